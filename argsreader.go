@@ -0,0 +1,67 @@
+package goenv
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// ArgsEnvReader is an environment variable reader that implements the EnvReader interface
+// by looking up values from command-line-style KEY=VALUE tokens, e.g. the arguments left
+// over after parsing flags, so that the same config can be overridden from the command
+// line. Later tokens take precedence over earlier tokens with the same key.
+type ArgsEnvReader struct {
+	values map[string]string
+}
+
+// NewArgsEnvReader creates a new instance of ArgsEnvReader from KEY=VALUE tokens, ignoring
+// tokens that are not of that form.
+func NewArgsEnvReader(args []string) *ArgsEnvReader {
+	env, _ := newArgsEnvReader(args, false)
+	return env
+}
+
+// NewStrictArgsEnvReader creates a new instance of ArgsEnvReader from KEY=VALUE tokens. It
+// returns an error if any of the tokens are not of that form.
+func NewStrictArgsEnvReader(args []string) (*ArgsEnvReader, error) {
+	return newArgsEnvReader(args, true)
+}
+
+func newArgsEnvReader(args []string, strict bool) (*ArgsEnvReader, error) {
+	values := map[string]string{}
+	for i, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			if strict {
+				return nil, errors.Errorf("argument %d (%s) is not of the form KEY=VALUE", i, arg)
+			}
+			continue
+		}
+		values[kv[0]] = kv[1]
+	}
+
+	return &ArgsEnvReader{
+		values: values,
+	}, nil
+}
+
+// LookupEnv - Looks up the value of a key from the arguments. Returns the value if the key
+// was given, and otherwise returns an unspecific value and false.
+func (env *ArgsEnvReader) LookupEnv(key string) (string, bool) {
+	val, ok := env.values[key]
+	return val, ok
+}
+
+// HasKeys - Returns whether or not a set of keys were given as arguments along with a list
+// of keys that were not.
+func (env *ArgsEnvReader) HasKeys(keys []string) (bool, []string) {
+	return hasKeys(env, keys)
+}
+
+// Keys - Returns the keys of all arguments.
+func (env *ArgsEnvReader) Keys() []string {
+	keys := make([]string, 0, len(env.values))
+	for key := range env.values {
+		keys = append(keys, key)
+	}
+	return keys
+}
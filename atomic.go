@@ -0,0 +1,95 @@
+package goenv
+
+import (
+	"reflect"
+	"sync/atomic"
+)
+
+// the types of the values stored by the typed values of sync/atomic, keyed by the typed
+// values. atomic.Values store strings.
+var atomicValueTypes = map[reflect.Type]reflect.Type{
+	reflect.TypeOf((*atomic.Bool)(nil)).Elem():   reflect.TypeOf(false),
+	reflect.TypeOf((*atomic.Int32)(nil)).Elem():  reflect.TypeOf(int32(0)),
+	reflect.TypeOf((*atomic.Int64)(nil)).Elem():  reflect.TypeOf(int64(0)),
+	reflect.TypeOf((*atomic.Uint32)(nil)).Elem(): reflect.TypeOf(uint32(0)),
+	reflect.TypeOf((*atomic.Uint64)(nil)).Elem(): reflect.TypeOf(uint64(0)),
+	reflect.TypeOf((*atomic.Value)(nil)).Elem():  stringType,
+}
+
+// Determines whether or not a type is one of the typed values of sync/atomic, e.g.
+// atomic.Int64, which are unmarshalled by storing the value they hold.
+func isAtomicType(t reflect.Type) bool {
+	_, ok := atomicValueTypes[t]
+	return ok
+}
+
+// Unmarshals a typed value of sync/atomic, e.g. atomic.Int64, by parsing the value it holds
+// like any other field and storing it. Missing optional values leave the field at its zero
+// value. The field is later stored into the destination by assignValue.
+func (marshaler *DefaultEnvMarshaler) unmarshalAtomic(
+	structFieldVal reflect.Value,
+	fieldEnvTag string,
+	opts *fieldOptions,
+	parser *DefaultParser,
+) error {
+	val, err := marshaler.unmarshalType(atomicValueTypes[structFieldVal.Type()], fieldEnvTag, opts, parser)
+	if err != nil || val == nil {
+		return err
+	}
+
+	structFieldVal.Addr().MethodByName("Store").Call([]reflect.Value{*val})
+	return nil
+}
+
+// Loads the value held by a typed value of sync/atomic, which is invalid if an atomic.Value
+// holds nothing.
+func loadAtomic(v reflect.Value) reflect.Value {
+	if !v.CanAddr() {
+		addressable := reflect.New(v.Type()).Elem()
+		addressable.Set(v)
+		v = addressable
+	}
+
+	loaded := v.Addr().MethodByName("Load").Call(nil)[0]
+	if loaded.Kind() == reflect.Interface {
+		return loaded.Elem()
+	}
+	return loaded
+}
+
+// Determines whether or not a struct type has typed values of sync/atomic among its fields,
+// including those of its nested structs that are not pointers.
+func hasAtomicFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		fieldType := t.Field(i).Type
+		if isAtomicType(fieldType) || fieldType.Kind() == reflect.Struct && hasAtomicFields(fieldType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Assigns an unmarshalled value src to dst. Structs with typed values of sync/atomic among
+// their fields are assigned field by field, storing the atomic values into those of dst
+// rather than overwriting them, so that they can be read concurrently while dst is
+// unmarshalled again. Their unexported fields are left untouched, and atomic.Values that
+// hold nothing in src are left as they are in dst.
+func assignValue(dst, src reflect.Value) {
+	t := dst.Type()
+	switch {
+	case isAtomicType(t):
+		if loaded := loadAtomic(src); loaded.IsValid() {
+			dst.Addr().MethodByName("Store").Call([]reflect.Value{loaded})
+		}
+
+	case t.Kind() == reflect.Struct && hasAtomicFields(t):
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" {
+				assignValue(dst.Field(i), src.Field(i))
+			}
+		}
+
+	default:
+		dst.Set(src)
+	}
+}
@@ -0,0 +1,134 @@
+package goenv
+
+import (
+	"reflect"
+)
+
+// BatchEnvReader is an interface for EnvReaders that can look up many keys at once, e.g.
+// readers backed by a remote store, for which a lookup per key is a round trip per key.
+// LookupEnvN returns the values of the keys that are set, omitting those that are missing.
+//
+// When the Environment implements BatchEnvReader, the DefaultEnvMarshaler looks up all of
+// the keys of a struct that are known ahead of time in a single call to LookupEnvN. Keys
+// that are discovered while unmarshalling, e.g. those of collected slices, are still looked
+// up with LookupEnv.
+type BatchEnvReader interface {
+	LookupEnvN(keys []string) map[string]string
+}
+
+// The values of keys prefetched from a BatchEnvReader, including the keys that are missing.
+type prefetchedEnv struct {
+	keys   map[string]bool
+	values map[string]string
+}
+
+// Looks up a key from the overrides of the UnmarshalWithOverrides in progress, if any, and
+// then from the environment, answering prefetched keys without consulting it. Empty values
+// are reported as missing if TreatEmptyAsAbsent is set.
+func (marshaler *DefaultEnvMarshaler) lookupRaw(key string) (string, bool) {
+	var envVal string
+	var hasVal bool
+	if overrideVal, ok := marshaler.lookupOverride(key); ok {
+		envVal, hasVal = overrideVal, true
+	} else if prefetched := marshaler.prefetched; prefetched != nil && prefetched.keys[key] {
+		envVal, hasVal = prefetched.values[key]
+	} else {
+		envVal, hasVal = marshaler.Environment.LookupEnv(key)
+	}
+
+	if marshaler.TreatEmptyAsAbsent && envVal == "" {
+		return "", false
+	}
+	return envVal, hasVal
+}
+
+// Prefetches the keys of a struct type, nested under envPrefix, if the environment
+// implements BatchEnvReader.
+func (marshaler *DefaultEnvMarshaler) prefetch(t reflect.Type, envPrefix string) {
+	batchReader, ok := marshaler.Environment.(BatchEnvReader)
+	if !ok || t.Kind() != reflect.Struct {
+		return
+	}
+
+	keys := marshaler.structKeys(t, envPrefix, 1, []string{})
+	prefetched := &prefetchedEnv{
+		keys:   make(map[string]bool, len(keys)),
+		values: batchReader.LookupEnvN(keys),
+	}
+	for _, key := range keys {
+		prefetched.keys[key] = true
+	}
+	marshaler.prefetched = prefetched
+}
+
+// Appends the keys that unmarshalling a struct type at depth, nested under envPrefix, may
+// look up to keys. Fields whose keys are discovered while unmarshalling are skipped, as are
+// fields with malformed tags, which fail when unmarshalled, and structs nested too deep.
+func (marshaler *DefaultEnvMarshaler) structKeys(t reflect.Type, envPrefix string, depth int, keys []string) []string {
+	if depth > marshaler.maxDepth() {
+		return keys
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		fieldStruct := t.Field(i)
+		if !marshaler.hasEnvKey(fieldStruct) {
+			continue
+		}
+
+		opts, err := marshaler.fieldOptions(fieldStruct, envPrefix)
+		if err != nil || opts.raw {
+			continue
+		}
+
+		if opts.enabledBy != "" {
+			keys = marshaler.appendKey(keys, opts.enabledBy)
+		}
+		if opts.typeFrom != "" {
+			keys = marshaler.appendKey(keys, opts.typeFrom)
+		}
+		if opts.template != "" {
+			for _, key := range templateKeys(opts.template) {
+				keys = marshaler.appendKey(keys, key)
+			}
+			continue
+		}
+
+		fieldEnvTag := opts.envKey(envPrefix)
+		fieldType := indirectType(fieldStruct.Type)
+		if opts.collect || marshaler.implementsUnmarshal(fieldType) || isDiscoveredMap(fieldType) {
+			continue
+		}
+		if isStructType(fieldType) && !opts.split {
+			keys = marshaler.structKeys(fieldType, fieldEnvTag, depth+1, keys)
+			continue
+		}
+
+		keys = marshaler.appendKey(keys, fieldEnvTag)
+		if opts.defaultFrom != "" {
+			keys = marshaler.appendKey(keys, opts.defaultFrom)
+		}
+	}
+	return keys
+}
+
+// Appends a normalized key, and its _FILE variant if FileIndirection is enabled, to keys.
+func (marshaler *DefaultEnvMarshaler) appendKey(keys []string, key string) []string {
+	key = marshaler.normalizeKey(key)
+	keys = append(keys, key)
+	if marshaler.FileIndirection {
+		keys = append(keys, key+FileIndirectionSuffix)
+	}
+	return keys
+}
+
+// Determines whether or not a type is a map or slice whose entries are discovered from
+// prefixed keys, i.e. a map of structs, a map of maps or a slice of structs.
+func isDiscoveredMap(t reflect.Type) bool {
+	if isStructSliceType(t) {
+		return true
+	}
+	if t.Kind() != reflect.Map {
+		return false
+	}
+	return t.Elem().Kind() == reflect.Map || isStructMapType(t)
+}
@@ -0,0 +1,115 @@
+package goenv
+
+import (
+	"github.com/pkg/errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// BitValuer is implemented by integer types that are bitmasks of named bits, so that they
+// are parsed from separated bit names, e.g. FLAGS=read,write,exec, whose values are ORed
+// together rather than from the numeric mask:
+//
+//	type Perm int
+//
+//	func (Perm) BitValues() map[string]int {
+//		return map[string]int{"read": 4, "write": 2, "exec": 1}
+//	}
+//
+// Unknown names are rejected.
+type BitValuer interface {
+	BitValues() map[string]int
+}
+
+var bitValuerType = reflect.TypeOf((*BitValuer)(nil)).Elem()
+
+// Determines whether or not a type is an integer bitmask, i.e. an integer type
+// implementing BitValuer.
+func isBitmaskType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		return reflect.PtrTo(t).Implements(bitValuerType)
+	}
+	return false
+}
+
+// Returns the values of the named bits of a bitmask type.
+func bitValues(t reflect.Type) map[string]int {
+	return reflect.New(t).Interface().(BitValuer).BitValues()
+}
+
+// Returns the names of the bits of a bitmask type in sorted order.
+func bitNames(bits map[string]int) []string {
+	names := make([]string, 0, len(bits))
+	for name := range bits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parses separated bit names into a bitmask, ORing together the values of the names.
+// Empty names are ignored, so that an empty string is parsed as an empty mask.
+func (marshaler *DefaultParser) parseBits(str string, dst reflect.Value) error {
+	t := dst.Type()
+	bits := bitValues(t)
+
+	var mask int64
+	for _, name := range strings.Split(str, marshaler.sliceSeparator()) {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		bit, ok := bits[name]
+		if !ok {
+			return errors.Errorf(
+				"Cannot convert %s to %s: unknown bit %s, expected one of %s",
+				str, t, name, strings.Join(bitNames(bits), ", "))
+		}
+		mask |= int64(bit)
+	}
+
+	switch t.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		if mask < 0 || dst.OverflowUint(uint64(mask)) {
+			return errors.Errorf("Cannot convert %s to %s: the mask %d overflows it", str, t, mask)
+		}
+		dst.SetUint(uint64(mask))
+	default:
+		if dst.OverflowInt(mask) {
+			return errors.Errorf("Cannot convert %s to %s: the mask %d overflows it", str, t, mask)
+		}
+		dst.SetInt(mask)
+	}
+	return nil
+}
+
+// Formats a bitmask as the separated names of its bits, in sorted order. It returns an
+// error if the mask has bits that no name covers.
+func (marshaler *DefaultParser) formatBits(v reflect.Value) (string, error) {
+	var mask int64
+	switch v.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		mask = int64(v.Uint())
+	default:
+		mask = v.Int()
+	}
+
+	bits := bitValues(v.Type())
+	names := []string{}
+	var covered int64
+	for _, name := range bitNames(bits) {
+		bit := int64(bits[name])
+		if bit != 0 && mask&bit == bit {
+			names = append(names, name)
+			covered |= bit
+		}
+	}
+	if covered != mask {
+		return "", errors.Errorf("Cannot marshal %d as the named bits of %s", mask, v.Type())
+	}
+	return strings.Join(names, marshaler.sliceSeparator()), nil
+}
@@ -0,0 +1,50 @@
+package goenv
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// UnmarshalBlob - Unmarshals a given value from a JSON object held by a single environment
+// variable, for platforms that limit the number of variables. The object may be
+// base64-encoded, in which case it is decoded first: values that do not start with { are
+// decoded as standard base64, with or without padding. The JSON is unmarshalled by
+// json.Unmarshal, i.e. according to the json tags of the struct rather than its env tags.
+//
+// Usage:
+//
+//	// CONFIG=eyJwb3J0Ijo4MDgwfQ== or CONFIG={"port":8080}
+//	err := unmarshaller.UnmarshalBlob("CONFIG", &config)
+func (marshaler *DefaultEnvMarshaler) UnmarshalBlob(key string, i interface{}) error {
+	key = marshaler.normalizeKey(key)
+	envVal, hasVal, err := marshaler.lookupEnv(key)
+	if err != nil {
+		return err
+	}
+	if !hasVal {
+		return errors.WithStack(&MissingKeyError{Key: key})
+	}
+
+	blob := []byte(strings.TrimSpace(envVal))
+	if !strings.HasPrefix(string(blob), "{") {
+		blob, err = decodeBase64(string(blob))
+		if err != nil {
+			return errors.Wrapf(err, "cannot decode %s as base64", key)
+		}
+	}
+
+	if err := json.Unmarshal(blob, i); err != nil {
+		return errors.Wrapf(err, "cannot unmarshal %s as JSON", key)
+	}
+	return nil
+}
+
+// Decodes standard base64, with or without padding.
+func decodeBase64(str string) ([]byte, error) {
+	if strings.HasSuffix(str, "=") {
+		return base64.StdEncoding.DecodeString(str)
+	}
+	return base64.RawStdEncoding.DecodeString(str)
+}
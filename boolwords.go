@@ -0,0 +1,81 @@
+package goenv
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// BoolWords - Spellings of true and false, e.g. in a language other than English, that can
+// be registered with RegisterBoolWords.
+type BoolWords struct {
+	True  []string
+	False []string
+}
+
+var (
+	// EnglishBoolWords - yes/no and on/off
+	EnglishBoolWords = BoolWords{
+		True:  []string{"yes", "y", "on"},
+		False: []string{"no", "n", "off"},
+	}
+
+	// FrenchBoolWords - oui/non and vrai/faux
+	FrenchBoolWords = BoolWords{
+		True:  []string{"oui", "vrai"},
+		False: []string{"non", "faux"},
+	}
+
+	// GermanBoolWords - ja/nein and wahr/falsch
+	GermanBoolWords = BoolWords{
+		True:  []string{"ja", "wahr"},
+		False: []string{"nein", "falsch"},
+	}
+
+	// SpanishBoolWords - sí/no and verdadero/falso
+	SpanishBoolWords = BoolWords{
+		True:  []string{"sí", "si", "verdadero"},
+		False: []string{"no", "falso"},
+	}
+)
+
+var boolWords = struct {
+	sync.RWMutex
+	values map[string]bool
+}{values: map[string]bool{}}
+
+// RegisterBoolWords - Registers spellings of true and false, which are accepted, regardless
+// of case, wherever booleans are parsed, in addition to those accepted by strconv.ParseBool.
+// A word registered again takes the value it was last registered with.
+//
+// Usage:
+//
+//	goenv.RegisterBoolWords(goenv.FrenchBoolWords.True, goenv.FrenchBoolWords.False)
+func RegisterBoolWords(trueWords, falseWords []string) {
+	boolWords.Lock()
+	defer boolWords.Unlock()
+
+	for _, word := range trueWords {
+		boolWords.values[strings.ToLower(word)] = true
+	}
+	for _, word := range falseWords {
+		boolWords.values[strings.ToLower(word)] = false
+	}
+}
+
+// Parses a boolean value, regardless of case, via strconv.ParseBool or the registered bool
+// words. It returns the error from strconv.ParseBool if the value is neither.
+func parseBool(str string) (bool, error) {
+	str = strings.ToLower(str)
+	b, err := strconv.ParseBool(str)
+	if err == nil {
+		return b, nil
+	}
+
+	boolWords.RLock()
+	defer boolWords.RUnlock()
+	if b, ok := boolWords.values[str]; ok {
+		return b, nil
+	}
+	return false, err
+}
@@ -0,0 +1,56 @@
+package goenv
+
+// ChainedEnvReader is an environment variable reader that implements the EnvReader interface
+// by looking up values from a list of readers in order, so that earlier readers override
+// later ones, e.g. command-line arguments overriding environment variables:
+//
+//	env := NewChainedEnvReader(NewArgsEnvReader(flag.Args()), NewOsEnvReader())
+type ChainedEnvReader struct {
+	readers []EnvReader
+}
+
+// NewChainedEnvReader creates a new instance of ChainedEnvReader from readers in order of
+// precedence.
+func NewChainedEnvReader(readers ...EnvReader) *ChainedEnvReader {
+	return &ChainedEnvReader{
+		readers: readers,
+	}
+}
+
+// LookupEnv - Looks up the value of a key from the first reader that has it. Returns an
+// unspecific value and false if none of the readers have it.
+func (env *ChainedEnvReader) LookupEnv(key string) (string, bool) {
+	for _, reader := range env.readers {
+		if val, ok := reader.LookupEnv(key); ok {
+			return val, true
+		}
+	}
+	return "", false
+}
+
+// HasKeys - Returns whether or not a set of keys have values in any of the readers along
+// with a list of keys that do not.
+func (env *ChainedEnvReader) HasKeys(keys []string) (bool, []string) {
+	return hasKeys(env, keys)
+}
+
+// Keys - Returns the keys of all readers, without duplicates. Readers that do not implement
+// EnvEnumerator contribute no keys.
+func (env *ChainedEnvReader) Keys() []string {
+	keys := []string{}
+	seen := map[string]bool{}
+	for _, reader := range env.readers {
+		enumerator, ok := reader.(EnvEnumerator)
+		if !ok {
+			continue
+		}
+
+		for _, key := range enumerator.Keys() {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
@@ -0,0 +1,27 @@
+package goenv
+
+// Diff - Compares the values of a set of keys in two environments, e.g. the environment of a
+// running process against a config file, to detect drift. It returns the pair of values of
+// each key whose values differ between a and b, where a nil value denotes a key that is
+// missing from that environment. Keys that are missing from both are not reported.
+func Diff(a, b EnvReader, keys []string) map[string][2]*string {
+	diff := map[string][2]*string{}
+	for _, key := range keys {
+		aVal, inA := a.LookupEnv(key)
+		bVal, inB := b.LookupEnv(key)
+		if inA == inB && aVal == bVal {
+			continue
+		}
+
+		pair := [2]*string{}
+		if inA {
+			pair[0] = &aVal
+		}
+		if inB {
+			pair[1] = &bVal
+		}
+		diff[key] = pair
+	}
+
+	return diff
+}
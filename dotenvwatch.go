@@ -0,0 +1,91 @@
+package goenv
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WatchDotEnv - Watches a .env file of KEY=VALUE lines, e.g. a file mounted from a config
+// map, by polling it every interval, and calls onChange with a fresh reader of its values
+// whenever its contents change. Blank lines, lines starting with # and an export prefix
+// are ignored, and values enclosed in matching single or double quotes, e.g. KEY="a b",
+// are unquoted. It returns an error if the interval is not positive or the file cannot be
+// read initially, and otherwise a func that stops watching. Polling rather than subscribing to file system events keeps
+// the package free of dependencies.
+//
+// Usage:
+//
+//	stop, err := goenv.WatchDotEnv("/etc/app/.env", 5*time.Second, func(env goenv.EnvReader) {
+//		config := Config{}
+//		if err := (&goenv.DefaultEnvMarshaler{Environment: env}).Unmarshal(&config); err == nil {
+//			reload(config)
+//		}
+//	})
+func WatchDotEnv(path string, interval time.Duration, onChange func(EnvReader)) (func(), error) {
+	if interval <= 0 {
+		return nil, errors.Errorf("cannot watch %s every %s: expected a positive interval", path, interval)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot watch %s", path)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			// the file may be missing while it is being replaced, so try again later
+			latest, err := ioutil.ReadFile(path)
+			if err != nil || bytes.Equal(latest, contents) {
+				continue
+			}
+			contents = latest
+			onChange(parseDotEnv(contents))
+		}
+	}()
+
+	var stopOnce sync.Once
+	return func() {
+		stopOnce.Do(func() { close(done) })
+	}, nil
+}
+
+// Parses the contents of a .env file into a reader of its KEY=VALUE lines.
+func parseDotEnv(contents []byte) *ArgsEnvReader {
+	args := []string{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args = append(args, unquoteDotEnv(strings.TrimPrefix(line, "export ")))
+	}
+	return NewArgsEnvReader(args)
+}
+
+// Strips the matching single or double quotes enclosing the value of a KEY=VALUE line.
+func unquoteDotEnv(line string) string {
+	keyVal := strings.SplitN(line, "=", 2)
+	if len(keyVal) < 2 {
+		return line
+	}
+
+	val := keyVal[1]
+	if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+		val = val[1 : len(val)-1]
+	}
+	return keyVal[0] + "=" + val
+}
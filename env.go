@@ -32,9 +32,16 @@
 package goenv
 
 import (
+	"fmt"
 	"github.com/pkg/errors"
+	"io/ioutil"
 	"os"
 	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
 )
 
 // EnvReader is an interface for expressing the ability to look up values from the environment
@@ -55,108 +62,1281 @@ type EnvReader interface {
 // OsEnvReader is an environment variable reader that implements that EnvReader interface by using the
 // os.LookupEnv method.
 type OsEnvReader struct {
-	lookup func(key string) (string, bool)
+	lookup  func(key string) (string, bool)
+	environ func() []string
+
+	// whether keys are matched regardless of case, as they are on Windows
+	caseInsensitive bool
 }
 
 // NewOsEnvReader creates a new instance of OsEnvReader
 func NewOsEnvReader() *OsEnvReader {
 	return &OsEnvReader{
-		lookup: os.LookupEnv,
+		lookup:  os.LookupEnv,
+		environ: os.Environ,
+	}
+}
+
+// NewPlatformOsEnvReader creates a new instance of OsEnvReader that follows the conventions
+// of the platform: on Windows, where the names of environment variables are
+// case-insensitive, e.g. Path and PATH are the same variable, keys are matched regardless
+// of case; elsewhere, keys are matched exactly.
+func NewPlatformOsEnvReader() *OsEnvReader {
+	env := NewOsEnvReader()
+	env.caseInsensitive = runtime.GOOS == "windows"
+	return env
+}
+
+// LookupEnv - Lookup a certain environment variable by name. Returns the value of the
+// environment variable if the variable exists and has an assigned value. Otherwise,
+// returns an unspecific value, and the exists flag is set to false.
+func (env *OsEnvReader) LookupEnv(key string) (string, bool) {
+	if envVal, hasVal := env.lookup(key); hasVal || !env.caseInsensitive {
+		return envVal, hasVal
+	}
+
+	environ := env.environ
+	if environ == nil {
+		environ = os.Environ
+	}
+
+	// fall back to the first variable whose key matches regardless of case
+	for _, keyVal := range environ() {
+		kv := strings.SplitN(keyVal, "=", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], key) {
+			return kv[1], true
+		}
+	}
+	return "", false
+}
+
+// HasKeys - Returns whether or not a set of environment variables have corresponding
+// values along with a list of environment variables that do not have values.
+func (env *OsEnvReader) HasKeys(keys []string) (bool, []string) {
+	return hasKeys(env, keys)
+}
+
+// Returns whether or not a reader has values for all of the given keys along
+// with the keys that are missing, by looking up each of the keys in turn.
+func hasKeys(env EnvReader, keys []string) (bool, []string) {
+	missingKeys := []string{}
+	for _, key := range keys {
+		if _, ok := env.LookupEnv(key); !ok {
+			missingKeys = append(missingKeys, key)
+		}
+	}
+
+	return len(missingKeys) == 0, missingKeys
+}
+
+// EnvEnumerator is an interface for EnvReaders that can list the keys of all environment
+// variables they hold. Features that discover keys, such as collecting all variables
+// sharing a prefix, require the EnvReader to implement EnvEnumerator.
+type EnvEnumerator interface {
+	Keys() []string
+}
+
+// Keys - Returns the keys of all environment variables in the environment.
+func (env *OsEnvReader) Keys() []string {
+	environ := env.environ
+	if environ == nil {
+		environ = os.Environ
+	}
+
+	keys := []string{}
+	for _, keyVal := range environ() {
+		keys = append(keys, strings.SplitN(keyVal, "=", 2)[0])
+	}
+	return keys
+}
+
+// PrefixEnumerator is an interface for EnvReaders that can list the keys of the environment
+// variables sharing a prefix directly, without listing all keys first. Features that
+// discover keys use it in preference to EnvEnumerator.
+type PrefixEnumerator interface {
+	KeysWithPrefix(prefix string) []string
+}
+
+// KeysWithPrefix - Returns the keys of the environment variables that start with a prefix,
+// scanning the environment once. The prefix matches regardless of case if the reader is
+// case-insensitive.
+func (env *OsEnvReader) KeysWithPrefix(prefix string) []string {
+	environ := env.environ
+	if environ == nil {
+		environ = os.Environ
+	}
+
+	keys := []string{}
+	for _, keyVal := range environ() {
+		key := strings.SplitN(keyVal, "=", 2)[0]
+		if len(key) < len(prefix) {
+			continue
+		}
+		if key[:len(prefix)] == prefix || env.caseInsensitive && strings.EqualFold(key[:len(prefix)], prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// EnvUnmarshaler is an interface for any object that defines the UnmarshalEnv method, i.e. a
+// method that accepts an EnvReader and can unmarshal from environment variable
+// values from the EnvReader
+type EnvUnmarshaler interface {
+	UnmarshalEnv(EnvReader) error
+}
+
+// Marshaler - An interface for any object that implements the Unmarshal method.
+type Marshaler interface {
+	Unmarshal(interface{}) error
+}
+
+// Logger is an interface for logging the warnings of a DefaultEnvMarshaler. It is satisfied
+// by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// DefaultEnvMarshaler - An unmarshaller that uses the DefaultParser and a specific environment reader
+// to unmarshal primitive and derived values.
+type DefaultEnvMarshaler struct {
+	Environment EnvReader
+
+	// EnvironmentName, if set, names the environment the config is for, e.g. prod, and
+	// selects the default of each field from its tag for that environment, e.g.
+	// defaultProd, in preference to its default tag.
+	EnvironmentName string
+
+	// Parser parses the values of individual fields. If nil, a zero-valued
+	// DefaultParser is used.
+	Parser *DefaultParser
+
+	// KeyNormalizer, if set, transforms every key (including its prefixes) before
+	// it is looked up from the environment, e.g. NormalizeUpperSnake.
+	KeyNormalizer func(string) string
+
+	// TreatEmptyAsAbsent, if set, treats variables that are set to an empty string exactly
+	// like missing ones, so that their fields fall back to their defaults or, if required,
+	// fail. By default, an empty value is parsed like any other.
+	TreatEmptyAsAbsent bool
+
+	// FileIndirection, if set, reads the value of a missing variable, e.g. DB_PASSWORD,
+	// from the file named by its _FILE variant, e.g. DB_PASSWORD_FILE, as is commonly
+	// done for Docker and systemd secrets.
+	FileIndirection bool
+
+	// FileConflict sets how FileIndirection reads a variable when both it and its _FILE
+	// variant are set. By default, i.e. FileConflictError, it fails.
+	FileConflict FileConflictPolicy
+
+	// OnField, if set, is called after each field is unmarshalled with the field's path
+	// from the root struct, e.g. Database.Port, its key, its raw value and the error, if
+	// any, from unmarshalling it. Struct fields are reported after their own fields, and
+	// with an empty raw value, as are the values of secret fields.
+	OnField func(path, key, rawValue string, err error)
+
+	// TypeFactories, if set, maps the keys named by typeFrom tags, e.g. BACKEND_TYPE, to
+	// factories of the concrete values of interface fields by the values of those keys,
+	// e.g. redis. The concrete value is then unmarshalled like any other field.
+	TypeFactories map[string]map[string]func() interface{}
+
+	// TypeNamePrefixes, if set, unmarshals untagged struct fields, and pointers to structs,
+	// with a prefix derived from the name of their type in upper snake case, e.g.
+	// DATABASE_CONFIG_ for a DatabaseConfig. Embedded structs are promoted regardless.
+	TypeNamePrefixes bool
+
+	// UseFieldNames, if set, unmarshals untagged exported fields with keys derived from
+	// their names in upper snake case, e.g. MAX_CONNS for MaxConns, or prefixes, e.g.
+	// DATABASE_ for a struct field named Database. TypeNamePrefixes takes precedence.
+	UseFieldNames bool
+
+	// SkipOnParseError, if set, skips fields whose values cannot be parsed, logging a
+	// warning to the Logger rather than failing. Skipped fields fall back to their
+	// default tags, if any, and are otherwise left unset. Required fields still fail.
+	SkipOnParseError bool
+
+	// Logger, if set, logs warnings, e.g. about fields skipped by SkipOnParseError.
+	Logger Logger
+
+	// MaxDepth, if set, is the depth to which structs may be nested, e.g. by self-referential
+	// types such as type Node struct { Next *Node }, beyond which Unmarshal fails rather
+	// than recursing further. It is DefaultMaxDepth otherwise.
+	MaxDepth int
+
+	// the depth of the struct being unmarshalled, with the root struct at depth 1
+	depth int
+
+	// the paths of the fields that fell back to their default tags in the last Unmarshal
+	defaulted []string
+
+	// the values prefetched from a BatchEnvReader for the Unmarshal in progress, if any
+	prefetched *prefetchedEnv
+
+	// the result of the UnmarshalWithResult in progress, if any
+	result *UnmarshalResult
+
+	// the schema, keyed by normalized keys, of the UnmarshalWithSchema in progress, if any
+	schema map[string]FieldSpec
+
+	// the values, keyed by normalized keys, of the UnmarshalWithOverrides in progress, if
+	// any, which take precedence over those of the Environment
+	overrides map[string]string
+
+	// the maps of the raw fields of the structs being unmarshalled, innermost last, which
+	// receive the raw values of the keys consumed by their structs
+	rawValues []map[string]string
+}
+
+// NormalizeUpperSnake - Normalizes a key by upper-casing it and replacing dots and dashes
+// with underscores, so that a field tagged with `env:"db.host"` reads DB_HOST.
+func NormalizeUpperSnake(key string) string {
+	return strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// Logs a warning to the Logger, if any.
+func (marshaler *DefaultEnvMarshaler) warnf(format string, v ...interface{}) {
+	if marshaler.Logger != nil {
+		marshaler.Logger.Printf(format, v...)
+	}
+}
+
+// Logs a warning to the Logger, if any, and records it in the result, if any.
+func (marshaler *DefaultEnvMarshaler) warn(kind WarningKind, key string, format string, v ...interface{}) {
+	marshaler.warnf(format, v...)
+	marshaler.result.recordWarning(kind, key, fmt.Sprintf(format, v...))
+}
+
+// Normalizes a key using the KeyNormalizer, if any.
+func (marshaler *DefaultEnvMarshaler) normalizeKey(key string) string {
+	if marshaler.KeyNormalizer == nil {
+		return key
+	}
+	return marshaler.KeyNormalizer(key)
+}
+
+// Returns the parser used to parse field values.
+func (marshaler *DefaultEnvMarshaler) parser() *DefaultParser {
+	if marshaler.Parser == nil {
+		return &DefaultParser{}
+	}
+	return marshaler.Parser
+}
+
+// Determines whether or not a specific object type (represented as reflect.Type)
+// implements the EnvUnMarshaler interface.
+func (marshaler *DefaultEnvMarshaler) implementsUnmarshal(t reflect.Type) bool {
+	return reflect.PtrTo(t).Implements(envUnmarshalerType)
+}
+
+var envUnmarshalerType = reflect.TypeOf((*EnvUnmarshaler)(nil)).Elem()
+
+// prefixedEnvReader is a view of an EnvReader in which every key is prefixed, so that
+// EnvUnmarshaler fields can look up keys relative to their env tag.
+type prefixedEnvReader struct {
+	env    EnvReader
+	prefix string
+}
+
+func (env *prefixedEnvReader) LookupEnv(key string) (string, bool) {
+	return env.env.LookupEnv(env.prefix + key)
+}
+
+func (env *prefixedEnvReader) HasKeys(keys []string) (bool, []string) {
+	return hasKeys(env, keys)
+}
+
+// Unmarshals a field whose type implements EnvUnmarshaler by calling its UnmarshalEnv method
+// with a view of the environment in which keys are prefixed with the field's env tag.
+func (marshaler *DefaultEnvMarshaler) unmarshalEnvUnmarshaler(
+	fieldType reflect.Type,
+	fieldEnvTag string,
+) (*reflect.Value, error) {
+	ptrVal := reflect.New(fieldType)
+	envUnmarsh := ptrVal.Interface().(EnvUnmarshaler)
+	err := envUnmarsh.UnmarshalEnv(&prefixedEnvReader{
+		env:    marshaler.Environment,
+		prefix: marshaler.normalizeKey(fieldEnvTag),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot unmarshal %s to type %s", fieldEnvTag, fieldType.Name())
+	}
+
+	fieldVal := ptrVal.Elem()
+	return &fieldVal, nil
+}
+
+// DefaultMaxDepth is the depth to which structs may be nested when the marshaler does not
+// specify one with MaxDepth.
+const DefaultMaxDepth = 32
+
+// FileIndirectionSuffix is the suffix of the environment variable holding the path of a
+// file whose contents are the value of another variable, e.g. DB_PASSWORD_FILE for
+// DB_PASSWORD.
+const FileIndirectionSuffix = "_FILE"
+
+// FileConflictPolicy - How FileIndirection reads a variable, e.g. DB_PASSWORD, when both it
+// and its _FILE variant, e.g. DB_PASSWORD_FILE, are set.
+type FileConflictPolicy int
+
+const (
+	// FileConflictError fails to read the variable, and is the default.
+	FileConflictError FileConflictPolicy = iota
+
+	// FileConflictPreferValue reads the value of the variable itself.
+	FileConflictPreferValue
+
+	// FileConflictPreferFile reads the file named by the _FILE variant.
+	FileConflictPreferFile
+)
+
+// Looks up the value of a key from the environment. If FileIndirection is enabled, the value
+// is read from the file named by the key's _FILE variant, less a trailing newline, if the
+// key is missing, or if both are set and the FileConflict policy prefers the file. It
+// returns an error if that file cannot be read, or if both are set and the policy is
+// FileConflictError.
+func (marshaler *DefaultEnvMarshaler) lookupEnv(key string) (string, bool, error) {
+	envVal, hasVal := marshaler.lookupRaw(key)
+	if !marshaler.FileIndirection {
+		return envVal, hasVal, nil
+	}
+
+	fileKey := key + FileIndirectionSuffix
+	path, hasPath := marshaler.lookupRaw(fileKey)
+	if !hasPath {
+		return envVal, hasVal, nil
+	}
+	if hasVal {
+		switch marshaler.FileConflict {
+		case FileConflictPreferValue:
+			return envVal, true, nil
+		case FileConflictPreferFile:
+		default:
+			return "", false, errors.Errorf("cannot read %s: both %s and %s are set", key, key, fileKey)
+		}
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false, errors.Wrapf(err, "cannot read value of %s from file (Env: %s)", key, fileKey)
+	}
+
+	envVal = strings.TrimSuffix(string(contents), "\n")
+	return strings.TrimSuffix(envVal, "\r"), true, nil
+}
+
+// Looks up the raw value for a field from the environment. If the key is missing, the
+// value falls back to that of the key named by the field's defaultFrom tag (sharing
+// the field's prefix), and then to the literal value of the field's default tag, see
+// Unmarshal. It sets the source of the field's value.
+func (marshaler *DefaultEnvMarshaler) lookupValue(fieldEnvTag string, opts *fieldOptions) (string, bool, error) {
+	if envVal, hasVal, err := marshaler.lookupEnv(fieldEnvTag); hasVal || err != nil {
+		opts.resolvedKey = fieldEnvTag
+		opts.source = SourceEnv
+		return opts.envValue(envVal), hasVal, err
+	}
+
+	if opts.defaultFrom != "" {
+		defaultFrom := marshaler.normalizeKey(opts.defaultFrom)
+		if envVal, hasVal, err := marshaler.lookupEnv(defaultFrom); hasVal || err != nil {
+			opts.resolvedKey = defaultFrom
+			opts.source = SourceDefaultFrom
+			return opts.envValue(envVal), hasVal, err
+		}
+	}
+
+	if opts.hasDefault && !opts.required {
+		opts.defaulted = true
+		opts.source = opts.defaultSource()
+		return opts.defaultValue, true, nil
+	}
+
+	opts.source = SourceZero
+	return "", false, nil
+}
+
+// Returns the value of a field read from the environment, stripped of any trailing comment
+// if the field has the stripcomment option.
+func (opts *fieldOptions) envValue(envVal string) string {
+	if !opts.stripComment {
+		return envVal
+	}
+	return stripComment(envVal)
+}
+
+// Strips a trailing comment from a value, i.e. from the first # surrounded by whitespace,
+// or preceded by whitespace at the end of the value, along with the whitespace before it,
+// e.g. "db.local # the primary" is stripped to "db.local". A # that is part of the value,
+// e.g. in "#fff" or "a#b", is kept.
+func stripComment(str string) string {
+	for i := 1; i < len(str); i++ {
+		if str[i] != '#' || !isCommentSpace(str[i-1]) {
+			continue
+		}
+		if i == len(str)-1 || isCommentSpace(str[i+1]) {
+			return strings.TrimRight(str[:i], " \t")
+		}
+	}
+	return str
+}
+
+// Determines whether or not a byte is whitespace that may surround the # of a comment.
+func isCommentSpace(b byte) bool {
+	return b == ' ' || b == '\t'
+}
+
+// Checks that none of the keys named by a field's conflictsWith tag, if any, are set along
+// with the field's own key, returning an error naming both keys otherwise.
+func (marshaler *DefaultEnvMarshaler) checkConflicts(fieldEnvTag string, opts *fieldOptions) error {
+	if len(opts.conflictsWith) == 0 {
+		return nil
+	}
+
+	fieldEnvTag = marshaler.normalizeKey(fieldEnvTag)
+	if _, hasVal, err := marshaler.lookupEnv(fieldEnvTag); err != nil || !hasVal {
+		return err
+	}
+	for _, conflict := range opts.conflictsWith {
+		conflict = marshaler.normalizeKey(conflict)
+		_, hasVal, err := marshaler.lookupEnv(conflict)
+		if err != nil {
+			return err
+		}
+		if hasVal {
+			return errors.Errorf("environment vars %s and %s are mutually exclusive: set only one of them", fieldEnvTag, conflict)
+		}
+	}
+	return nil
+}
+
+// Determines whether or not a field is enabled by the key named by its enabledBy tag, if
+// any. A field is disabled if the key is missing or false, and it returns an error if the
+// key is not a boolean.
+func (marshaler *DefaultEnvMarshaler) fieldEnabled(opts *fieldOptions) (bool, error) {
+	if opts.enabledBy == "" {
+		return true, nil
+	}
+
+	enabledBy := marshaler.normalizeKey(opts.enabledBy)
+	envVal, hasVal, err := marshaler.lookupEnv(enabledBy)
+	if err != nil || !hasVal {
+		return false, err
+	}
+
+	enabled, err := parseBool(strings.TrimSpace(envVal))
+	if err != nil {
+		return false, errors.Wrapf(err, "cannot convert %s to a boolean value (Env: %s)", envVal, enabledBy)
+	}
+	return enabled, nil
+}
+
+func (marshaler *DefaultEnvMarshaler) unmarshalType(
+	fieldType reflect.Type,
+	fieldEnvTag string,
+	opts *fieldOptions,
+	parser *DefaultParser,
+) (*reflect.Value, error) {
+	fieldEnvTag = marshaler.normalizeKey(fieldEnvTag)
+	envVal, hasVal, err := marshaler.lookupValue(fieldEnvTag, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !hasVal {
+		if opts.optional && !opts.required {
+			return nil, nil
+		}
+		return nil, errors.WithStack(&MissingKeyError{Key: fieldEnvTag})
+	}
+
+	opts.rawValue = envVal
+	fieldVal, parseErr := parser.ParseType(envVal, fieldType)
+	if parseErr != nil {
+		err := parseError(parseErr, envVal, fieldType, fieldEnvTag, opts)
+		if !marshaler.SkipOnParseError || opts.required {
+			return nil, err
+		}
+		return marshaler.skipField(fieldType, opts, parser, err)
+	}
+
+	return &fieldVal, nil
+}
+
+// Skips a field whose value cannot be parsed, logging the parse error as a warning. The
+// field falls back to its default tag if its value wasn't already the default. Otherwise
+// it returns a nil value, and the field is left unset.
+func (marshaler *DefaultEnvMarshaler) skipField(
+	fieldType reflect.Type,
+	opts *fieldOptions,
+	parser *DefaultParser,
+	parseErr error,
+) (*reflect.Value, error) {
+	marshaler.warn(WarningSkipped, opts.resolvedKey, "skipping field %s: %s", opts.path, parseErr)
+	opts.skipped = true
+	if !opts.hasDefault || opts.defaulted {
+		opts.source = SourceZero
+		return nil, nil
+	}
+
+	fieldVal, err := parser.ParseType(opts.defaultValue, fieldType)
+	if err != nil {
+		opts.source = SourceZero
+		return nil, nil
+	}
+	opts.rawValue = opts.defaultValue
+	opts.resolvedKey = ""
+	opts.defaulted = true
+	opts.source = opts.defaultSource()
+	return &fieldVal, nil
+}
+
+// MissingKeyError - The error of a variable that is missing from the environment, and has
+// no default, so that callers can tell missing variables apart from invalid ones, e.g.
+//
+//	var missing *goenv.MissingKeyError
+//	if errors.As(err, &missing) {
+//		log.Fatalf("please set %s", missing.Key)
+//	}
+type MissingKeyError struct {
+	// Key is the normalized key of the missing variable.
+	Key string
+}
+
+func (err *MissingKeyError) Error() string {
+	return fmt.Sprintf("cannot retrieve any value from environment var %s", err.Key)
+}
+
+// RedactedValue replaces the values of secret fields in error messages.
+const RedactedValue = "****"
+
+// redactedError hides the message of its cause, which may contain a secret value, while
+// keeping the cause available via errors.Cause and errors.As.
+type redactedError struct {
+	msg   string
+	cause error
+}
+
+func (err *redactedError) Error() string { return err.msg }
+func (err *redactedError) Cause() error  { return err.cause }
+func (err *redactedError) Unwrap() error { return err.cause }
+
+// Wraps an error parsing the value of a key. The value, and the message of the cause which may
+// contain it, are redacted for secret fields.
+func parseError(parseErr error, envVal string, t reflect.Type, key string, opts *fieldOptions) error {
+	if opts.secret {
+		return &redactedError{
+			msg: fmt.Sprintf(
+				"cannot unmarshal %s to type %s (Env: %s)",
+				RedactedValue,
+				t.Name(),
+				key,
+			),
+			cause: parseErr,
+		}
+	}
+
+	return errors.Wrapf(parseErr,
+		"cannot unmarshal %s to type %s (Env: %s)",
+		envVal,
+		t.Name(),
+		key,
+	)
+}
+
+func (marshaler *DefaultEnvMarshaler) unmarshalNonPtr(
+	fieldType reflect.Type,
+	fieldEnvTag string,
+	opts *fieldOptions,
+	parser *DefaultParser,
+) (*reflect.Value, error) {
+	if fieldType == timeType {
+		return marshaler.unmarshalType(fieldType, fieldEnvTag, opts, parser)
+	}
+
+	if opts.collect {
+		return marshaler.collectValues(fieldType, fieldEnvTag, opts, parser)
+	}
+
+	if marshaler.implementsUnmarshal(fieldType) {
+		return marshaler.unmarshalEnvUnmarshaler(fieldType, fieldEnvTag)
+	}
+
+	if isStructMapType(fieldType) {
+		return marshaler.unmarshalStructMap(fieldType, fieldEnvTag, opts.path, parser)
+	}
+
+	if fieldType.Kind() == reflect.Map && fieldType.Elem().Kind() == reflect.Map {
+		return marshaler.unmarshalNestedMap(fieldType, fieldEnvTag, opts, parser)
+	}
+
+	if isStructSliceType(fieldType) && !opts.isRecords() {
+		return marshaler.unmarshalStructSlice(fieldType, fieldEnvTag, opts)
+	}
+
+	if opts.split && isStructType(fieldType) {
+		return marshaler.unmarshalSplitStruct(fieldType, fieldEnvTag, opts, parser)
+	}
+
+	if isStructType(fieldType) {
+		fieldVal, err := marshaler.unmarshalStruct(fieldType, fieldEnvTag, opts.path)
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"cannot unmarshal %s to type %s",
+				fieldEnvTag,
+				fieldType.Name(),
+			)
+		}
+		return &fieldVal, nil
+	}
+
+	return marshaler.unmarshalType(fieldType, fieldEnvTag, opts, parser)
+}
+
+// Unmarshals a struct with the split option from the key=value entries of a single value,
+// e.g. DB=host=localhost;port=5432. Each entry sets the field whose env tag matches its key
+// regardless of case, as if the entries were the environment of the struct, so that the
+// fields' defaults, requirements and options still apply.
+func (marshaler *DefaultEnvMarshaler) unmarshalSplitStruct(
+	fieldType reflect.Type,
+	fieldEnvTag string,
+	opts *fieldOptions,
+	parser *DefaultParser,
+) (*reflect.Value, error) {
+	fieldEnvTag = marshaler.normalizeKey(fieldEnvTag)
+	envVal, hasVal, err := marshaler.lookupValue(fieldEnvTag, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !hasVal {
+		if opts.optional && !opts.required {
+			return nil, nil
+		}
+		return nil, errors.WithStack(&MissingKeyError{Key: fieldEnvTag})
+	}
+	opts.rawValue = envVal
+
+	kvs, err := parser.splitEntries(strings.TrimSpace(envVal))
+	if err != nil {
+		if opts.secret {
+			return nil, parseError(err, envVal, fieldType, fieldEnvTag, opts)
+		}
+		return nil, errors.Wrapf(err, "cannot split %s into entries", fieldEnvTag)
+	}
+	values := make(map[string]string, len(kvs))
+	entries := make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		values[kv[0]] = kv[1]
+		entries = append(entries, kv[0]+"="+kv[1])
+	}
+
+	// unmarshal the struct from its entries, matching keys regardless of case
+	splitMarshaler := *marshaler
+	splitMarshaler.Environment = &OsEnvReader{
+		lookup: func(key string) (string, bool) {
+			val, ok := values[key]
+			return val, ok
+		},
+		environ:         func() []string { return entries },
+		caseInsensitive: true,
+	}
+	// the entries are consumed as the raw value of the field itself
+	splitMarshaler.rawValues = nil
+	fieldVal, err := splitMarshaler.unmarshalStruct(fieldType, "", opts.path)
+	if err != nil {
+		// the entries of secret fields may appear in the errors of their own fields
+		if opts.secret {
+			return nil, parseError(err, envVal, fieldType, fieldEnvTag, opts)
+		}
+		return nil, errors.Wrapf(
+			err,
+			"cannot unmarshal %s to type %s",
+			fieldEnvTag,
+			fieldType.Name(),
+		)
+	}
+	return &fieldVal, nil
+}
+
+// Returns the type referenced by a pointer type, or the type itself otherwise.
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// Determines whether or not a type is a struct unmarshalled field by field, rather than
+// parsed from a single value.
+func isStructType(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType && t != bytesBufferType && t != regexpType && !isAtomicType(t) &&
+		!implementsValueUnmarshaler(t)
+}
+
+// Determines whether or not a struct field is an exported, embedded struct, or pointer to a
+// struct, without an env tag. The fields of such a struct are promoted, i.e. their keys are
+// nested under the prefix of the enclosing struct, as if they were its own fields.
+func isPromotedStruct(fieldStruct reflect.StructField) bool {
+	return fieldStruct.Anonymous && fieldStruct.PkgPath == "" && fieldStruct.Tag.Get("env") == "" &&
+		isStructType(indirectType(fieldStruct.Type))
+}
+
+// Returns the key of an untagged field derived from its name, or from the name of its type,
+// in upper snake case. If TypeNamePrefixes is set, struct fields, and pointers to structs,
+// have prefixes derived from the names of their types, e.g. DATABASE_CONFIG_ for a
+// DatabaseConfig. Otherwise, if UseFieldNames is set, fields have keys derived from their
+// names, e.g. MAX_CONNS for MaxConns, which end with an underscore for fields whose keys
+// are prefixes, e.g. of structs. It returns "" for tagged, promoted and unexported fields,
+// and for fields of unsupported kinds.
+func (marshaler *DefaultEnvMarshaler) derivedKey(fieldStruct reflect.StructField) string {
+	if fieldStruct.Anonymous || fieldStruct.PkgPath != "" || fieldStruct.Tag.Get("env") != "" {
+		return ""
+	}
+
+	fieldType := indirectType(fieldStruct.Type)
+	if isUnsupportedKind(fieldType.Kind()) && !isLazyFuncType(fieldType) {
+		return ""
+	}
+	if marshaler.TypeNamePrefixes && isStructType(fieldType) && fieldType.Name() != "" {
+		return upperSnakeCase(fieldType.Name()) + "_"
+	}
+	if !marshaler.UseFieldNames {
+		return ""
+	}
+
+	key := upperSnakeCase(fieldStruct.Name)
+	if isStructType(fieldType) || isDiscoveredMap(fieldType) {
+		key += "_"
+	}
+	return key
+}
+
+// Determines whether or not a struct field is unmarshalled, i.e. it has an env tag, is a
+// promoted struct, or has a key derived from its name or the name of its type.
+func (marshaler *DefaultEnvMarshaler) hasEnvKey(fieldStruct reflect.StructField) bool {
+	if isIgnoredField(fieldStruct) {
+		return false
+	}
+	return fieldStruct.Tag.Get("env") != "" || isPromotedStruct(fieldStruct) ||
+		marshaler.derivedKey(fieldStruct) != ""
+}
+
+// Determines whether or not a struct field is tagged `env:"-"` without a template, i.e. it
+// has neither a variable of its own nor a value computed from others.
+func isIgnoredField(fieldStruct reflect.StructField) bool {
+	return fieldStruct.Tag.Get("env") == NoEnvKey && fieldStruct.Tag.Get("template") == ""
+}
+
+// Derives the options of a struct field, nested under envPrefix, like parseFieldOptions, with
+// the key derived from its name or the name of its type, if any.
+func (marshaler *DefaultEnvMarshaler) fieldOptions(fieldStruct reflect.StructField, envPrefix string) (*fieldOptions, error) {
+	opts, err := parseFieldOptions(fieldStruct, envPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if key := marshaler.derivedKey(fieldStruct); key != "" {
+		opts.key = key
+	}
+	return opts, nil
+}
+
+// Converts a camel case name to upper snake case, e.g. DatabaseConfig to DATABASE_CONFIG.
+// Runs of capitals are kept together as acronyms, e.g. HTTPServer to HTTP_SERVER.
+func upperSnakeCase(name string) string {
+	runes := []rune(name)
+	var snake strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				snake.WriteByte('_')
+			}
+		}
+		snake.WriteRune(unicode.ToUpper(r))
+	}
+	return snake.String()
+}
+
+// Determines whether or not a type is a map of structs, e.g. map[string]DBConfig. Sets, i.e.
+// maps of empty structs such as StringSet, are parsed from a single value instead.
+func isStructMapType(t reflect.Type) bool {
+	if t.Kind() != reflect.Map {
+		return false
+	}
+	eltType := indirectType(t.Elem())
+	return isStructType(eltType) && eltType.NumField() > 0
+}
+
+// Unmarshals a map of structs, e.g. map[string]DBConfig, from keys of the form
+// <prefix><name>_<field>, e.g. DB_primary_HOST and DB_replica_HOST. Each distinct name,
+// which cannot contain underscores, is parsed as a key of the map and the corresponding
+// struct is unmarshalled with the prefix <prefix><name>_.
+func (marshaler *DefaultEnvMarshaler) unmarshalStructMap(
+	fieldType reflect.Type,
+	envPrefix string,
+	path string,
+	parser *DefaultParser,
+) (*reflect.Value, error) {
+	normalizedPrefix := marshaler.normalizeKey(envPrefix)
+	keys, err := marshaler.keysWithPrefix(normalizedPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		nameField := strings.SplitN(key[len(normalizedPrefix):], "_", 2)
+		if len(nameField) != 2 || nameField[0] == "" || seen[nameField[0]] {
+			continue
+		}
+		seen[nameField[0]] = true
+		names = append(names, nameField[0])
+	}
+	sort.Strings(names)
+
+	eltType := fieldType.Elem()
+	mapVal := reflect.New(fieldType).Elem()
+	mapVal.Set(reflect.MakeMap(fieldType))
+	for _, name := range names {
+		keyVal, err := parser.ParseType(name, fieldType.Key())
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot unmarshal map key %s (Env: %s)", name, envPrefix+name+"_")
+		}
+
+		structVal, err := marshaler.unmarshalStruct(indirectType(eltType), envPrefix+name+"_", path+"["+name+"]")
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot unmarshal map value %s", name)
+		}
+
+		if eltType.Kind() == reflect.Ptr {
+			ptrVal := reflect.New(eltType.Elem())
+			ptrVal.Elem().Set(structVal)
+			structVal = ptrVal
+		}
+		mapVal.SetMapIndex(keyVal, structVal)
+	}
+
+	return &mapVal, nil
+}
+
+// Determines whether or not a type is a slice of structs, or of pointers to structs, e.g.
+// []ServerConfig, whose elements are discovered from indexed keys, rather than a type the
+// Parser parses from a single value, e.g. an OrderedMap.
+func isStructSliceType(t reflect.Type) bool {
+	if t.Kind() != reflect.Slice || t == orderedMapType || implementsValueUnmarshaler(t) {
+		return false
+	}
+	eltType := indirectType(t.Elem())
+	return isStructType(eltType) && eltType.NumField() > 0
+}
+
+// Unmarshals a slice of structs, e.g. []ServerConfig, from keys of the form
+// <prefix><index>_<field>, e.g. SERVER_0_HOST and SERVER_1_HOST. The structs are
+// unmarshalled with the prefixes <prefix><index>_ in the order of their indices, which are
+// non-negative integers that must be contiguous from 0 unless the field has the gaps=skip
+// option, in which case missing indices are skipped. Other keys with the prefix are ignored.
+func (marshaler *DefaultEnvMarshaler) unmarshalStructSlice(
+	fieldType reflect.Type,
+	envPrefix string,
+	opts *fieldOptions,
+) (*reflect.Value, error) {
+	normalizedPrefix := marshaler.normalizeKey(envPrefix)
+	keys, err := marshaler.keysWithPrefix(normalizedPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// the first key giving each index, by the way the index is written, e.g. 1 or 01
+	sort.Strings(keys)
+	spellings := map[int]map[string]string{}
+	for _, key := range keys {
+		indexField := strings.SplitN(key[len(normalizedPrefix):], "_", 2)
+		if len(indexField) != 2 {
+			continue
+		}
+		index, err := strconv.Atoi(indexField[0])
+		if err != nil || index < 0 {
+			continue
+		}
+		if spellings[index] == nil {
+			spellings[index] = map[string]string{}
+		}
+		if _, ok := spellings[index][indexField[0]]; !ok {
+			spellings[index][indexField[0]] = key
+		}
+	}
+
+	indices := make([]int, 0, len(spellings))
+	for index := range spellings {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	for _, index := range indices {
+		// the prefixes of elements are rebuilt from their indices, e.g. SERVER_1_
+		name := strconv.Itoa(index)
+		if len(spellings[index]) > 1 {
+			duplicates := []string{}
+			for _, spelling := range sortedKeys(spellings[index]) {
+				duplicates = append(duplicates, spellings[index][spelling])
+			}
+			return nil, errors.Errorf(
+				"cannot unmarshal %s: element %d is given by more than one index, in %s",
+				normalizedPrefix, index, strings.Join(duplicates, " and "))
+		}
+		if _, ok := spellings[index][name]; !ok {
+			for spelling, key := range spellings[index] {
+				return nil, errors.Errorf(
+					"cannot unmarshal %s: index %s of %s is not canonical, expected %s",
+					normalizedPrefix, spelling, key, name)
+			}
+		}
+	}
+	if len(indices) == 0 && opts.optional && !opts.required {
+		return nil, nil
+	}
+	if !opts.skipGaps {
+		for i, index := range indices {
+			if index != i {
+				return nil, errors.Errorf(
+					"cannot unmarshal %s: element %d is missing, add gaps=skip to skip missing elements",
+					normalizedPrefix, i)
+			}
+		}
+	}
+
+	eltType := fieldType.Elem()
+	sliceVal := reflect.New(fieldType).Elem()
+	sliceVal.Set(reflect.MakeSlice(fieldType, len(indices), len(indices)))
+	for i, index := range indices {
+		name := strconv.Itoa(index)
+		structVal, err := marshaler.unmarshalStruct(indirectType(eltType), envPrefix+name+"_", opts.path+"["+name+"]")
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot unmarshal element %d", index)
+		}
+
+		if eltType.Kind() == reflect.Ptr {
+			ptrVal := reflect.New(eltType.Elem())
+			ptrVal.Elem().Set(structVal)
+			structVal = ptrVal
+		}
+		sliceVal.Index(i).Set(structVal)
+	}
+
+	return &sliceVal, nil
+}
+
+// Unmarshals a two-level map, e.g. map[string]map[string]time.Duration, from keys of the
+// form <prefix><outer>_<inner>, e.g. CACHE_REGIONS_US_TTL with the prefix CACHE_REGIONS_
+// populates the entry TTL of the map US. Outer keys cannot contain underscores, whereas
+// inner keys can.
+func (marshaler *DefaultEnvMarshaler) unmarshalNestedMap(
+	fieldType reflect.Type,
+	envPrefix string,
+	opts *fieldOptions,
+	parser *DefaultParser,
+) (*reflect.Value, error) {
+	envPrefix = marshaler.normalizeKey(envPrefix)
+	keys, err := marshaler.keysWithPrefix(envPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	innerType := fieldType.Elem()
+	mapVal := reflect.New(fieldType).Elem()
+	mapVal.Set(reflect.MakeMap(fieldType))
+	for _, key := range keys {
+		outerInner := strings.SplitN(key[len(envPrefix):], "_", 2)
+		if len(outerInner) != 2 || outerInner[0] == "" || outerInner[1] == "" {
+			continue
+		}
+
+		outerVal, err := parser.ParseType(outerInner[0], fieldType.Key())
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot unmarshal map key %s (Env: %s)", outerInner[0], key)
+		}
+		innerKeyVal, err := parser.ParseType(outerInner[1], innerType.Key())
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot unmarshal map key %s (Env: %s)", outerInner[1], key)
+		}
+
+		envVal, _, err := marshaler.lookupEnv(key)
+		if err != nil {
+			return nil, err
+		}
+		innerVal, err := parser.ParseType(envVal, innerType.Elem())
+		if err != nil {
+			return nil, parseError(err, envVal, innerType.Elem(), key, opts)
+		}
+
+		innerMap := mapVal.MapIndex(outerVal)
+		if !innerMap.IsValid() {
+			innerMap = reflect.MakeMap(innerType)
+			mapVal.SetMapIndex(outerVal, innerMap)
+		}
+		innerMap.SetMapIndex(innerKeyVal, innerVal)
 	}
+
+	return &mapVal, nil
 }
 
-// LookupEnv - Lookup a certain environment variable by name. Returns the value of the
-// environment variable if the variable exists and has an assigned value. Otherwise,
-// returns an unspecific value, and the exists flag is set to false.
-func (env *OsEnvReader) LookupEnv(key string) (string, bool) {
-	return env.lookup(key)
+// Returns the keys of the environment that start with a prefix. It returns an error if the
+// environment cannot enumerate its keys.
+func (marshaler *DefaultEnvMarshaler) keysWithPrefix(prefix string) ([]string, error) {
+	if prefixEnumerator, ok := marshaler.Environment.(PrefixEnumerator); ok {
+		return marshaler.appendOverrideKeys(prefixEnumerator.KeysWithPrefix(prefix), prefix), nil
+	}
+
+	enumerator, ok := marshaler.Environment.(EnvEnumerator)
+	if !ok {
+		return nil, errors.Errorf(
+			"cannot enumerate keys with prefix %s: environment does not implement EnvEnumerator",
+			prefix,
+		)
+	}
+
+	keys := []string{}
+	for _, key := range enumerator.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return marshaler.appendOverrideKeys(keys, prefix), nil
 }
 
-// HasKeys - Returns whether or not a set of environment variables have corresponding
-// values along with a list of environment variables that do not have values.
-func (env *OsEnvReader) HasKeys(keys []string) (bool, []string) {
-	missingKeys := []string{}
+// Unmarshals a slice from the values of all environment variables prefixed with envPrefix,
+// in the order of their keys. Keys whose values are missing, e.g. empty values with
+// TreatEmptyAsAbsent, are skipped.
+func (marshaler *DefaultEnvMarshaler) collectValues(
+	fieldType reflect.Type,
+	envPrefix string,
+	opts *fieldOptions,
+	parser *DefaultParser,
+) (*reflect.Value, error) {
+	if fieldType.Kind() != reflect.Slice {
+		return nil, errors.Errorf("cannot collect %s into non-slice type %s", envPrefix, fieldType)
+	}
+
+	envPrefix = marshaler.normalizeKey(envPrefix)
+	keys, err := marshaler.keysWithPrefix(envPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	sliceVal := reflect.New(fieldType).Elem()
+	sliceVal.Set(reflect.MakeSlice(fieldType, 0, len(keys)))
 	for _, key := range keys {
-		if _, ok := env.LookupEnv(key); !ok {
-			missingKeys = append(missingKeys, key)
+		// the keys are looked up like those of other fields, e.g. from the prefetched batch
+		envVal, hasVal, err := marshaler.lookupEnv(key)
+		if err != nil {
+			return nil, err
+		}
+		if !hasVal {
+			continue
+		}
+
+		envVal = strings.TrimSpace(opts.envValue(envVal))
+		eltVal, parseErr := parser.ParseType(envVal, fieldType.Elem())
+		if parseErr != nil {
+			return nil, parseError(parseErr, envVal, fieldType.Elem(), key, opts)
 		}
+		sliceVal.Set(reflect.Append(sliceVal, eltVal))
 	}
 
-	return len(missingKeys) == 0, missingKeys
+	return &sliceVal, nil
 }
 
-// EnvUnmarshaler is an interface for any object that defines the UnmarshalEnv method, i.e. a
-// method that accepts an EnvReader and can unmarshal from environment variable
-// values from the EnvReader
-type EnvUnmarshaler interface {
-	UnmarshalEnv(EnvReader) error
+// Determines whether or not values of a specific kind can never be unmarshalled
+// from environment variables, e.g. funcs and channels.
+func isUnsupportedKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return true
+	}
+	return false
 }
 
-// Marshaler - An interface for any object that implements the Unmarshal method.
-type Marshaler interface {
-	Unmarshal(interface{}) error
+// Determines whether or not a type is a lazy func, i.e. of the form func() (T, error), whose
+// value is parsed as a T only when the func is called.
+func isLazyFuncType(t reflect.Type) bool {
+	return t.Kind() == reflect.Func && t.NumIn() == 0 && !t.IsVariadic() &&
+		t.NumOut() == 2 && t.Out(1) == errorType
 }
 
-// DefaultEnvMarshaler - An unmarshaller that uses the DefaultParser and a specific environment reader
-// to unmarshal primitive and derived values.
-type DefaultEnvMarshaler struct {
-	Environment EnvReader
-}
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
 
-// Determines whether or not a specific object type (represented as reflect.Type)
-// implements the EnvUnMarshaler interface.
-func (marshaler *DefaultEnvMarshaler) implementsUnmarshal(t reflect.Type) bool {
-	modelType := reflect.TypeOf((*EnvUnmarshaler)(nil)).Elem()
-	return reflect.PtrTo(t).Implements(modelType)
+// Unmarshals a lazy func by looking up its raw value, including any defaults, and capturing
+// it in a func that parses it on every call. A missing value fails immediately, whereas a
+// value that cannot be parsed fails only when the func is called.
+func (marshaler *DefaultEnvMarshaler) unmarshalLazyFunc(
+	structFieldVal reflect.Value,
+	fieldEnvTag string,
+	opts *fieldOptions,
+	parser *DefaultParser,
+) error {
+	fieldEnvTag = marshaler.normalizeKey(fieldEnvTag)
+	envVal, hasVal, err := marshaler.lookupValue(fieldEnvTag, opts)
+	if err != nil {
+		return err
+	}
+	if !hasVal {
+		return errors.WithStack(&MissingKeyError{Key: fieldEnvTag})
+	}
+	opts.rawValue = envVal
+
+	funcType := structFieldVal.Type()
+	valType := funcType.Out(0)
+	structFieldVal.Set(reflect.MakeFunc(funcType, func([]reflect.Value) []reflect.Value {
+		val, parseErr := parser.ParseType(envVal, valType)
+		if parseErr != nil {
+			err := parseError(parseErr, envVal, valType, fieldEnvTag, opts)
+			return []reflect.Value{reflect.Zero(valType), reflect.ValueOf(&err).Elem()}
+		}
+		return []reflect.Value{val, reflect.Zero(errorType)}
+	}))
+	return nil
 }
 
-func (marshaler *DefaultEnvMarshaler) unmarshalType(
-	fieldType reflect.Type, fieldEnvTag string, parser *DefaultParser,
-) (*reflect.Value, error) {
-	envVal, hasVal := marshaler.Environment.LookupEnv(fieldEnvTag)
-	if !hasVal {
-		return nil, errors.Errorf(
-			"cannot retrieve any value from environment var %s",
-			fieldEnvTag,
-		)
+// Unmarshals an interface from the concrete value produced by the factory selected by the
+// key named by the field's typeFrom tag. Concrete values that are pointers are allocated
+// anew and point to the unmarshalled value.
+func (marshaler *DefaultEnvMarshaler) unmarshalInterface(
+	structFieldVal reflect.Value,
+	fieldEnvTag string,
+	opts *fieldOptions,
+	parser *DefaultParser,
+) error {
+	typeFrom := marshaler.normalizeKey(opts.typeFrom)
+	typeName, hasType, err := marshaler.lookupEnv(typeFrom)
+	if err != nil {
+		return err
+	}
+	if !hasType {
+		return errors.Errorf("cannot retrieve any type from environment var %s", typeFrom)
 	}
 
-	fieldVal, parseErr := parser.ParseType(envVal, fieldType)
-	if parseErr != nil {
-		return nil, errors.Wrapf(parseErr,
-			"cannot unmarshal %s to type %s (Env: %s)",
-			envVal,
-			fieldType.Name(),
-			fieldEnvTag,
-		)
+	typeName = strings.TrimSpace(typeName)
+	factory, ok := marshaler.TypeFactories[typeFrom][typeName]
+	if !ok {
+		return errors.Errorf("no type is registered for %s (Env: %s)", typeName, typeFrom)
 	}
 
-	return &fieldVal, nil
+	concrete := reflect.ValueOf(factory())
+	if !concrete.IsValid() {
+		return errors.Errorf("factory for %s returned nil (Env: %s)", typeName, typeFrom)
+	}
+	if !concrete.Type().AssignableTo(structFieldVal.Type()) {
+		return errors.Errorf("type %s for %s does not implement %s", concrete.Type(), typeName, structFieldVal.Type())
+	}
+
+	concreteType := indirectType(concrete.Type())
+	val, err := marshaler.unmarshalNonPtr(concreteType, fieldEnvTag, opts, parser)
+	if err != nil {
+		return err
+	}
+	if val == nil {
+		return nil
+	}
+
+	if concrete.Kind() == reflect.Ptr {
+		ptrVal := reflect.New(concreteType)
+		ptrVal.Elem().Set(*val)
+		structFieldVal.Set(ptrVal)
+		return nil
+	}
+	structFieldVal.Set(*val)
+	return nil
 }
 
-func (marshaler *DefaultEnvMarshaler) unmarshalNonPtr(
-	fieldType reflect.Type,
-	fieldEnvTag string,
-	parser *DefaultParser,
-) (*reflect.Value, error) {
-	if fieldType.Name() == "Time" {
-		return marshaler.unmarshalType(fieldType, fieldEnvTag, parser)
+// Returns the element type of a map, slice or array type, or of a pointer to one, or nil for
+// other types and for those parsed as a whole, e.g. OrderedMaps.
+func aggregateElem(t reflect.Type) reflect.Type {
+	t = indirectType(t)
+	switch t.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		if t == orderedMapType || implementsValueUnmarshaler(t) {
+			return nil
+		}
+		return t.Elem()
 	}
+	return nil
+}
 
-	if fieldType.Kind() == reflect.Struct {
-		fieldVal, err := marshaler.unmarshalStruct(fieldType, fieldEnvTag)
-		if err != nil {
-			return nil, errors.Wrapf(
-				err,
-				"cannot unmarshal %s to type %s",
-				fieldEnvTag,
-				fieldType.Name(),
-			)
+// Determines whether or not a type nests structs in aggregates more deeply than can be
+// unmarshalled, e.g. map[string][]ServerConfig or [][]ServerConfig. Maps and slices of
+// structs are supported, but not maps or slices of aggregates of structs.
+func isUnsupportedNesting(t reflect.Type) bool {
+	eltType := aggregateElem(t)
+	if eltType == nil {
+		return false
+	}
+	for inner := aggregateElem(eltType); inner != nil; inner = aggregateElem(inner) {
+		if isStructType(indirectType(inner)) {
+			return true
 		}
-		return &fieldVal, nil
 	}
+	return false
+}
+
+// Unmarshals a bool, or a pointer to a bool, from whether or not its key is present in the
+// environment, regardless of its value.
+func (marshaler *DefaultEnvMarshaler) unmarshalPresence(
+	structFieldVal reflect.Value,
+	fieldEnvTag string,
+	opts *fieldOptions,
+) error {
+	key := marshaler.normalizeKey(fieldEnvTag)
+	envVal, hasVal, err := marshaler.lookupEnv(key)
+	if err != nil {
+		return err
+	}
+	opts.rawValue = envVal
+	opts.source = SourceZero
+	if hasVal {
+		opts.resolvedKey = key
+		opts.source = SourceEnv
+	}
+
+	boolVal := reflect.ValueOf(hasVal)
+	if structFieldVal.Kind() == reflect.Ptr {
+		ptrVal := reflect.New(structFieldVal.Type().Elem())
+		ptrVal.Elem().Set(boolVal.Convert(ptrVal.Elem().Type()))
+		boolVal = ptrVal
+	}
+	structFieldVal.Set(boolVal.Convert(structFieldVal.Type()))
+	return nil
+}
+
+// Reports an unmarshalled field to the OnField callback, if any.
+func (marshaler *DefaultEnvMarshaler) reportField(fieldEnvTag string, opts *fieldOptions, err error) {
+	if marshaler.OnField == nil {
+		return
+	}
+
+	rawValue := opts.rawValue
+	if opts.secret {
+		rawValue = ""
+	}
+	marshaler.OnField(opts.path, marshaler.normalizeKey(fieldEnvTag), rawValue, err)
+}
+
+// the type of the fields with the raw option
+var rawValuesType = reflect.TypeOf(map[string]string{})
 
-	return marshaler.unmarshalType(fieldType, fieldEnvTag, parser)
+// Records the raw value of a field read from the environment in the raw fields of the
+// structs being unmarshalled, if any. The values of secret fields are redacted.
+func (marshaler *DefaultEnvMarshaler) recordRawValue(opts *fieldOptions) {
+	if opts.resolvedKey == "" {
+		return
+	}
+
+	rawValue := opts.rawValue
+	if opts.secret {
+		rawValue = RedactedValue
+	}
+	for _, rawValues := range marshaler.rawValues {
+		rawValues[opts.resolvedKey] = rawValue
+	}
 }
 
 // Unmarshals a field in a struct.
@@ -164,55 +1344,217 @@ func (marshaler *DefaultEnvMarshaler) unmarshalField(
 	fieldStruct reflect.StructField,
 	structFieldVal reflect.Value,
 	fieldEnvTag string,
+	opts *fieldOptions,
 	parser *DefaultParser,
-) error {
+) (err error) {
+	defer func() {
+		if err == nil {
+			marshaler.recordRawValue(opts)
+		}
+		marshaler.reportField(fieldEnvTag, opts, err)
+	}()
+
 	structFieldType := structFieldVal.Type()
 	fieldName := fieldStruct.Name
 
+	if structFieldType.Kind() == reflect.Interface && opts.typeFrom != "" {
+		if err := marshaler.unmarshalInterface(structFieldVal, fieldEnvTag, opts, parser); err != nil {
+			return errors.Wrapf(err, "error unmarshaling field %s", fieldName)
+		}
+		return nil
+	}
+
+	if isLazyFuncType(structFieldType) {
+		if err := marshaler.unmarshalLazyFunc(structFieldVal, fieldEnvTag, opts, parser); err != nil {
+			return errors.Wrapf(err, "error unmarshaling field %s", fieldName)
+		}
+		return nil
+	}
+
+	if isAtomicType(structFieldType) {
+		if err := marshaler.unmarshalAtomic(structFieldVal, fieldEnvTag, opts, parser); err != nil {
+			return errors.Wrapf(err, "error unmarshaling field %s", fieldName)
+		}
+		return nil
+	}
+
+	baseType := structFieldType
+	if baseType.Kind() == reflect.Ptr {
+		baseType = baseType.Elem()
+	}
+	if isUnsupportedKind(baseType.Kind()) {
+		return errors.Errorf(
+			"cannot unmarshal field %s of unsupported kind %s",
+			fieldName,
+			baseType.Kind(),
+		)
+	}
+	if isUnsupportedNesting(baseType) {
+		return errors.Errorf("unsupported nested type %s at field %s", baseType, opts.path)
+	}
+
+	if opts.presence {
+		if baseType.Kind() != reflect.Bool {
+			return errors.Errorf("cannot unmarshal presence into field %s of non-bool type %s", fieldName, baseType)
+		}
+		return marshaler.unmarshalPresence(structFieldVal, fieldEnvTag, opts)
+	}
+
 	if structFieldType.Kind() == reflect.Ptr {
+		// pointers are left nil, rather than failing, when their values are missing
+		opts.optional = true
 		indirectType := structFieldType.Elem()
-		indirectVal, unmarshErr := marshaler.unmarshalNonPtr(indirectType, fieldEnvTag, parser)
+		indirectVal, unmarshErr := marshaler.unmarshalNonPtr(indirectType, fieldEnvTag, opts, parser)
 		if unmarshErr != nil {
 			return errors.Wrapf(unmarshErr, "error unmarshaling field %s", fieldName)
 		}
+		if indirectVal == nil {
+			return nil
+		}
 		structFieldVal.Set(indirectVal.Addr())
 		return nil
 
 	}
 
-	fieldVal, unmarshErr := marshaler.unmarshalNonPtr(structFieldType, fieldEnvTag, parser)
+	fieldVal, unmarshErr := marshaler.unmarshalNonPtr(structFieldType, fieldEnvTag, opts, parser)
 	if unmarshErr != nil {
 		return errors.Wrapf(unmarshErr, "error unmarshaling field %s", fieldName)
 	}
+	if fieldVal == nil {
+		return nil
+	}
 
 	structFieldVal.Set(*fieldVal)
 	return nil
 }
 
-// Recursively unmarshals a struct.
-func (marshaler *DefaultEnvMarshaler) unmarshalStruct(t reflect.Type, envPrefix string) (reflect.Value, error) {
+// Returns the depth to which structs may be nested, given by MaxDepth or DefaultMaxDepth.
+func (marshaler *DefaultEnvMarshaler) maxDepth() int {
+	if marshaler.MaxDepth <= 0 {
+		return DefaultMaxDepth
+	}
+	return marshaler.MaxDepth
+}
+
+// Recursively unmarshals a struct whose fields' paths are nested under path.
+func (marshaler *DefaultEnvMarshaler) unmarshalStruct(t reflect.Type, envPrefix string, path string) (reflect.Value, error) {
 	val := reflect.New(t).Elem()
-	parser := &DefaultParser{}
+	parser := marshaler.parser()
 
 	tKind := t.Kind()
 	if tKind != reflect.Struct {
 		return val, errors.Errorf("cannot unmarshal non-struct type %s", tKind)
 	}
 
+	marshaler.depth++
+	defer func() {
+		marshaler.depth--
+	}()
+	if marshaler.depth > marshaler.maxDepth() {
+		return val, errors.Errorf(
+			"cannot unmarshal field %s: structs are nested more than %d deep", path, marshaler.maxDepth())
+	}
+
+	fields := []structField{}
+	rawFields := []int{}
 	for i := 0; i < t.NumField(); i++ {
 		fieldStruct := t.Field(i)
-		fieldEnvTag := fieldStruct.Tag.Get("env")
+		if !marshaler.hasEnvKey(fieldStruct) {
+			continue
+		}
+
+		opts, err := marshaler.fieldOptions(fieldStruct, envPrefix)
+		if err != nil {
+			return val, errors.Wrapf(err, "invalid tags on field %s", fieldStruct.Name)
+		}
+		if envDefault, ok := environmentDefault(fieldStruct.Tag, marshaler.EnvironmentName); ok {
+			opts.defaultValue, opts.hasDefault, opts.environmentDefault = envDefault, true, true
+		}
+		marshaler.applySchema(opts.envKey(envPrefix), opts)
+
+		opts.path = fieldStruct.Name
+		if path != "" {
+			opts.path = path + "." + fieldStruct.Name
+		}
+		if opts.raw {
+			if fieldStruct.Type != rawValuesType {
+				return val, errors.Errorf(
+					"cannot capture raw values into field %s of type %s: expected %s", opts.path, fieldStruct.Type, rawValuesType)
+			}
+			rawFields = append(rawFields, i)
+			continue
+		}
+		fields = append(fields, structField{index: i, opts: opts})
+	}
+
+	fields, err := marshaler.dependencyOrder(fields, envPrefix)
+	if err != nil {
+		return val, err
+	}
+
+	if len(rawFields) > 0 {
+		consumed := map[string]string{}
+		defer func(rawValues []map[string]string) {
+			marshaler.rawValues = rawValues
+		}(marshaler.rawValues)
+		marshaler.rawValues = append(marshaler.rawValues, consumed)
+		for _, index := range rawFields {
+			val.Field(index).Set(reflect.ValueOf(consumed))
+		}
+	}
+
+	// the raw values of the fields unmarshalled so far, by key, referenced by other fields
+	resolved := map[string]string{}
+	for _, field := range fields {
+		fieldStruct := t.Field(field.index)
+		opts := field.opts
+
+		enabled, err := marshaler.fieldEnabled(opts)
+		if err != nil {
+			return val, errors.Wrapf(err, "error unmarshaling field %s", fieldStruct.Name)
+		}
+		if !enabled {
+			marshaler.result.recordDisabled(opts)
+			continue
+		}
 
-		if fieldEnvTag == "" {
+		fieldEnvTag := opts.envKey(envPrefix)
+		structFieldVal := val.Field(field.index)
+		if opts.template != "" {
+			if err := marshaler.unmarshalTemplate(fieldStruct, structFieldVal, fieldEnvTag, opts, resolved); err != nil {
+				return val, err
+			}
 			continue
 		}
 
-		fieldEnvTag = envPrefix + fieldEnvTag
-		structFieldVal := val.Field(i)
-		err := marshaler.unmarshalField(fieldStruct, structFieldVal, fieldEnvTag, parser)
+		err = marshaler.unmarshalField(fieldStruct, structFieldVal, fieldEnvTag, opts, opts.fieldParser(parser))
+		if err != nil && opts.errMsg != "" {
+			// the custom message leads, followed by the cause for debugging
+			return val, errors.Wrap(err, opts.errMsg)
+		}
 		if err != nil {
 			return val, err
 		}
+		if err := marshaler.checkConflicts(fieldEnvTag, opts); err != nil {
+			return val, errors.Wrapf(err, "error unmarshaling field %s", fieldStruct.Name)
+		}
+		if opts.deprecated && opts.resolvedKey == marshaler.normalizeKey(fieldEnvTag) {
+			marshaler.warn(WarningDeprecated, opts.resolvedKey,
+				"environment var %s of field %s is deprecated", opts.resolvedKey, opts.path)
+		}
+		if opts.clamped {
+			marshaler.result.recordWarning(WarningClamped, opts.resolvedKey,
+				fmt.Sprintf("duration %s of field %s was clamped", opts.rawValue, opts.path))
+		}
+		if opts.defaulted {
+			marshaler.defaulted = append(marshaler.defaulted, opts.path)
+			marshaler.result.recordWarning(WarningDefaulted, marshaler.normalizeKey(fieldEnvTag),
+				fmt.Sprintf("field %s fell back to its default", opts.path))
+		}
+		marshaler.result.recordField(opts)
+		if opts.resolvedKey != "" || opts.defaulted {
+			resolved[marshaler.normalizeKey(fieldEnvTag)] = opts.rawValue
+		}
 	}
 
 	return val, nil
@@ -221,6 +1563,137 @@ func (marshaler *DefaultEnvMarshaler) unmarshalStruct(t reflect.Type, envPrefix
 // Unmarshal - Unmarshals a given value from environment variables. It accepts a pointer to a given
 // object, and either succeeds in unmarshalling the object or returns an error.
 //
+// Fields are unmarshalled with the first of the following that applies: the UnmarshalEnv
+// method of types implementing EnvUnmarshaler, which is passed a view of the environment
+// relative to the field's env tag; the recursive unmarshalling of struct fields and maps of
+// structs, with the env tag prefixing their keys; and otherwise the Parser, see
+// DefaultParser.ParseType. Maps of structs, e.g. map[string]DBConfig tagged `env:"DB_"`,
+// are populated from keys of the form DB_<name>_<field>, with one struct per distinct name.
+// Similarly, maps of maps, e.g. map[string]map[string]string tagged `env:"REGIONS_"`, are
+// populated from keys of the form REGIONS_<outer>_<inner>, where outer contains no
+// underscores. Slices of structs, e.g. []ServerConfig tagged `env:"SERVER_"`, are populated
+// from keys of the form SERVER_<index>_<field>, in the order of their indices.
+// Embedded structs, and pointers to them, without an env tag have their fields promoted, i.e.
+// their keys are nested under the prefix of the enclosing struct, and pointers are allocated.
+//
+// Pointer fields other than structs, e.g. a *bool, are left nil if their variables are
+// missing and have no defaults, rather than failing, unless they are required. This
+// distinguishes unset values from zero values, e.g. for tri-state flags.
+//
+// The key of the env tag may be followed by comma-separated options:
+//
+//   - base:N parses integers in base N rather than base 10, e.g. `env:"MASK,base:8"`
+//   - scale=N parses Decimals with N decimal places, e.g. `env:"PRICE,scale=2"`
+//   - abs reads the key as is, rather than nested under the prefixes of enclosing structs,
+//     e.g. `env:"GLOBAL_REGION,abs"`
+//   - strict rejects integers with leading zeros, e.g. 0080, other than a lone 0
+//   - sci accepts integers written in scientific notation, e.g. 1e3, if they are whole numbers
+//   - clamp clamps overflowing durations to the largest (or smallest) time.Duration
+//   - nonneg rejects negative durations, and neg rejects durations that aren't negative
+//   - sep=S splits slices and maps on S rather than on the Parser's SliceSeparator
+//   - kvsep=S splits the keys and values of map entries on S rather than on the Parser's
+//     KeyValueSeparator, e.g. `env:"LABELS,sep=;,kvsep=:"`
+//   - nlsep splits slices and maps on newlines, ignoring trailing empty lines
+//   - split populates a struct from the key=value entries of a single variable, separated
+//     by sep or by DefaultSplitSeparator, whose keys match the env tags of its fields
+//     regardless of case, e.g. `env:"DB,split"` parses DB=host=localhost;port=5432
+//   - recsep=S and fieldsep=S parse slices of structs from records split on recsep, whose
+//     values are split on fieldsep and map positionally to the struct's fields with env
+//     tags, in the order they are declared, e.g. `env:"USERS,recsep=|,fieldsep=;"` parses
+//     "alice;admin|bob;user" into []User{{"alice", "admin"}, {"bob", "user"}}
+//   - gaps=skip skips missing indices of slices of structs discovered from indexed keys,
+//     compacting the slice, e.g. SERVER_0_HOST and SERVER_2_HOST populate two elements,
+//     rather than rejecting them
+//   - kv parses slices of structs with two fields with env tags from key=value entries,
+//     split on kvsep or the Parser's KeyValueSeparator, whose keys map to the first field
+//     and values to the second, e.g. `env:"HEADERS,kv"` parses "X-A=1,X-B=2" into
+//     []Header{{"X-A", "1"}, {"X-B", "2"}}
+//   - stripcomment strips a trailing comment, starting at a # surrounded by whitespace,
+//     from the variable, e.g. "db.local # the primary" is read as db.local, whereas "#fff"
+//     and "a#b" are read as they are
+//   - trimnewline trims only trailing newlines from strings, rather than surrounding
+//     whitespace, preserving the spaces of, e.g., passwords
+//   - trimtrailing drops the empty element after a trailing separator of a slice, e.g. "a,b,"
+//     has the elements a and b
+//   - lower and upper lowercase or uppercase strings, including the elements of slices and
+//     the keys and values of maps, e.g. `env:"HOSTS,lower"`
+//   - nilempty parses an empty value as a nil slice or map, rather than an empty one
+//   - maxlen=N rejects slices of more than N elements, e.g. `env:"IPS,maxlen=100"`
+//   - min=N and max=N bound numeric values and durations, or each element of slices, like the
+//     min and max tags, e.g. `env:"PORTS,min=1,max=65535"` or `env:"DELAYS,min=1ms,max=1m"`
+//   - required requires the variable to be set, ignoring the default tag
+//   - deprecated logs a warning to the Logger when the variable is set, but still parses it
+//   - true=S and false=S parse only S as true and false, respectively, for bools, rejecting
+//     any other value, e.g. `env:"MODE,true=active,false=idle"`
+//   - presence sets a bool to whether or not its key is present, regardless of its value
+//   - collect populates a slice from every variable prefixed with the key, in the order of
+//     their keys, e.g. `env:"ALLOWED_ORIGIN_,collect"`
+//   - raw, without a key, populates a map[string]string with the raw values of every
+//     variable read by the struct, including its nested structs, by key, e.g.
+//     `env:",raw"`, with the values of secret fields redacted
+//
+// Other tags further configure a field:
+//
+//   - default gives the literal value of a missing variable
+//   - default<Name>, e.g. defaultProd, gives the literal value of a missing variable in
+//     preference to default when the EnvironmentName is, e.g., prod
+//   - defaultFrom names a variable, sharing the field's prefix, whose value is used for a
+//     missing variable in preference to the default; only the variable itself is read, not
+//     the default or template of its field, so fields are not ordered by it and may fall
+//     back to each other
+//   - typeFrom names a variable, sharing the field's prefix, whose value selects the concrete
+//     type of an interface field from the TypeFactories, e.g.
+//     `env:"BACKEND_" typeFrom:"BACKEND_TYPE"` with BACKEND_TYPE=redis
+//   - enabledBy names a boolean variable, sharing the field's prefix, without which the field
+//     is skipped, e.g. `env:"METRICS_" enabledBy:"METRICS_ENABLED"`
+//   - conflictsWith names comma-separated variables, sharing the field's prefix, that cannot
+//     be set along with the field's variable, e.g. `env:"USE_TLS" conflictsWith:"INSECURE"`
+//   - template computes the value of a field tagged `env:"-"` from other variables, sharing
+//     the field's prefix, e.g. `env:"-" template:"postgres://${DB_HOST}:${DB_PORT}/${DB_NAME}"`,
+//     with the values of the fields of those variables in its struct, including their
+//     defaults and templates, which are unmarshalled first wherever they are declared;
+//     references that form a cycle are an error
+//   - min and max bound numeric values and durations, inclusively, e.g. `min:"1" max:"65535"`
+//     or `min:"1s"`, and apply to each element of slices
+//   - pattern gives a regexp that strings, or each element of slices, must match, e.g.
+//     `pattern:"^[a-z][a-z0-9-]*$"`; an invalid regexp fails the field however it is set
+//   - secret:"true" replaces the value with RedactedValue in the messages of parse errors
+//   - errmsg gives a message for operators that leads the error of a missing or invalid
+//     value, which follows it, e.g. `errmsg:"Set API_KEY to your key from the dashboard."`
+//   - envFormat gives the layout of a time, which is otherwise any of the Parser's TimeLayouts
+//   - envTZ names the location in which a time is parsed
+//
+// The value of a field is resolved from the first of these sources to give one, which
+// UnmarshalWithResult reports in its Sources:
+//
+//  1. the field's own variable, SourceEnv
+//  2. the variable named by its defaultFrom tag, SourceDefaultFrom
+//  3. its default tag for the EnvironmentName, e.g. defaultProd, SourceEnvironmentDefault
+//  4. its default tag, SourceDefault
+//  5. none, SourceZero, which leaves optional fields, e.g. pointers, at their zero values
+//     and fails for others
+//
+// Required fields never fall back to 3 or 4. Fields with a template tag are computed from
+// it instead, SourceTemplate.
+//
+// Fields of the form func() (T, error) are lazy: their values are looked up by Unmarshal,
+// but parsed as a T only when, and every time, the func is called, which returns any
+// parse error instead.
+//
+// Fields of the typed values of sync/atomic, e.g. atomic.Int64 or atomic.Bool, are parsed as
+// the value they hold, which is then stored into the field in place, so that the field can
+// be read concurrently, e.g. while the config is reloaded. atomic.Values hold strings. The
+// other fields of a struct with such fields are assigned one by one, and its unexported
+// fields are left as they are.
+//
+// For example
+//
+//	AdvertiseHost string    `env:"ADVERTISE_HOST" defaultFrom:"BIND_HOST" default:"localhost"`
+//	Cutoff        time.Time `env:"CUTOFF" envFormat:"2006-01-02 15:04" envTZ:"America/New_York"`
+//
+// Maps of structs, maps of maps, slices of structs and the collect option require the
+// Environment to implement EnvEnumerator or PrefixEnumerator.
+//
 // Usage:
 //
 //	 import "github.com/evilwire/go-env"
@@ -244,27 +1717,180 @@ func (marshaler *DefaultEnvMarshaler) unmarshalStruct(t reflect.Type, envPrefix
 //	 }
 //
 func (marshaler *DefaultEnvMarshaler) Unmarshal(i interface{}) error {
-	t := reflect.TypeOf(i)
 	v := reflect.ValueOf(i)
-	if t.Kind() == reflect.Ptr {
+	if v.Kind() == reflect.Ptr {
+		v = reflect.Indirect(v)
+	}
+
+	return marshaler.UnmarshalValue(v)
+}
+
+// UnmarshalValue - Unmarshals environment variables into a reflect.Value, for callers that
+// already hold one. The value must be an addressable struct, e.g. the Elem of a pointer to
+// a struct, or an addressable value whose pointer implements EnvUnmarshaler.
+//
+// Usage:
+//
+//	config := reflect.New(configType).Elem()
+//	err := unmarshaller.UnmarshalValue(config)
+func (marshaler *DefaultEnvMarshaler) UnmarshalValue(v reflect.Value) error {
+	return marshaler.unmarshalValue(v, "")
+}
+
+// UnmarshalFrom - Unmarshals a given value, like Unmarshal, from environment variables whose
+// keys are prefixed with a given prefix, e.g. a struct with a field tagged `env:"HOST"` is
+// unmarshalled from SERVER_HOST with the prefix SERVER_.
+func (marshaler *DefaultEnvMarshaler) UnmarshalFrom(prefix string, i interface{}) error {
+	v := reflect.ValueOf(i)
+	if v.Kind() == reflect.Ptr {
 		v = reflect.Indirect(v)
-		t = v.Type()
 	}
 
+	return marshaler.unmarshalValue(v, prefix)
+}
+
+// UnmarshalMany - Unmarshals one instance per prefix, in the order of the prefixes, for configs
+// that share a struct, e.g. the client and server halves of a symmetric config. Each instance
+// is a pointer returned by factory, and is unmarshalled as with UnmarshalFrom.
+//
+// Usage:
+//
+//	instances, err := unmarshaller.UnmarshalMany(
+//		[]string{"CLIENT_", "SERVER_"},
+//		func() interface{} { return &TLSConfig{} },
+//	)
+//	client, server := instances[0].(*TLSConfig), instances[1].(*TLSConfig)
+func (marshaler *DefaultEnvMarshaler) UnmarshalMany(
+	prefixes []string,
+	factory func() interface{},
+) ([]interface{}, error) {
+	instances := make([]interface{}, len(prefixes))
+	for i, prefix := range prefixes {
+		instance := factory()
+		if err := marshaler.UnmarshalFrom(prefix, instance); err != nil {
+			return nil, errors.Wrapf(err, "cannot unmarshal instance with prefix %s", prefix)
+		}
+		instances[i] = instance
+	}
+	return instances, nil
+}
+
+// Unmarshals environment variables whose keys are prefixed with envPrefix into a reflect.Value.
+func (marshaler *DefaultEnvMarshaler) unmarshalValue(v reflect.Value, envPrefix string) error {
+	if !v.IsValid() {
+		return errors.New("cannot unmarshal into an invalid value")
+	}
+	t := v.Type()
+	marshaler.defaulted = []string{}
+
 	// if the object implements EnvUnmarshaler, then use UnmarshalEnv method
 	// of the type
 	if marshaler.implementsUnmarshal(t) {
-		envUnmarsh, _ := i.(EnvUnmarshaler)
-		return envUnmarsh.UnmarshalEnv(marshaler.Environment)
+		if !v.CanAddr() {
+			return errors.Errorf("cannot unmarshal into an unaddressable %s", t)
+		}
+		envUnmarsh := v.Addr().Interface().(EnvUnmarshaler)
+		if envPrefix == "" {
+			return envUnmarsh.UnmarshalEnv(marshaler.Environment)
+		}
+		return envUnmarsh.UnmarshalEnv(&prefixedEnvReader{
+			env:    marshaler.Environment,
+			prefix: marshaler.normalizeKey(envPrefix),
+		})
 	}
 
 	if t.Kind() != reflect.Struct {
 		return errors.New("cannot unmarshal non-struct, non-EnvMarshaler objects")
 	}
 
-	val, err := marshaler.unmarshalStruct(t, "")
+	if !v.CanSet() {
+		return errors.Errorf("cannot unmarshal into an unsettable %s", t)
+	}
+
+	marshaler.prefetch(t, envPrefix)
+	defer func() {
+		marshaler.prefetched = nil
+	}()
+
+	val, err := marshaler.unmarshalStruct(t, envPrefix, "")
 	if err == nil {
-		v.Set(val)
+		assignValue(v, val)
 	}
 	return err
 }
+
+// KeyFor - Returns the key from which a field of a struct, or of a pointer to a struct, is
+// unmarshalled, applying the prefixes of enclosing structs and the KeyNormalizer. Nested
+// fields are named by dotted paths of field names, e.g. Database.Host. It returns an error
+// if any field in the path doesn't exist or has no env tag.
+//
+// Usage:
+//
+//	key, err := unmarshaller.KeyFor(&config, "Database.Host") // DB_HOST
+func (marshaler *DefaultEnvMarshaler) KeyFor(i interface{}, fieldName string) (string, error) {
+	t := reflect.TypeOf(i)
+	if t != nil {
+		t = indirectType(t)
+	}
+	envPrefix := ""
+	path := strings.Split(fieldName, ".")
+	for depth, name := range path {
+		if t == nil || t.Kind() != reflect.Struct {
+			return "", errors.Errorf("cannot find field %s in non-struct type %v", strings.Join(path[:depth+1], "."), t)
+		}
+
+		fieldStruct, ok := t.FieldByName(name)
+		if !ok {
+			return "", errors.Errorf("cannot find field %s in type %s", strings.Join(path[:depth+1], "."), t)
+		}
+		if !marshaler.hasEnvKey(fieldStruct) {
+			return "", errors.Errorf("field %s has no env tag", strings.Join(path[:depth+1], "."))
+		}
+
+		opts, err := marshaler.fieldOptions(fieldStruct, envPrefix)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid tags on field %s", strings.Join(path[:depth+1], "."))
+		}
+		envPrefix = opts.envKey(envPrefix)
+		t = indirectType(fieldStruct.Type)
+	}
+
+	return marshaler.normalizeKey(envPrefix), nil
+}
+
+// DefaultedFields - Returns the paths of the fields, e.g. Database.Port, that fell back to the
+// literal values of their default tags in the last Unmarshal, in the order of the fields.
+// Fields set by their own keys, or by the keys named by their defaultFrom tags, are not
+// reported.
+func (marshaler *DefaultEnvMarshaler) DefaultedFields() []string {
+	defaulted := make([]string, len(marshaler.defaulted))
+	copy(defaulted, marshaler.defaulted)
+	return defaulted
+}
+
+// UnmarshalKey - Unmarshals the value of a single environment variable into a given value. It
+// accepts a pointer to any object the Parser can parse, such as a slice or a map, and either
+// succeeds in unmarshalling the object or returns an error.
+//
+// Usage:
+//
+//	hosts := []string{}
+//	err := unmarshaller.UnmarshalKey("CASSANDRA_HOSTS", &hosts)
+//
+//	labels := map[string]string{}
+//	err = unmarshaller.UnmarshalKey("LABELS", &labels)
+func (marshaler *DefaultEnvMarshaler) UnmarshalKey(key string, i interface{}) error {
+	key = marshaler.normalizeKey(key)
+	envVal, hasVal, err := marshaler.lookupEnv(key)
+	if err != nil {
+		return err
+	}
+	if !hasVal {
+		return errors.WithStack(&MissingKeyError{Key: key})
+	}
+
+	if err := marshaler.parser().Unmarshal(envVal, i); err != nil {
+		return errors.Wrapf(err, "cannot unmarshal %s (Env: %s)", envVal, key)
+	}
+	return nil
+}
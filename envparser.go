@@ -2,148 +2,955 @@
 package goenv
 
 import (
+	"bytes"
+	"encoding"
+	"encoding/json"
 	"github.com/pkg/errors"
+	"io"
+	"math"
+	"net/netip"
+	"net/url"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// DefaultSliceSeparator is the separator used to split slice values when the parser
+// does not specify one.
+const DefaultSliceSeparator = ","
+
+// DefaultKeyValueSeparator is the separator used to split the keys and values of map entries
+// when the parser does not specify one.
+const DefaultKeyValueSeparator = "="
+
+// DefaultSplitSeparator is the separator used to split the entries of structs with the split
+// option when the field does not specify one with the sep option.
+const DefaultSplitSeparator = ";"
+
+// DecodeHook - Converts the string data of a value into a value of type to, which must be
+// assignable or convertible to to. A hook that does not handle the (from, to) pair passes
+// it to the next hook by returning a nil value and a nil error. The from type is always the
+// type of data, i.e. string, in keeping with decode hooks elsewhere, e.g. mapstructure.
+type DecodeHook func(from reflect.Type, to reflect.Type, data string) (interface{}, error)
+
+var stringType = reflect.TypeOf("")
+
+// DefaultTimeLayouts are the layouts, tried in order, with which times are parsed when the
+// parser does not specify any. They include the formats of dates in HTTP headers and logs,
+// with the layouts of numeric zones ahead of those of zone abbreviations.
+var DefaultTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC822Z,
+	time.RFC822,
+	time.ANSIC,
+}
+
 // DefaultParser - A default way to parse a string into a specific primitive or pointer.
-type DefaultParser struct{}
+type DefaultParser struct {
+	// SliceSeparator splits the elements of array and slice values. Defaults to
+	// DefaultSliceSeparator if empty.
+	SliceSeparator string
+
+	// KeyValueSeparator splits the key and value of each map entry. Defaults to
+	// DefaultKeyValueSeparator if empty.
+	KeyValueSeparator string
+
+	// TimeLayouts are tried in order to parse times until one succeeds, unless the field
+	// being parsed has an envFormat tag. Defaults to DefaultTimeLayouts if empty. Times are
+	// formatted with the first layout.
+	TimeLayouts []string
+
+	// DecodeHooks are consulted in order before the built-in parsing of every value,
+	// including the elements of slices and maps, and the first to handle a value parses it.
+	DecodeHooks []DecodeHook
+
+	// the options of the struct field being parsed, if any
+	field *fieldOptions
+}
+
+// Returns the scale at which Decimals are parsed, or -1 for the scale of their values.
+func (marshaler *DefaultParser) scale() int {
+	if marshaler.field == nil || !marshaler.field.hasScale {
+		return -1
+	}
+	return marshaler.field.scale
+}
+
+// Returns the base in which integer values are parsed.
+func (marshaler *DefaultParser) base() int {
+	if marshaler.field == nil || marshaler.field.base == 0 {
+		return 10
+	}
+	return marshaler.field.base
+}
+
+// Returns the maximum number of elements of array and slice values, or 0 if unbounded.
+func (marshaler *DefaultParser) maxLen() int {
+	if marshaler.field == nil {
+		return 0
+	}
+	return marshaler.field.maxLen
+}
+
+// Returns whether or not empty strings are parsed as nil slices and maps, rather than empty
+// ones.
+func (marshaler *DefaultParser) nilEmpty() bool {
+	return marshaler.field != nil && marshaler.field.nilEmpty
+}
+
+// Returns whether or not integer values may be written in scientific notation.
+func (marshaler *DefaultParser) scientific() bool {
+	return marshaler.field != nil && marshaler.field.sci
+}
+
+// Returns the separator used to split array and slice values. The separator of the field
+// being parsed, if any, takes precedence over the SliceSeparator.
+func (marshaler *DefaultParser) sliceSeparator() string {
+	if marshaler.field != nil && marshaler.field.separator != "" {
+		return marshaler.field.separator
+	}
+	if marshaler.SliceSeparator == "" {
+		return DefaultSliceSeparator
+	}
+	return marshaler.SliceSeparator
+}
+
+// Returns the separator used to split the keys and values of map entries. The separator of
+// the field being parsed, if any, takes precedence over the KeyValueSeparator.
+func (marshaler *DefaultParser) keyValueSeparator() string {
+	if marshaler.field != nil && marshaler.field.kvSeparator != "" {
+		return marshaler.field.kvSeparator
+	}
+	if marshaler.KeyValueSeparator == "" {
+		return DefaultKeyValueSeparator
+	}
+	return marshaler.KeyValueSeparator
+}
+
+// Trims a string value, either of surrounding whitespace or, if the field being parsed has
+// the trimnewline option, of trailing newlines only.
+func (marshaler *DefaultParser) trimString(str string) string {
+	if marshaler.field != nil && marshaler.field.trimNewline {
+		return strings.TrimRight(str, "\r\n")
+	}
+	return strings.TrimSpace(str)
+}
+
+// Parses a boolean value, which must be one of the values of the true and false options of
+// the field being parsed, if it has them, and is otherwise parsed by parseBool.
+func (marshaler *DefaultParser) parseBool(str string) (bool, error) {
+	if marshaler.field == nil || marshaler.field.trueValue == "" {
+		return parseBool(str)
+	}
+
+	switch strings.TrimSpace(str) {
+	case marshaler.field.trueValue:
+		return true, nil
+	case marshaler.field.falseValue:
+		return false, nil
+	}
+	return false, errors.Errorf(
+		"expected %q for true or %q for false", marshaler.field.trueValue, marshaler.field.falseValue)
+}
+
+// Converts the case of a string value if the field being parsed has the lower or upper
+// option.
+func (marshaler *DefaultParser) convertCase(str string) string {
+	switch {
+	case marshaler.field == nil:
+		return str
+	case marshaler.field.lower:
+		return strings.ToLower(str)
+	case marshaler.field.upper:
+		return strings.ToUpper(str)
+	}
+	return str
+}
+
+// Determines whether or not structs are parsed from key=value entries, i.e. the field being
+// parsed has the kv option.
+func (marshaler *DefaultParser) keyValueRecords() bool {
+	return marshaler.field != nil && marshaler.field.keyValue
+}
+
+// Returns the separator used to split records into the values of their fields, given by
+// the fieldsep option of the field being parsed, or "" if records are not supported.
+func (marshaler *DefaultParser) fieldSeparator() string {
+	if marshaler.field == nil {
+		return ""
+	}
+	return marshaler.field.fieldSeparator
+}
 
 // ParseType - Parses a string value for a specific type given by reflect.Type.
 // For example, ParseType might accept str="2" and reflect.Type=reflect.Uint
 // and parses the uint value of 2 returned as reflect.Value.
 //
 // In this particular case, we parse all numeric types, pointers, strings,
-// booleans, arrays and slices. The method handles Durations differently, though
-// under the hood, the type is treated the same way as int64. In particular, we
-// parse durations of the form `1m3s` and more generally, expects the string to be
-// parse-able via ParseDuration.
+// booleans, arrays, slices and maps. Maps are parsed from separated key=value
+// entries, e.g. "a=1,b=2", and so are OrderedMaps, which retain the order of
+// the entries. StringSets are parsed from separated values, like slices, with
+// duplicate values collapsed. Decimals are parsed from decimal strings, e.g. "12.34",
+// without rounding. Integer types implementing BitValuer are parsed from separated bit
+// names, e.g. "read,write", whose values are ORed together. IP addresses, netip.Addr,
+// and address-port pairs, netip.AddrPort, e.g. "127.0.0.1:8080" or "[::1]:8080", are
+// parsed via netip.ParseAddr and netip.ParseAddrPort. Query strings, e.g. "a=1&b=2&b=3",
+// are parsed into url.Values via url.ParseQuery, which handles repeated keys and
+// percent-encoding. Regexps, regexp.Regexp, are compiled via regexp.Compile. Buffers,
+// bytes.Buffer, hold the raw bytes of the value, and io.Readers are strings.Readers over
+// it. The method handles Durations differently, though under the hood, the type is
+// treated the same way as int64. In particular, we parse durations of the form `1m3s`
+// and more generally, expects the string to be parse-able via ParseDuration. Durations
+// too large for time.Duration are reported with ErrDurationOverflow as their cause. Times are parsed with the first of the
+// TimeLayouts that succeeds, e.g. RFC3339 by default. Structs are parsed from records
+// only for fields with the fieldsep option, see Unmarshal. Booleans are parsed,
+// regardless of case, via strconv.ParseBool or from the words registered with
+// RegisterBoolWords.
+//
+// Types other than Durations and Times whose pointers implement encoding.TextUnmarshaler
+// are parsed via UnmarshalText; failing that, types whose pointers implement json.Unmarshaler
+// are parsed via UnmarshalJSON, with the raw string as the JSON fragment. Both take
+// precedence over parsing by kind.
+//
+// The empty string is parsed as an empty, non-nil slice or map, or as nil for fields with
+// the nilempty option.
+//
+// Numeric values are parsed with the bit size of the type, so that errors from
+// the strconv package, including out of range errors, are preserved as the cause
+// of the returned error and can be inspected via errors.As as a *strconv.NumError.
 //
 // If the object isn't one of the supported types, it throws an error.
 func (marshaler *DefaultParser) ParseType(str string, t reflect.Type) (reflect.Value, error) {
 	val := reflect.New(t).Elem()
+	err := marshaler.ParseInto(str, val)
+	return val, err
+}
+
+// ParseInto - Parses a string value into an existing, settable reflect.Value, supporting
+// the same types as ParseType. Unlike ParseType, ParseInto reuses the memory already held
+// by dst where it can, e.g. the backing array of a slice with enough capacity, or the
+// value referenced by a non-nil pointer.
+//
+// If parsing fails, dst may be partially written.
+func (marshaler *DefaultParser) ParseInto(str string, dst reflect.Value) error {
+	if !dst.CanSet() {
+		return errors.Errorf("cannot parse into an unsettable %s value", dst.Kind())
+	}
+
+	if handled, err := marshaler.decode(str, dst); handled || err != nil {
+		return err
+	}
+
+	t := dst.Type()
 	tName := t.Name()
 	tKind := t.Kind()
 
-	if tName == "Duration" {
-		// do duration stuff here
-		duration, err := time.ParseDuration(str)
+	if str == "" && marshaler.nilEmpty() && (tKind == reflect.Slice || tKind == reflect.Map) {
+		dst.Set(reflect.Zero(t))
+		return nil
+	}
+
+	if t == durationType {
+		duration, err := marshaler.parseDuration(str)
+		if err != nil {
+			return err
+		}
+		if err := marshaler.checkDurationSign(duration); err != nil {
+			return err
+		}
+
+		dst.SetInt(int64(duration))
+		return marshaler.checkBounds(dst)
+	} else if t == timeType {
+		t, err := marshaler.parseTime(str)
+		if err != nil {
+			return err
+		}
+
+		dst.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	if t == addrType {
+		addr, err := netip.ParseAddr(strings.TrimSpace(str))
+		if err != nil {
+			return errors.Wrapf(err, "Cannot convert %s to %s", str, t)
+		}
+		dst.Set(reflect.ValueOf(addr))
+		return nil
+	} else if t == addrPortType {
+		addrPort, err := netip.ParseAddrPort(strings.TrimSpace(str))
+		if err != nil {
+			return errors.Wrapf(err, "Cannot convert %s to %s", str, t)
+		}
+		dst.Set(reflect.ValueOf(addrPort))
+		return nil
+	}
+
+	if t == bytesBufferType {
+		buf := dst.Addr().Interface().(*bytes.Buffer)
+		buf.Reset()
+		buf.WriteString(str)
+		return nil
+	} else if t == ioReaderType {
+		dst.Set(reflect.ValueOf(strings.NewReader(str)))
+		return nil
+	}
+
+	if t == regexpType {
+		re, err := regexp.Compile(str)
 		if err != nil {
-			return val, errors.Wrapf(err, "could not parse duration \"%s\"", str)
+			return errors.Wrapf(err, "Cannot compile %s as a regexp", str)
 		}
+		dst.Set(reflect.ValueOf(re).Elem())
+		return nil
+	}
 
-		durVal := reflect.ValueOf(duration)
-		val.Set(durVal)
+	if t == urlValuesType {
+		values, err := url.ParseQuery(strings.TrimSpace(str))
+		if err != nil {
+			return errors.Wrapf(err, "Cannot convert %s to %s", str, t)
+		}
+		dst.Set(reflect.ValueOf(values))
+		return nil
+	}
 
-		return val, nil
-	} else if tName == "Time" {
-		t, err := time.Parse(time.RFC3339, str)
+	if t == orderedMapType {
+		orderedMap, err := marshaler.parseOrderedMap(str)
 		if err != nil {
-			return val, errors.Wrapf(err, "could not parse duration \"%s\"", str)
+			return err
 		}
+		dst.Set(reflect.ValueOf(orderedMap))
+		return nil
+	}
 
-		timeVal := reflect.ValueOf(t)
-		val.Set(timeVal)
-		return val, nil
+	if t == decimalType {
+		decimal, err := parseDecimal(strings.TrimSpace(str), marshaler.scale())
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.ValueOf(decimal))
+		return nil
+	}
+
+	if t == stringSetType {
+		stringSet, err := marshaler.parseStringSet(str)
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.ValueOf(stringSet))
+		return nil
+	}
+
+	if isBitmaskType(t) {
+		return marshaler.parseBits(str, dst)
+	}
+
+	if tKind != reflect.Ptr && tKind != reflect.Interface {
+		ptr := dst.Addr().Interface()
+		if textUnmarsh, ok := ptr.(encoding.TextUnmarshaler); ok {
+			if err := textUnmarsh.UnmarshalText([]byte(str)); err != nil {
+				return errors.Wrapf(err, "Cannot unmarshal text %s to %s", str, tName)
+			}
+			return nil
+		}
+
+		if jsonUnmarsh, ok := ptr.(json.Unmarshaler); ok {
+			if err := jsonUnmarsh.UnmarshalJSON([]byte(str)); err != nil {
+				return errors.Wrapf(err, "Cannot unmarshal JSON %s to %s", str, tName)
+			}
+			return nil
+		}
 	}
 
 	switch tKind {
 
 	case reflect.Ptr:
-		indirectVal, err := marshaler.ParseType(str, t.Elem())
-		if err != nil {
-			return val, err
+		if dst.IsNil() {
+			dst.Set(reflect.New(t.Elem()))
 		}
-		val.Set(indirectVal.Addr())
+		return marshaler.ParseInto(str, dst.Elem())
 
 	case reflect.String:
-		val.SetString(strings.TrimSpace(str))
+		str = marshaler.convertCase(marshaler.trimString(str))
+		if err := marshaler.checkPattern(str); err != nil {
+			return err
+		}
+		dst.SetString(str)
 
 	case reflect.Bool:
-		b, err := strconv.ParseBool(strings.ToLower(str))
+		b, err := marshaler.parseBool(str)
 		if err != nil {
-			return val, errors.Wrapf(err, "Cannot convert %s to a boolean value.", str)
+			return errors.Wrapf(err, "Cannot convert %s to a boolean value.", str)
 		}
-		val.SetBool(b)
+		dst.SetBool(b)
 
 	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
-		uintVal, convErr := strconv.ParseUint(str, 10, 64)
+		if err := marshaler.checkLeadingZeros(str); err != nil {
+			return err
+		}
+
+		var uintVal uint64
+		var convErr error
+		if marshaler.scientific() {
+			var floatVal float64
+			floatVal, convErr = parseScientific(str, t.Bits(), false)
+			uintVal = uint64(floatVal)
+		} else {
+			uintVal, convErr = strconv.ParseUint(str, marshaler.base(), t.Bits())
+		}
 		if convErr != nil {
-			return val, errors.Wrapf(
+			return errors.Wrapf(
 				convErr,
 				"Cannot convert %s to %s", str, tName)
 		}
 
-		if val.OverflowUint(uintVal) {
-			return val, errors.Errorf("The value %d overflows type %s", uintVal, tName)
-		}
-
-		val.SetUint(uintVal)
+		dst.SetUint(uintVal)
+		return marshaler.checkBounds(dst)
 
 	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
-		intVal, convErr := strconv.ParseInt(str, 10, 64)
+		if err := marshaler.checkLeadingZeros(str); err != nil {
+			return err
+		}
+
+		var intVal int64
+		var convErr error
+		if marshaler.scientific() {
+			var floatVal float64
+			floatVal, convErr = parseScientific(str, t.Bits(), true)
+			intVal = int64(floatVal)
+		} else {
+			intVal, convErr = strconv.ParseInt(str, marshaler.base(), t.Bits())
+		}
 		if convErr != nil {
-			return val, errors.Wrapf(
+			return errors.Wrapf(
 				convErr,
 				"Cannot convert %s to %s", str, tName)
 		}
 
-		if val.OverflowInt(intVal) {
-			return val, errors.Errorf("The value %d overflows type %s", intVal, tName)
-		}
-
-		val.SetInt(intVal)
+		dst.SetInt(intVal)
+		return marshaler.checkBounds(dst)
 
 	case reflect.Float32, reflect.Float64:
-		floatVal, convErr := strconv.ParseFloat(str, 64)
+		floatVal, convErr := strconv.ParseFloat(str, t.Bits())
 		if convErr != nil {
-			return val, errors.Wrapf(
+			return errors.Wrapf(
 				convErr,
 				"Cannot convert %s to %s", str, tName)
 		}
 
-		if val.OverflowFloat(floatVal) {
-			return val, errors.Errorf("The value %.4f overflows type %s", floatVal, tName)
-		}
-		val.SetFloat(floatVal)
+		dst.SetFloat(floatVal)
+		return marshaler.checkBounds(dst)
 
 	case reflect.Array, reflect.Slice:
-		var elts []string
+		// count the elements before parsing, so that huge values are rejected before
+		// they are allocated
+		numElts := marshaler.countElements(str)
+		if maxLen := marshaler.maxLen(); maxLen > 0 && numElts > maxLen {
+			return errors.Errorf(
+				"Expected at most %d elements for type %s, received %d", maxLen, t, numElts)
+		}
 
-		// it seems that "" makes more sense as a way to express an empty
-		// list than an element with nothing in it
-		if str == "" {
-			elts = []string{}
+		if tKind == reflect.Array {
+			if numElts != dst.Len() {
+				return errors.Errorf(
+					"Expected %d elements for type %s, received %d", dst.Len(), t, numElts)
+			}
+		} else if !dst.IsNil() && dst.Cap() >= numElts {
+			dst.SetLen(numElts)
 		} else {
-			elts = strings.Split(str, ",")
+			dst.Set(reflect.MakeSlice(t, numElts, numElts))
 		}
-		arrVal := reflect.MakeSlice(t, len(elts), len(elts))
-		eltType := t.Elem()
 
-		for i, elt := range elts {
-			trimmedElt := strings.TrimSpace(elt)
-			eltVal, marshalErr := marshaler.ParseType(trimmedElt, eltType)
+		return marshaler.eachElement(str, func(i int, elt string) error {
+			marshalErr := marshaler.ParseInto(elt, dst.Index(i))
 			if marshalErr != nil {
-				return val, errors.Wrapf(
+				return errors.Wrapf(
 					marshalErr,
 					"Could not marshal element %d", i)
 			}
-			arrVal.Index(i).Set(eltVal)
+			return nil
+		})
+
+	case reflect.Map:
+		mapVal, err := marshaler.parseMap(str, t)
+		if err != nil {
+			return err
+		}
+		dst.Set(mapVal)
+
+	case reflect.Struct:
+		if marshaler.keyValueRecords() {
+			return marshaler.parseKeyValueRecord(str, dst)
+		}
+		if marshaler.fieldSeparator() == "" {
+			return errors.Errorf("Cannot unmarshal objects of type %s without a field separator", tName)
 		}
-		val.Set(arrVal)
+		return marshaler.parseRecord(str, dst)
 
 	default:
-		return val, errors.Errorf("Cannot unmarshal objects of type %s", tName)
+		return errors.Errorf("Cannot unmarshal objects of type %s", tName)
+	}
+
+	return nil
+}
+
+// Returns the fields of a struct type that the values of a record map to, i.e. its exported
+// fields with env tags, in the order they are declared.
+func recordFields(t reflect.Type) []reflect.StructField {
+	fields := []reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		fieldStruct := t.Field(i)
+		if fieldStruct.PkgPath == "" && fieldStruct.Tag.Get("env") != "" {
+			fields = append(fields, fieldStruct)
+		}
+	}
+	return fields
+}
+
+// Parses a record, i.e. values split by the field separator, into a struct. The values map
+// positionally to the record fields of the struct, and each is parsed according to the tags
+// of its field. It returns an error unless there are as many values as record fields.
+func (marshaler *DefaultParser) parseRecord(str string, dst reflect.Value) error {
+	t := dst.Type()
+	fields := recordFields(t)
+	values := strings.Split(str, marshaler.fieldSeparator())
+	if len(values) != len(fields) {
+		return errors.Errorf(
+			"Expected %d fields for type %s, received %d", len(fields), t, len(values))
+	}
+	return marshaler.parseRecordValues(values, fields, dst)
+}
+
+// Parses a key=value entry into a struct with exactly two record fields: the key maps to
+// the first and the value to the second. The entry is split on the first key-value
+// separator only, so values may contain it.
+func (marshaler *DefaultParser) parseKeyValueRecord(str string, dst reflect.Value) error {
+	t := dst.Type()
+	fields := recordFields(t)
+	if len(fields) != 2 {
+		return errors.Errorf(
+			"Cannot unmarshal key%svalue entries into type %s: expected 2 fields with env tags, found %d",
+			marshaler.keyValueSeparator(), t, len(fields))
+	}
+
+	values := strings.SplitN(str, marshaler.keyValueSeparator(), 2)
+	if len(values) != 2 {
+		return errors.Errorf(
+			"Expected an entry of the form key%svalue for type %s, received %s", marshaler.keyValueSeparator(), t, str)
+	}
+	return marshaler.parseRecordValues(values, fields, dst)
+}
+
+// Parses the values of a record into the record fields of dst they map to positionally.
+func (marshaler *DefaultParser) parseRecordValues(values []string, fields []reflect.StructField, dst reflect.Value) error {
+	// the fields are parsed by their own options rather than those of the enclosing field
+	recordParser := *marshaler
+	recordParser.field = nil
+	for i, fieldStruct := range fields {
+		opts, err := parseFieldOptions(fieldStruct, "")
+		if err != nil {
+			return errors.Wrapf(err, "Invalid tags on field %s", fieldStruct.Name)
+		}
+
+		value := strings.TrimSpace(values[i])
+		if err := opts.fieldParser(&recordParser).ParseInto(value, dst.FieldByIndex(fieldStruct.Index)); err != nil {
+			return errors.Wrapf(err, "Could not marshal field %s", fieldStruct.Name)
+		}
+	}
+	return nil
+}
+
+// Rejects integers with insignificant leading zeros, e.g. 0080, if the field being parsed
+// has the strict option. A lone 0 is accepted.
+func (marshaler *DefaultParser) checkLeadingZeros(str string) error {
+	if marshaler.field == nil || !marshaler.field.strict {
+		return nil
+	}
+
+	digits := strings.TrimLeft(str, "+-")
+	if len(digits) > 1 && digits[0] == '0' {
+		return errors.Errorf("Cannot convert %s: leading zeros are not allowed", str)
+	}
+	return nil
+}
+
+// Parses a string value into dst with the first of the DecodeHooks that handles it, if any,
+// and returns whether or not any hook handled it.
+func (marshaler *DefaultParser) decode(str string, dst reflect.Value) (bool, error) {
+	t := dst.Type()
+	for _, hook := range marshaler.DecodeHooks {
+		decoded, err := hook(stringType, t, str)
+		if err != nil {
+			return true, errors.Wrapf(err, "Cannot decode %s to %s", str, t)
+		}
+		if decoded == nil {
+			continue
+		}
+
+		decodedVal := reflect.ValueOf(decoded)
+		switch {
+		case decodedVal.Type().AssignableTo(t):
+			dst.Set(decodedVal)
+		case decodedVal.Type().ConvertibleTo(t):
+			dst.Set(decodedVal.Convert(t))
+		default:
+			return true, errors.Errorf("Cannot decode %s to %s: hook returned %s", str, t, decodedVal.Type())
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// Parses an integer that may be written in scientific notation, e.g. 1e3, as a float. It
+// returns an error if the value is not a whole number, or is out of the range of a signed
+// or unsigned integer of bitSize bits, in which case the cause is a *strconv.NumError.
+func parseScientific(str string, bitSize int, signed bool) (float64, error) {
+	floatVal, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, err
+	}
+	if floatVal != math.Trunc(floatVal) {
+		return 0, errors.Errorf("%s is not a whole number", str)
+	}
+
+	min, max := 0.0, math.Ldexp(1, bitSize)
+	if signed {
+		min, max = -math.Ldexp(1, bitSize-1), math.Ldexp(1, bitSize-1)
+	}
+	if floatVal < min || floatVal >= max {
+		return 0, &strconv.NumError{Func: "ParseFloat", Num: str, Err: strconv.ErrRange}
+	}
+	return floatVal, nil
+}
+
+// ParseSliceFunc - Parses a string of separated values, as ParseType parses a slice, but
+// yields each element, parsed as elemType, to fn in turn rather than building the slice.
+// This allows long lists to be processed without holding all of their elements at once.
+// Parsing stops at the first error, either from parsing an element or from fn.
+//
+// Usage:
+//
+//	err := parser.ParseSliceFunc(hosts, reflect.TypeOf(""), func(host reflect.Value) error {
+//		return register(host.String())
+//	})
+func (marshaler *DefaultParser) ParseSliceFunc(str string, elemType reflect.Type, fn func(reflect.Value) error) error {
+	return marshaler.eachElement(str, func(i int, elt string) error {
+		eltVal, err := marshaler.ParseType(elt, elemType)
+		if err != nil {
+			return errors.Wrapf(err, "Could not marshal element %d", i)
+		}
+		return fn(eltVal)
+	})
+}
+
+// Trims a string of separated values as needed before it is split, returning the trimmed
+// string, the separator, and whether or not the string has any elements.
+func (marshaler *DefaultParser) trimElements(str string) (string, string, bool) {
+	separator := marshaler.sliceSeparator()
+	if separator == "\n" {
+		// a value read from a file usually ends with a newline, so trailing
+		// empty lines do not count as elements
+		str = strings.TrimRight(str, "\r\n")
+	}
+
+	// it seems that "" makes more sense as a way to express an empty
+	// list than an element with nothing in it
+	if str == "" {
+		return str, separator, false
+	}
+
+	if marshaler.field != nil && marshaler.field.trimTrailing && strings.HasSuffix(str, separator) {
+		// drop only the one empty element after the trailing separator, so that "a,b,"
+		// has two elements and "a,," or "," still keep their other empty elements
+		str = str[:len(str)-len(separator)]
+	}
+	return str, separator, true
+}
+
+// Counts the elements of a string of separated values.
+func (marshaler *DefaultParser) countElements(str string) int {
+	str, separator, ok := marshaler.trimElements(str)
+	if !ok {
+		return 0
+	}
+	return strings.Count(str, separator) + 1
+}
+
+// Calls fn with the index and the trimmed value of each element of a string of separated
+// values in turn, without splitting the string up front. It stops at the first error.
+func (marshaler *DefaultParser) eachElement(str string, fn func(i int, elt string) error) error {
+	str, separator, ok := marshaler.trimElements(str)
+	if !ok {
+		return nil
+	}
+
+	for i := 0; ; i++ {
+		end := strings.Index(str, separator)
+		if end < 0 {
+			return fn(i, strings.TrimSpace(str))
+		}
+		if err := fn(i, strings.TrimSpace(str[:end])); err != nil {
+			return err
+		}
+		str = str[end+len(separator):]
+	}
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+	addrType            = reflect.TypeOf(netip.Addr{})
+	addrPortType        = reflect.TypeOf(netip.AddrPort{})
+	urlValuesType       = reflect.TypeOf(url.Values{})
+	regexpType          = reflect.TypeOf(regexp.Regexp{})
+	bytesBufferType     = reflect.TypeOf(bytes.Buffer{})
+	ioReaderType        = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// Determines whether or not the parser delegates parsing values of a specific type to
+// the type's own UnmarshalText or UnmarshalJSON method.
+func implementsValueUnmarshaler(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return false
+	}
+
+	ptrType := reflect.PtrTo(t)
+	return ptrType.Implements(textUnmarshalerType) || ptrType.Implements(jsonUnmarshalerType)
+}
+
+// ErrDurationOverflow is the cause of errors parsing well-formed durations that are too large
+// to be represented by time.Duration, e.g. 9999999999h. Fields with the clamp option are
+// instead clamped to the largest (or smallest) representable duration.
+var ErrDurationOverflow = errors.New("duration overflows time.Duration")
+
+// matches the syntax of durations accepted by time.ParseDuration
+var durationSyntax = regexp.MustCompile(`^[-+]?(0|((\d+\.?\d*|\.\d+)(ns|us|µs|μs|ms|s|m|h))+)$`)
+
+// Parses a duration, distinguishing durations that overflow time.Duration from those that
+// are malformed.
+func (marshaler *DefaultParser) parseDuration(str string) (time.Duration, error) {
+	duration, err := time.ParseDuration(str)
+	if err == nil {
+		return duration, nil
+	}
+
+	if !durationSyntax.MatchString(str) {
+		return 0, errors.Wrapf(err, "could not parse duration \"%s\"", str)
+	}
+
+	if marshaler.field != nil && marshaler.field.clamp {
+		marshaler.field.clamped = true
+		if strings.HasPrefix(str, "-") {
+			return time.Duration(math.MinInt64), nil
+		}
+		return time.Duration(math.MaxInt64), nil
+	}
+
+	return 0, errors.Wrapf(ErrDurationOverflow, "could not parse duration \"%s\"", str)
+}
+
+// Checks a duration against the sign constraint of the field being parsed, if any.
+func (marshaler *DefaultParser) checkDurationSign(duration time.Duration) error {
+	if marshaler.field == nil {
+		return nil
+	}
+	if marshaler.field.nonNegative && duration < 0 {
+		return errors.Errorf("duration %s must not be negative", duration)
+	}
+	if marshaler.field.negative && duration >= 0 {
+		return errors.Errorf("duration %s must be negative", duration)
+	}
+	return nil
+}
+
+// Checks a parsed numeric value, or duration, against the bounds given by the min and max
+// tags of the field being parsed, if any. The bounds are parsed as the type of the value.
+func (marshaler *DefaultParser) checkBounds(v reflect.Value) error {
+	if marshaler.field == nil {
+		return nil
+	}
+
+	bounds := []struct {
+		name  string
+		bound string
+		sign  int
+	}{
+		{"min", marshaler.field.minValue, -1},
+		{"max", marshaler.field.maxValue, 1},
 	}
+	for _, b := range bounds {
+		if b.bound == "" {
+			continue
+		}
 
-	return val, nil
+		boundVal, err := (&DefaultParser{}).ParseType(b.bound, v.Type())
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s %s for type %s", b.name, b.bound, v.Type())
+		}
+		if compareNumbers(v, boundVal) == b.sign {
+			return errors.Errorf("%v is out of range: %s is %s", v.Interface(), b.name, b.bound)
+		}
+	}
+	return nil
+}
+
+// Compares two numeric values of the same type, returning -1, 0 or 1 if a is less than,
+// equal to, or greater than b.
+func compareNumbers(a, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		switch {
+		case a.Uint() < b.Uint():
+			return -1
+		case a.Uint() > b.Uint():
+			return 1
+		}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		switch {
+		case a.Int() < b.Int():
+			return -1
+		case a.Int() > b.Int():
+			return 1
+		}
+	case reflect.Float32, reflect.Float64:
+		switch {
+		case a.Float() < b.Float():
+			return -1
+		case a.Float() > b.Float():
+			return 1
+		}
+	}
+	return 0
+}
+
+// Parses a time using the layout and location of the field being parsed, if any. Times are
+// otherwise parsed with the first of the TimeLayouts that succeeds.
+func (marshaler *DefaultParser) parseTime(str string) (time.Time, error) {
+	layouts := marshaler.timeLayouts()
+	var location *time.Location
+	if marshaler.field != nil {
+		location = marshaler.field.timeLocation
+	}
+
+	var t time.Time
+	var err error
+	for _, layout := range layouts {
+		if location != nil {
+			t, err = time.ParseInLocation(layout, str, location)
+		} else {
+			t, err = time.Parse(layout, str)
+		}
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	if len(layouts) == 1 {
+		return t, errors.Wrapf(err, "could not parse time \"%s\"", str)
+	}
+	return t, errors.Errorf("could not parse time \"%s\" with any of the layouts %q", str, layouts)
+}
+
+// Returns the layouts with which times are parsed, in order: the layout of the field being
+// parsed, if any, or else the TimeLayouts.
+func (marshaler *DefaultParser) timeLayouts() []string {
+	if marshaler.field != nil && marshaler.field.timeLayout != "" {
+		return []string{marshaler.field.timeLayout}
+	}
+	if len(marshaler.TimeLayouts) == 0 {
+		return DefaultTimeLayouts
+	}
+	return marshaler.TimeLayouts
+}
+
+// Splits a string of separated key=value entries, e.g. "a=1,b=2", into trimmed keys and
+// values, in the order in which they appear.
+func (marshaler *DefaultParser) splitEntries(str string) ([][2]string, error) {
+	// similar to slices, "" expresses an empty map
+	if str == "" {
+		return [][2]string{}, nil
+	}
+
+	kvSeparator := marshaler.keyValueSeparator()
+	entries := strings.Split(str, marshaler.sliceSeparator())
+	kvs := make([][2]string, len(entries))
+	for i, entry := range entries {
+		kv := strings.SplitN(entry, kvSeparator, 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("Entry %d (%s) is not of the form key%svalue", i, entry, kvSeparator)
+		}
+		kvs[i] = [2]string{strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])}
+	}
+
+	return kvs, nil
+}
+
+// Parses a map from a string of separated key=value entries, e.g. "a=1,b=2". Both keys
+// and values are parsed according to the key and element types of the map.
+func (marshaler *DefaultParser) parseMap(str string, t reflect.Type) (reflect.Value, error) {
+	mapVal := reflect.MakeMap(t)
+	kvs, err := marshaler.splitEntries(str)
+	if err != nil {
+		return mapVal, err
+	}
+
+	for i, kv := range kvs {
+		keyVal, err := marshaler.ParseType(kv[0], t.Key())
+		if err != nil {
+			return mapVal, errors.Wrapf(err, "Could not marshal key of entry %d", i)
+		}
+
+		eltVal, err := marshaler.ParseType(kv[1], t.Elem())
+		if err != nil {
+			return mapVal, errors.Wrapf(err, "Could not marshal value of entry %d", i)
+		}
+
+		mapVal.SetMapIndex(keyVal, eltVal)
+	}
+
+	return mapVal, nil
+}
+
+// Parses an OrderedMap from a string of separated key=value entries, retaining the order
+// of the entries.
+func (marshaler *DefaultParser) parseOrderedMap(str string) (OrderedMap, error) {
+	kvs, err := marshaler.splitEntries(str)
+	if err != nil {
+		return nil, err
+	}
+
+	orderedMap := make(OrderedMap, len(kvs))
+	for i, kv := range kvs {
+		orderedMap[i] = MapEntry{Key: marshaler.convertCase(kv[0]), Value: marshaler.convertCase(kv[1])}
+	}
+	return orderedMap, nil
+}
+
+// Parses a StringSet from a string of separated values, collapsing duplicate values.
+func (marshaler *DefaultParser) parseStringSet(str string) (StringSet, error) {
+	var values []string
+	if err := marshaler.ParseInto(str, reflect.ValueOf(&values).Elem()); err != nil {
+		return nil, err
+	}
+
+	stringSet := make(StringSet, len(values))
+	for _, val := range values {
+		stringSet[val] = struct{}{}
+	}
+	return stringSet, nil
 }
 
 // Unmarshal - Unmarshals a string into any one of the string-parseable types, which include
-// (pointers of) numeric types, strings, booleans, arrays and slices. The method also
+// (pointers of) numeric types, strings, booleans, arrays, slices and maps. The method also
 // handles Duration separately.
 //
 // The method throws an error if the underlying interface is unsettable (see
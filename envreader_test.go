@@ -1,9 +1,16 @@
 package goenv
 
 import (
+	"github.com/pkg/errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 type LookupEnvMock struct {
@@ -222,3 +229,558 @@ func TestOsEnvReader_HasKeys(t *testing.T) {
 		}
 	}
 }
+
+func TestJSONEnvReader_LookupEnv(t *testing.T) {
+	json := `{
+		"DB_HOST": "localhost",
+		"DB_PORT": 5432,
+		"DB_RATIO": 0.25,
+		"DEBUG": true,
+		"HOSTS": ["a", "b", 3],
+		"EMPTY": "",
+		"NOTHING": null,
+		"CACHE": {
+			"TTL": "12m",
+			"REGION": {
+				"NAME": "us-east"
+			}
+		}
+	}`
+
+	envReader, err := NewJSONEnvReader(strings.NewReader(json))
+	if err != nil {
+		t.Fatalf("Should not get error when reading JSON. Error: %s", err.Error())
+	}
+
+	testCases := []struct {
+		Key      string
+		HasKey   bool
+		Expected string
+	}{
+		{"DB_HOST", true, "localhost"},
+		{"DB_PORT", true, "5432"},
+		{"DB_RATIO", true, "0.25"},
+		{"DEBUG", true, "true"},
+		{"HOSTS", true, "a,b,3"},
+		{"EMPTY", true, ""},
+		{"NOTHING", false, ""},
+		{"CACHE_TTL", true, "12m"},
+		{"CACHE_REGION_NAME", true, "us-east"},
+		{"CACHE", false, ""},
+		{"CACHE_REGION", false, ""},
+	}
+
+	for i, c := range testCases {
+		val, exists := envReader.LookupEnv(c.Key)
+
+		if exists != c.HasKey {
+			t.Errorf("TC %d: Does key %s have value? Expected %t, actual %t",
+				i,
+				c.Key,
+				c.HasKey,
+				exists,
+			)
+		}
+
+		if c.HasKey && val != c.Expected {
+			t.Errorf("TC %d: Expect value to be %s, actual %s",
+				i,
+				c.Expected,
+				val,
+			)
+		}
+	}
+
+	hasKeys, missingKeys := envReader.HasKeys([]string{"DB_HOST", "CACHE_TTL", "NOTHING"})
+	if hasKeys || !sameKeys(missingKeys, []string{"NOTHING"}) {
+		t.Errorf("Expect missing keys [NOTHING], actual %v", missingKeys)
+	}
+}
+
+func TestJSONEnvReader_Unmarshal(t *testing.T) {
+	envReader, err := NewJSONEnvReader(strings.NewReader(
+		`{"APP": {"NAME": "silly-app", "PORT": 8080, "TAGS": ["x", "y"]}}`,
+	))
+	if err != nil {
+		t.Fatalf("Should not get error when reading JSON. Error: %s", err.Error())
+	}
+
+	config := struct {
+		App struct {
+			Name string   `env:"NAME"`
+			Port uint16   `env:"PORT"`
+			Tags []string `env:"TAGS"`
+		} `env:"APP_"`
+	}{}
+	marshaler := DefaultEnvMarshaler{Environment: envReader}
+	if err := marshaler.Unmarshal(&config); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if config.App.Name != "silly-app" || config.App.Port != 8080 ||
+		!reflect.DeepEqual(config.App.Tags, []string{"x", "y"}) {
+		t.Errorf("Unexpected config %+v", config)
+	}
+}
+
+func TestNewJSONEnvReaderFail(t *testing.T) {
+	cases := []string{
+		"",
+		"[1, 2, 3]",
+		`{"A": `,
+		`{"A": [[1, 2]]}`,
+		`{"A": [{"B": 1}]}`,
+		`{"A": [null]}`,
+	}
+
+	for _, c := range cases {
+		if _, err := NewJSONEnvReader(strings.NewReader(c)); err == nil {
+			t.Errorf("Expect an error when reading %s", c)
+		}
+	}
+}
+
+func TestOsEnvReader_Keys(t *testing.T) {
+	envReader := OsEnvReader{
+		environ: func() []string {
+			return []string{"A=hello", "B=", "C=x=y"}
+		},
+	}
+
+	keys := envReader.Keys()
+	if !sameKeys(keys, []string{"A", "B", "C"}) {
+		t.Errorf("Expect keys [A B C], actual %v", keys)
+	}
+}
+
+func TestOsEnvReader_KeysWithPrefix(t *testing.T) {
+	environ := func() []string {
+		return []string{"APP_HOST=x", "APP_PORT=80", "APPLE=1", "app_debug=true", "HOME=/root", "AP=2"}
+	}
+	posixReader := OsEnvReader{environ: environ}
+	windowsReader := OsEnvReader{environ: environ, caseInsensitive: true}
+
+	keys := posixReader.KeysWithPrefix("APP_")
+	if !sameKeys(keys, []string{"APP_HOST", "APP_PORT"}) {
+		t.Errorf("Expect keys [APP_HOST APP_PORT], actual %v", keys)
+	}
+
+	keys = windowsReader.KeysWithPrefix("APP_")
+	if !sameKeys(keys, []string{"APP_HOST", "APP_PORT", "app_debug"}) {
+		t.Errorf("Expect keys [APP_HOST APP_PORT app_debug], actual %v", keys)
+	}
+
+	if keys := posixReader.KeysWithPrefix("MISSING_"); len(keys) != 0 {
+		t.Errorf("Expect no keys, actual %v", keys)
+	}
+}
+
+func TestOsEnvReader_CaseInsensitive(t *testing.T) {
+	environ := map[string]string{"Path": "C:\\Windows", "GOPATH": "C:\\Go"}
+	lookup := func(key string) (string, bool) {
+		val, ok := environ[key]
+		return val, ok
+	}
+	environList := func() []string {
+		return []string{"Path=C:\\Windows", "GOPATH=C:\\Go"}
+	}
+
+	windowsReader := OsEnvReader{lookup: lookup, environ: environList, caseInsensitive: true}
+	posixReader := OsEnvReader{lookup: lookup, environ: environList}
+
+	cases := []struct {
+		Key           string
+		WindowsValue  string
+		WindowsExists bool
+		PosixExists   bool
+	}{
+		{"Path", "C:\\Windows", true, true},
+		{"PATH", "C:\\Windows", true, false},
+		{"path", "C:\\Windows", true, false},
+		{"GoPath", "C:\\Go", true, false},
+		{"HOME", "", false, false},
+	}
+
+	for i, c := range cases {
+		val, ok := windowsReader.LookupEnv(c.Key)
+		if ok != c.WindowsExists || val != c.WindowsValue {
+			t.Errorf("TC %d: Expect %s=%s (%t) on Windows, actual %s (%t)", i, c.Key, c.WindowsValue, c.WindowsExists, val, ok)
+		}
+		if _, ok := posixReader.LookupEnv(c.Key); ok != c.PosixExists {
+			t.Errorf("TC %d: Expect %s to exist (%t) on POSIX, actual %t", i, c.Key, c.PosixExists, ok)
+		}
+	}
+
+	platformReader := NewPlatformOsEnvReader()
+	if platformReader.caseInsensitive != (runtime.GOOS == "windows") {
+		t.Errorf("Expect case-insensitive matching only on Windows, actual %t", platformReader.caseInsensitive)
+	}
+}
+
+func TestRefreshableEnvReader(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fetches := 0
+	var fetchErr error
+	envReader := NewRefreshableEnvReader(func() (map[string]string, error) {
+		fetches++
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		return map[string]string{"VERSION": strconv.Itoa(fetches)}, nil
+	}, time.Minute)
+	envReader.now = func() time.Time { return now }
+
+	expectVersion := func(tc string, version string, expectedFetches int) {
+		val, ok := envReader.LookupEnv("VERSION")
+		if !ok || val != version {
+			t.Errorf("%s: Expect VERSION=%s, actual %s (%t)", tc, version, val, ok)
+		}
+		if fetches != expectedFetches {
+			t.Errorf("%s: Expect %d fetches, actual %d", tc, expectedFetches, fetches)
+		}
+	}
+
+	expectVersion("first lookup", "1", 1)
+	now = now.Add(30 * time.Second)
+	expectVersion("within TTL", "1", 1)
+	if ok, missing := envReader.HasKeys([]string{"VERSION", "MISSING"}); ok || !reflect.DeepEqual(missing, []string{"MISSING"}) {
+		t.Errorf("Expect MISSING to be missing, actual %v", missing)
+	}
+	if fetches != 1 {
+		t.Errorf("Expect HasKeys to use the cache, actual %d fetches", fetches)
+	}
+
+	now = now.Add(30 * time.Second)
+	expectVersion("after expiry", "2", 2)
+
+	fetchErr = errors.New("unavailable")
+	now = now.Add(time.Minute)
+	expectVersion("failed refresh", "2", 3)
+	expectVersion("after failed refresh", "2", 3)
+	if envReader.Err() == nil {
+		t.Error("Expect the error of the failed refresh.")
+	}
+
+	fetchErr = nil
+	if err := envReader.Refresh(); err != nil {
+		t.Errorf("Refresh should not raise error. Error: %s", err.Error())
+	}
+	expectVersion("forced refresh", "4", 4)
+}
+
+func TestWatchDotEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, ".env")
+	if err := ioutil.WriteFile(path, []byte("LOG_LEVEL=info\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := make(chan EnvReader, 1)
+	stop, err := WatchDotEnv(path, 10*time.Millisecond, func(env EnvReader) {
+		changes <- env
+	})
+	if err != nil {
+		t.Fatalf("WatchDotEnv should not raise error. Error: %s", err.Error())
+	}
+	defer stop()
+
+	contents := "# reloaded\nexport LOG_LEVEL=debug\n\nWORKERS=4\nGREETING=\"hello world\"\nNAME='app'\nQUOTE=\"a'\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case env := <-changes:
+		if val, _ := env.LookupEnv("LOG_LEVEL"); val != "debug" {
+			t.Errorf("Expect LOG_LEVEL=debug, actual %s", val)
+		}
+		if val, _ := env.LookupEnv("WORKERS"); val != "4" {
+			t.Errorf("Expect WORKERS=4, actual %s", val)
+		}
+		if val, _ := env.LookupEnv("GREETING"); val != "hello world" {
+			t.Errorf("Expect GREETING=hello world, actual %s", val)
+		}
+		if val, _ := env.LookupEnv("NAME"); val != "app" {
+			t.Errorf("Expect NAME=app, actual %s", val)
+		}
+		if val, _ := env.LookupEnv("QUOTE"); val != `"a'` {
+			t.Errorf(`Expect QUOTE="a', actual %s`, val)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expect the callback to fire after the file changed.")
+	}
+
+	if _, err := WatchDotEnv(filepath.Join(dir, "missing"), time.Second, func(EnvReader) {}); err == nil {
+		t.Error("Expecting an error from watching a missing file.")
+	}
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if _, err := WatchDotEnv(path, interval, func(EnvReader) {}); err == nil {
+			t.Errorf("Expecting an error from watching every %s.", interval)
+		}
+	}
+}
+
+func TestStripPrefixEnvReader(t *testing.T) {
+	environ := []string{"APP_DB_HOST=db.local", "APP_DB_PORT=5432", "APP_NAME=api", "DB_HOST=other", "APPLE=1"}
+	osReader := &OsEnvReader{
+		lookup: func(key string) (string, bool) {
+			for _, keyVal := range environ {
+				if kv := strings.SplitN(keyVal, "=", 2); kv[0] == key {
+					return kv[1], true
+				}
+			}
+			return "", false
+		},
+		environ: func() []string { return environ },
+	}
+	args := NewArgsEnvReader(environ)
+
+	for i, env := range []EnvReader{osReader, args} {
+		envReader := NewStripPrefixEnvReader(env, "APP_")
+
+		if val, ok := envReader.LookupEnv("DB_HOST"); !ok || val != "db.local" {
+			t.Errorf("TC %d: Expect DB_HOST=db.local, actual %s (%t)", i, val, ok)
+		}
+		if _, ok := envReader.LookupEnv("APP_DB_HOST"); ok {
+			t.Errorf("TC %d: Expect APP_DB_HOST to be missing", i)
+		}
+		if ok, missing := envReader.HasKeys([]string{"DB_PORT", "NAME", "LE"}); ok || !reflect.DeepEqual(missing, []string{"LE"}) {
+			t.Errorf("TC %d: Expect LE to be missing, actual %v", i, missing)
+		}
+
+		enumerator, ok := envReader.(PrefixEnumerator)
+		if !ok {
+			t.Fatalf("TC %d: Expect the reader to enumerate its keys", i)
+		}
+		if keys := envReader.(EnvEnumerator).Keys(); !sameKeys(keys, []string{"DB_HOST", "DB_PORT", "NAME"}) {
+			t.Errorf("TC %d: Expect keys [DB_HOST DB_PORT NAME], actual %v", i, keys)
+		}
+		if keys := enumerator.KeysWithPrefix("DB_"); !sameKeys(keys, []string{"DB_HOST", "DB_PORT"}) {
+			t.Errorf("TC %d: Expect keys [DB_HOST DB_PORT], actual %v", i, keys)
+		}
+	}
+
+	// a reader that cannot enumerate its keys fails features that discover them
+	lookupOnly := NewStripPrefixEnvReader(&lookupOnlyEnvReader{args}, "APP_")
+	if _, ok := lookupOnly.(EnvEnumerator); ok {
+		t.Error("Expect a reader that cannot enumerate its keys not to implement EnvEnumerator")
+	}
+	if _, ok := lookupOnly.(PrefixEnumerator); ok {
+		t.Error("Expect a reader that cannot enumerate its keys not to implement PrefixEnumerator")
+	}
+	obj := struct {
+		DB []string `env:"DB_,collect"`
+	}{}
+	marsh := DefaultEnvMarshaler{Environment: lookupOnly}
+	if err := marsh.Unmarshal(&obj); err == nil {
+		t.Errorf("Expecting an error from collecting the keys of a reader that cannot enumerate them, actual %v", obj.DB)
+	}
+}
+
+func TestArgsEnvReader(t *testing.T) {
+	args := []string{"LOG_LEVEL=debug", "--verbose", "HOSTS=a,b", "EMPTY=", "URL=http://x?a=1", "LOG_LEVEL=warn", "=oops"}
+	envReader := NewArgsEnvReader(args)
+
+	cases := []struct {
+		Key    string
+		Value  string
+		Exists bool
+	}{
+		{"LOG_LEVEL", "warn", true},
+		{"HOSTS", "a,b", true},
+		{"EMPTY", "", true},
+		{"URL", "http://x?a=1", true},
+		{"--verbose", "", false},
+		{"", "", false},
+	}
+	for i, c := range cases {
+		val, ok := envReader.LookupEnv(c.Key)
+		if ok != c.Exists || val != c.Value {
+			t.Errorf("TC %d: Expect %s=%s (%t), actual %s (%t)", i, c.Key, c.Value, c.Exists, val, ok)
+		}
+	}
+	if keys := envReader.Keys(); !sameKeys(keys, []string{"LOG_LEVEL", "HOSTS", "EMPTY", "URL"}) {
+		t.Errorf("Expect keys [LOG_LEVEL HOSTS EMPTY URL], actual %v", keys)
+	}
+
+	if _, err := NewStrictArgsEnvReader(args); err == nil {
+		t.Error("Expect an error from a token without =")
+	}
+	strictReader, err := NewStrictArgsEnvReader(args[:1])
+	if err != nil {
+		t.Fatalf("Expect no error from valid tokens. Error: %s", err.Error())
+	}
+	if val, ok := strictReader.LookupEnv("LOG_LEVEL"); !ok || val != "debug" {
+		t.Errorf("Expect LOG_LEVEL=debug, actual %s (%t)", val, ok)
+	}
+}
+
+func TestChainedEnvReader(t *testing.T) {
+	args := NewArgsEnvReader([]string{"LOG_LEVEL=debug"})
+	env := &MockEnvReader{map[string]string{"LOG_LEVEL": "info", "PORT": "8080"}}
+	lookupOnly := &lookupOnlyEnvReader{&MockEnvReader{map[string]string{"REGION": "us-east-1"}}}
+	envReader := NewChainedEnvReader(args, env, lookupOnly)
+
+	marsh := DefaultEnvMarshaler{Environment: envReader}
+	obj := struct {
+		LogLevel string `env:"LOG_LEVEL"`
+		Port     int    `env:"PORT"`
+		Region   string `env:"REGION"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if obj.LogLevel != "debug" || obj.Port != 8080 || obj.Region != "us-east-1" {
+		t.Errorf("Unexpected config %+v", obj)
+	}
+
+	if ok, missing := envReader.HasKeys([]string{"PORT", "REGION", "HOST"}); ok || !reflect.DeepEqual(missing, []string{"HOST"}) {
+		t.Errorf("Expect HOST to be missing, actual %v", missing)
+	}
+	if keys := envReader.Keys(); !sameKeys(keys, []string{"LOG_LEVEL", "PORT"}) {
+		t.Errorf("Expect keys [LOG_LEVEL PORT], actual %v", keys)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := &MockEnvReader{map[string]string{
+		"SAME":      "1",
+		"DIFFERENT": "a",
+		"ONLY_A":    "x",
+		"EMPTY_A":   "",
+	}}
+	b := &MockEnvReader{map[string]string{
+		"SAME":      "1",
+		"DIFFERENT": "b",
+		"ONLY_B":    "y",
+	}}
+
+	diff := Diff(a, b, []string{"SAME", "DIFFERENT", "ONLY_A", "ONLY_B", "EMPTY_A", "NEITHER"})
+
+	deref := func(s *string) string {
+		if s == nil {
+			return "<missing>"
+		}
+		return *s
+	}
+
+	expected := map[string][2]string{
+		"DIFFERENT": {"a", "b"},
+		"ONLY_A":    {"x", "<missing>"},
+		"ONLY_B":    {"<missing>", "y"},
+		"EMPTY_A":   {"", "<missing>"},
+	}
+	if len(diff) != len(expected) {
+		t.Errorf("Expected %d differing keys, actual %d", len(expected), len(diff))
+	}
+
+	for key, pair := range expected {
+		actual, ok := diff[key]
+		if !ok {
+			t.Errorf("Expected %s to differ", key)
+			continue
+		}
+
+		if deref(actual[0]) != pair[0] || deref(actual[1]) != pair[1] {
+			t.Errorf("Expected %s to differ by %v, actual [%s %s]",
+				key, pair, deref(actual[0]), deref(actual[1]),
+			)
+		}
+	}
+}
+
+// BatchEnvReaderMock is a BatchEnvReader that counts its lookups.
+type BatchEnvReaderMock struct {
+	MockEnvReader
+	Batches [][]string
+	Lookups []string
+}
+
+func (env *BatchEnvReaderMock) LookupEnv(key string) (string, bool) {
+	env.Lookups = append(env.Lookups, key)
+	return env.MockEnvReader.LookupEnv(key)
+}
+
+func (env *BatchEnvReaderMock) LookupEnvN(keys []string) map[string]string {
+	env.Batches = append(env.Batches, keys)
+	values := map[string]string{}
+	for _, key := range keys {
+		if val, ok := env.MockEnvReader.LookupEnv(key); ok {
+			values[key] = val
+		}
+	}
+	return values
+}
+
+func TestBatchEnvReader(t *testing.T) {
+	env := &BatchEnvReaderMock{
+		MockEnvReader: MockEnvReader{map[string]string{
+			"APP_NAME":        "app",
+			"APP_BIND_HOST":   "0.0.0.0",
+			"APP_DB_HOST":     "db.local",
+			"APP_DB_PORT":     "5432",
+			"APP_ORIGIN_1":    "a.local",
+			"APP_ORIGIN_2":    "b.local",
+			"APP_METRICS_ON":  "false",
+			"APP_METRICS_URL": "statsd.local",
+		}},
+	}
+	marsh := DefaultEnvMarshaler{Environment: env}
+
+	obj := struct {
+		Name          string `env:"NAME"`
+		AdvertiseHost string `env:"ADVERTISE_HOST" defaultFrom:"BIND_HOST"`
+		LogLevel      string `env:"LOG_LEVEL" default:"info"`
+		Database      *struct {
+			Host string `env:"HOST"`
+			Port int    `env:"PORT"`
+		} `env:"DB_"`
+		Metrics struct {
+			URL string `env:"URL"`
+		} `env:"METRICS_" enabledBy:"METRICS_ON"`
+	}{}
+	if err := marsh.UnmarshalFrom("APP_", &obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	expectedBatches := [][]string{{
+		"APP_NAME",
+		"APP_ADVERTISE_HOST",
+		"APP_BIND_HOST",
+		"APP_LOG_LEVEL",
+		"APP_DB_HOST",
+		"APP_DB_PORT",
+		"APP_METRICS_ON",
+		"APP_METRICS_URL",
+	}}
+	if !reflect.DeepEqual(env.Batches, expectedBatches) {
+		t.Errorf("Expected a single batch %v, actual %v", expectedBatches, env.Batches)
+	}
+	if len(env.Lookups) != 0 {
+		t.Errorf("Expected no lookups outside of the batch, actual %v", env.Lookups)
+	}
+	if obj.Name != "app" || obj.AdvertiseHost != "0.0.0.0" || obj.LogLevel != "info" ||
+		obj.Database == nil || obj.Database.Port != 5432 || obj.Metrics.URL != "" {
+		t.Errorf("Unexpected config %+v", obj)
+	}
+
+	// keys discovered while unmarshalling fall back to LookupEnv
+	collected := struct {
+		Origins []string `env:"ORIGIN_,collect"`
+	}{}
+	env.Batches = nil
+	if err := marsh.UnmarshalFrom("APP_", &collected); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(collected.Origins, []string{"a.local", "b.local"}) {
+		t.Errorf("Expected [a.local b.local], actual %v", collected.Origins)
+	}
+	if !reflect.DeepEqual(env.Lookups, []string{"APP_ORIGIN_1", "APP_ORIGIN_2"}) {
+		t.Errorf("Expected lookups of the collected keys, actual %v", env.Lookups)
+	}
+}
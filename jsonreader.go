@@ -0,0 +1,120 @@
+package goenv
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+)
+
+// JSONEnvReader is an environment variable reader that implements the EnvReader interface
+// by looking up values from a JSON object, so that the same config can be unmarshalled
+// from either environment variables or a JSON file.
+//
+// Numbers and booleans are exposed in their string forms, arrays of scalars are joined
+// with commas and nested objects are flattened by joining keys with underscores, so that
+//
+//	{"DB": {"HOST": "localhost", "PORT": 5432}}
+//
+// exposes DB_HOST=localhost and DB_PORT=5432. Null values are treated as missing.
+type JSONEnvReader struct {
+	values map[string]string
+}
+
+// NewJSONEnvReader creates a new instance of JSONEnvReader by decoding a JSON object
+// from r. It returns an error if r does not contain a JSON object, or if the object
+// contains arrays of non-scalar values.
+func NewJSONEnvReader(r io.Reader) (*JSONEnvReader, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+
+	var obj map[string]interface{}
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, errors.Wrap(err, "cannot decode JSON object")
+	}
+
+	values := map[string]string{}
+	if err := flattenJSON("", obj, values); err != nil {
+		return nil, err
+	}
+
+	return &JSONEnvReader{
+		values: values,
+	}, nil
+}
+
+// Flattens a decoded JSON object into values, joining nested keys with underscores.
+func flattenJSON(prefix string, obj map[string]interface{}, values map[string]string) error {
+	for key, val := range obj {
+		if nested, ok := val.(map[string]interface{}); ok {
+			if err := flattenJSON(prefix+key+"_", nested, values); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if val == nil {
+			continue
+		}
+
+		str, err := jsonScalarString(val)
+		if err != nil {
+			return errors.Wrapf(err, "cannot read JSON key %s", prefix+key)
+		}
+		values[prefix+key] = str
+	}
+
+	return nil
+}
+
+// Converts a decoded JSON scalar, or an array of scalars, into its string form.
+func jsonScalarString(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	case []interface{}:
+		elts := make([]string, len(v))
+		for i, elt := range v {
+			if _, isArr := elt.([]interface{}); isArr || elt == nil {
+				return "", errors.Errorf("unsupported array element %d", i)
+			}
+			str, err := jsonScalarString(elt)
+			if err != nil {
+				return "", errors.Wrapf(err, "unsupported array element %d", i)
+			}
+			elts[i] = str
+		}
+		return strings.Join(elts, DefaultSliceSeparator), nil
+	}
+
+	return "", errors.Errorf("unsupported JSON value %v", val)
+}
+
+// LookupEnv - Lookup a certain key in the JSON object. Returns the string form of the value
+// if the key exists. Otherwise, returns an unspecific value, and the exists flag is set to false.
+func (env *JSONEnvReader) LookupEnv(key string) (string, bool) {
+	val, ok := env.values[key]
+	return val, ok
+}
+
+// HasKeys - Returns whether or not a set of keys have corresponding values in the JSON object
+// along with a list of keys that do not have values.
+func (env *JSONEnvReader) HasKeys(keys []string) (bool, []string) {
+	return hasKeys(env, keys)
+}
+
+// Keys - Returns the keys of all values in the JSON object.
+func (env *JSONEnvReader) Keys() []string {
+	keys := make([]string, 0, len(env.values))
+	for key := range env.values {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,114 @@
+package goenv
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// The field that first claimed an env key while linting, and the nearest enclosing struct
+// without a prefix, if any.
+type lintKeyOwner struct {
+	path       string
+	unprefixed string
+}
+
+// Lint - Inspects the type of a struct, or a pointer to a struct, and returns warnings about
+// its env tags that would cause Unmarshal to fail or to behave surprisingly. Lint does not
+// consult the environment, and is intended to be called from tests, e.g.
+//
+//	func TestConfigTags(t *testing.T) {
+//		for _, warning := range goenv.Lint(&Config{}) {
+//			t.Error(warning)
+//		}
+//	}
+//
+// It warns about duplicate env keys, env tags on unexported fields, fields of unsupported
+// kinds such as funcs and channels, nested structs without a prefix whose keys collide
+// with other fields', malformed tags, and required fields with a default tag.
+func Lint(i interface{}) []string {
+	t := reflect.TypeOf(i)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return []string{fmt.Sprintf("cannot lint non-struct type %v", t)}
+	}
+
+	warnings := []string{}
+	lintStruct(t, "", "", "", map[string]lintKeyOwner{}, map[string]bool{}, &warnings)
+	return warnings
+}
+
+// Appends the warnings about the fields of a struct type, nested under envPrefix and path,
+// to warnings. The keys claimed by fields so far are tracked by owners, and the unprefixed
+// structs already warned about by collided.
+func lintStruct(
+	t reflect.Type,
+	envPrefix string,
+	path string,
+	unprefixed string,
+	owners map[string]lintKeyOwner,
+	collided map[string]bool,
+	warnings *[]string,
+) {
+	for i := 0; i < t.NumField(); i++ {
+		fieldStruct := t.Field(i)
+		if fieldStruct.Tag.Get("env") == "" && !isPromotedStruct(fieldStruct) || isIgnoredField(fieldStruct) {
+			continue
+		}
+
+		fieldPath := fieldStruct.Name
+		if path != "" {
+			fieldPath = path + "." + fieldStruct.Name
+		}
+
+		if fieldStruct.PkgPath != "" {
+			*warnings = append(*warnings, fmt.Sprintf("field %s is unexported and cannot be unmarshalled", fieldPath))
+			continue
+		}
+
+		opts, err := parseFieldOptions(fieldStruct, envPrefix)
+		if err != nil {
+			*warnings = append(*warnings, fmt.Sprintf("field %s has invalid tags: %s", fieldPath, err))
+			continue
+		}
+
+		fieldType := indirectType(fieldStruct.Type)
+		if isUnsupportedKind(fieldType.Kind()) && !isLazyFuncType(fieldType) {
+			*warnings = append(*warnings, fmt.Sprintf("field %s is of unsupported kind %s", fieldPath, fieldType.Kind()))
+			continue
+		}
+
+		if opts.required && opts.hasDefault {
+			*warnings = append(*warnings, fmt.Sprintf("field %s is required but has a default, which is ignored", fieldPath))
+		}
+
+		if opts.key == NoEnvKey || opts.raw {
+			continue
+		}
+
+		fieldEnvTag := opts.envKey(envPrefix)
+		if !opts.collect && !opts.split && !reflect.PtrTo(fieldType).Implements(envUnmarshalerType) && isStructType(fieldType) {
+			fieldUnprefixed := unprefixed
+			if opts.key == "" {
+				fieldUnprefixed = fieldPath
+			}
+			lintStruct(fieldType, fieldEnvTag, fieldPath, fieldUnprefixed, owners, collided, warnings)
+			continue
+		}
+
+		owner, claimed := owners[fieldEnvTag]
+		if !claimed {
+			owners[fieldEnvTag] = lintKeyOwner{path: fieldPath, unprefixed: unprefixed}
+			continue
+		}
+
+		*warnings = append(*warnings, fmt.Sprintf("fields %s and %s share the env key %s", owner.path, fieldPath, fieldEnvTag))
+		for _, structPath := range []string{owner.unprefixed, unprefixed} {
+			if structPath != "" && !collided[structPath] {
+				collided[structPath] = true
+				*warnings = append(*warnings, fmt.Sprintf("struct field %s has no prefix and its keys collide with other fields'", structPath))
+			}
+		}
+	}
+}
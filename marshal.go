@@ -0,0 +1,435 @@
+package goenv
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvMarshaler is an interface for any object that defines the MarshalEnv method, i.e. a
+// method that emits the object as environment variables. It is the counterpart of
+// EnvUnmarshaler: the keys of a field implementing EnvMarshaler are prefixed with the
+// field's env tag, just as its UnmarshalEnv method looks up keys relative to it.
+type EnvMarshaler interface {
+	MarshalEnv() (map[string]string, error)
+}
+
+var envMarshalerType = reflect.TypeOf((*EnvMarshaler)(nil)).Elem()
+
+// Marshal - Marshals a given struct, or a pointer to one, into environment variables from
+// which Unmarshal would reproduce it, keyed by (normalized) env tags. Objects implementing
+// EnvMarshaler are marshalled by their MarshalEnv method.
+//
+// Values are formatted as the Parser would parse them, e.g. slices are joined by the
+// separator of their field, and times are formatted with the layout of their envFormat
+// tag. Nil pointers are omitted, as are bools with the presence option that are false.
+// The elements of slices with the collect option are keyed by their prefix and their
+// 1-based index, padded with zeros so that the keys sort in order. Lazy funcs are called,
+// and marshalled as the values they return.
+//
+// Usage:
+//
+//	vars, err := marshaller.Marshal(&config)
+//	for key, val := range vars {
+//		fmt.Printf("%s=%s\n", key, val)
+//	}
+func (marshaler *DefaultEnvMarshaler) Marshal(i interface{}) (map[string]string, error) {
+	v := reflect.ValueOf(i)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, errors.New("cannot marshal a nil pointer")
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil, errors.New("cannot marshal an invalid value")
+	}
+
+	vars := map[string]string{}
+	if v.Type().Implements(envMarshalerType) || reflect.PtrTo(v.Type()).Implements(envMarshalerType) {
+		return vars, marshaler.marshalEnvMarshaler(v, "", vars)
+	}
+
+	if v.Kind() != reflect.Struct {
+		return nil, errors.New("cannot marshal non-struct, non-EnvMarshaler objects")
+	}
+
+	return vars, marshaler.marshalStruct(v, "", vars)
+}
+
+// Marshals a value implementing EnvMarshaler into vars, prefixing its keys with envPrefix.
+func (marshaler *DefaultEnvMarshaler) marshalEnvMarshaler(v reflect.Value, envPrefix string, vars map[string]string) error {
+	if !v.Type().Implements(envMarshalerType) {
+		ptrVal := reflect.New(v.Type())
+		ptrVal.Elem().Set(v)
+		v = ptrVal
+	}
+
+	envVars, err := v.Interface().(EnvMarshaler).MarshalEnv()
+	if err != nil {
+		return errors.Wrapf(err, "cannot marshal %s from type %s", envPrefix, v.Type())
+	}
+	for key, val := range envVars {
+		vars[marshaler.normalizeKey(envPrefix+key)] = val
+	}
+	return nil
+}
+
+// Recursively marshals a struct into vars, prefixing its keys with envPrefix.
+func (marshaler *DefaultEnvMarshaler) marshalStruct(v reflect.Value, envPrefix string, vars map[string]string) error {
+	t := v.Type()
+	parser := marshaler.parser()
+	for i := 0; i < t.NumField(); i++ {
+		fieldStruct := t.Field(i)
+		if !marshaler.hasEnvKey(fieldStruct) {
+			continue
+		}
+
+		opts, err := marshaler.fieldOptions(fieldStruct, envPrefix)
+		if err != nil {
+			return errors.Wrapf(err, "invalid tags on field %s", fieldStruct.Name)
+		}
+		if opts.template != "" || opts.raw {
+			// computed from, or capturing, the variables of other fields
+			continue
+		}
+
+		fieldEnvTag := opts.envKey(envPrefix)
+		err = marshaler.marshalField(v.Field(i), fieldEnvTag, opts, opts.fieldParser(parser), vars)
+		if err != nil {
+			return errors.Wrapf(err, "error marshaling field %s", fieldStruct.Name)
+		}
+	}
+	return nil
+}
+
+// Marshals a field of a struct into vars.
+func (marshaler *DefaultEnvMarshaler) marshalField(
+	fieldVal reflect.Value,
+	fieldEnvTag string,
+	opts *fieldOptions,
+	parser *DefaultParser,
+	vars map[string]string,
+) error {
+	if fieldVal.Kind() == reflect.Interface {
+		if fieldVal.IsNil() {
+			return nil
+		}
+		fieldVal = fieldVal.Elem()
+	}
+	if fieldVal.Kind() == reflect.Ptr {
+		if fieldVal.IsNil() {
+			return nil
+		}
+		fieldVal = fieldVal.Elem()
+	}
+	fieldType := fieldVal.Type()
+	key := marshaler.normalizeKey(fieldEnvTag)
+
+	switch {
+	case opts.presence:
+		if fieldVal.Kind() != reflect.Bool {
+			return errors.Errorf("cannot marshal presence from non-bool type %s", fieldType)
+		}
+		if fieldVal.Bool() {
+			vars[key] = ""
+		}
+
+	case opts.collect:
+		if fieldVal.Kind() != reflect.Slice {
+			return errors.Errorf("cannot collect %s from non-slice type %s", fieldEnvTag, fieldType)
+		}
+		width := len(strconv.Itoa(fieldVal.Len()))
+		for i := 0; i < fieldVal.Len(); i++ {
+			str, err := parser.format(fieldVal.Index(i))
+			if err != nil {
+				return errors.Wrapf(err, "could not marshal element %d", i)
+			}
+			vars[marshaler.normalizeKey(fmt.Sprintf("%s%0*d", fieldEnvTag, width, i+1))] = str
+		}
+
+	case fieldType.Implements(envMarshalerType) || reflect.PtrTo(fieldType).Implements(envMarshalerType):
+		return marshaler.marshalEnvMarshaler(fieldVal, fieldEnvTag, vars)
+
+	case isAtomicType(fieldType):
+		loaded := loadAtomic(fieldVal)
+		if !loaded.IsValid() {
+			return nil
+		}
+		str, err := parser.format(loaded)
+		if err != nil {
+			return err
+		}
+		vars[key] = str
+
+	case isStructSliceType(fieldType) && !opts.isRecords():
+		for i := 0; i < fieldVal.Len(); i++ {
+			eltVal := fieldVal.Index(i)
+			if eltVal.Kind() == reflect.Ptr {
+				if eltVal.IsNil() {
+					continue
+				}
+				eltVal = eltVal.Elem()
+			}
+			if err := marshaler.marshalStruct(eltVal, fmt.Sprintf("%s%d_", fieldEnvTag, i), vars); err != nil {
+				return errors.Wrapf(err, "cannot marshal element %d", i)
+			}
+		}
+
+	case isStructMapType(fieldType):
+		iter := fieldVal.MapRange()
+		for iter.Next() {
+			name, err := parser.format(iter.Key())
+			if err != nil {
+				return errors.Wrapf(err, "could not marshal map key %v", iter.Key())
+			}
+
+			eltVal := iter.Value()
+			if eltVal.Kind() == reflect.Ptr {
+				if eltVal.IsNil() {
+					continue
+				}
+				eltVal = eltVal.Elem()
+			}
+			if err := marshaler.marshalStruct(eltVal, fieldEnvTag+name+"_", vars); err != nil {
+				return errors.Wrapf(err, "cannot marshal map value %s", name)
+			}
+		}
+
+	case fieldType.Kind() == reflect.Map && fieldType.Elem().Kind() == reflect.Map:
+		iter := fieldVal.MapRange()
+		for iter.Next() {
+			outer, err := parser.format(iter.Key())
+			if err != nil {
+				return errors.Wrapf(err, "could not marshal map key %v", iter.Key())
+			}
+
+			innerIter := iter.Value().MapRange()
+			for innerIter.Next() {
+				inner, err := parser.format(innerIter.Key())
+				if err != nil {
+					return errors.Wrapf(err, "could not marshal map key %v", innerIter.Key())
+				}
+				str, err := parser.format(innerIter.Value())
+				if err != nil {
+					return errors.Wrapf(err, "could not marshal value of %s_%s", outer, inner)
+				}
+				vars[marshaler.normalizeKey(fieldEnvTag+outer+"_"+inner)] = str
+			}
+		}
+
+	case opts.split && isStructType(fieldType):
+		entryVars := map[string]string{}
+		if err := marshaler.marshalStruct(fieldVal, "", entryVars); err != nil {
+			return err
+		}
+		entries := make([]string, 0, len(entryVars))
+		for entryKey, entryVal := range entryVars {
+			entries = append(entries, entryKey+parser.keyValueSeparator()+entryVal)
+		}
+		sort.Strings(entries)
+		vars[key] = strings.Join(entries, parser.sliceSeparator())
+
+	case isStructType(fieldType):
+		return marshaler.marshalStruct(fieldVal, fieldEnvTag, vars)
+
+	case isLazyFuncType(fieldType):
+		if fieldVal.IsNil() {
+			return nil
+		}
+		out := fieldVal.Call(nil)
+		if err, _ := out[1].Interface().(error); err != nil {
+			return err
+		}
+		str, err := parser.format(out[0])
+		if err != nil {
+			return err
+		}
+		vars[key] = str
+
+	default:
+		str, err := parser.format(fieldVal)
+		if err != nil {
+			return err
+		}
+		vars[key] = str
+	}
+
+	return nil
+}
+
+// Formats a value as a string that the parser would parse back into the value. Values are
+// formatted by their MarshalText or MarshalJSON method, if any, and otherwise by kind.
+func (marshaler *DefaultParser) format(v reflect.Value) (string, error) {
+	t := v.Type()
+	switch t {
+	case durationType:
+		return time.Duration(v.Int()).String(), nil
+	case timeType:
+		return marshaler.formatTime(v.Interface().(time.Time)), nil
+	case orderedMapType:
+		orderedMap := v.Interface().(OrderedMap)
+		entries := make([]string, len(orderedMap))
+		for i, entry := range orderedMap {
+			entries[i] = entry.Key + marshaler.keyValueSeparator() + entry.Value
+		}
+		return strings.Join(entries, marshaler.sliceSeparator()), nil
+	case bytesBufferType:
+		buf := v.Interface().(bytes.Buffer)
+		return buf.String(), nil
+	case urlValuesType:
+		return v.Interface().(url.Values).Encode(), nil
+	case regexpType:
+		re := v.Interface().(regexp.Regexp)
+		return re.String(), nil
+	case stringSetType:
+		return strings.Join(v.Interface().(StringSet).Values(), marshaler.sliceSeparator()), nil
+	}
+
+	if isBitmaskType(t) {
+		return marshaler.formatBits(v)
+	}
+
+	if v.CanInterface() {
+		if textMarsh, ok := v.Interface().(encoding.TextMarshaler); ok {
+			text, err := textMarsh.MarshalText()
+			return string(text), errors.Wrapf(err, "Cannot marshal text from %s", t)
+		}
+		if jsonMarsh, ok := v.Interface().(json.Marshaler); ok {
+			data, err := jsonMarsh.MarshalJSON()
+			return string(data), errors.Wrapf(err, "Cannot marshal JSON from %s", t)
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "", errors.Errorf("Cannot marshal a nil %s", t)
+		}
+		return marshaler.format(v.Elem())
+
+	case reflect.String:
+		return v.String(), nil
+
+	case reflect.Bool:
+		if field := marshaler.field; field != nil && field.trueValue != "" {
+			if v.Bool() {
+				return field.trueValue, nil
+			}
+			return field.falseValue, nil
+		}
+		return strconv.FormatBool(v.Bool()), nil
+
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		return strconv.FormatUint(v.Uint(), marshaler.base()), nil
+
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		return strconv.FormatInt(v.Int(), marshaler.base()), nil
+
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, t.Bits()), nil
+
+	case reflect.Array, reflect.Slice:
+		elts := make([]string, v.Len())
+		for i := range elts {
+			elt, err := marshaler.format(v.Index(i))
+			if err != nil {
+				return "", errors.Wrapf(err, "Could not marshal element %d", i)
+			}
+			elts[i] = elt
+		}
+		return strings.Join(elts, marshaler.sliceSeparator()), nil
+
+	case reflect.Map:
+		entries := make([]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := marshaler.format(iter.Key())
+			if err != nil {
+				return "", errors.Wrapf(err, "Could not marshal key %v", iter.Key())
+			}
+			val, err := marshaler.format(iter.Value())
+			if err != nil {
+				return "", errors.Wrapf(err, "Could not marshal value of key %s", key)
+			}
+			entries = append(entries, key+marshaler.keyValueSeparator()+val)
+		}
+		sort.Strings(entries)
+		return strings.Join(entries, marshaler.sliceSeparator()), nil
+
+	case reflect.Struct:
+		if marshaler.keyValueRecords() {
+			return marshaler.formatKeyValueRecord(v)
+		}
+		if marshaler.fieldSeparator() == "" {
+			break
+		}
+		return marshaler.formatRecord(v)
+	}
+
+	return "", errors.Errorf("Cannot marshal objects of type %s", t)
+}
+
+// Formats a time using the layout and location of the field being formatted, if any. Times
+// are otherwise formatted with the first of the TimeLayouts.
+func (marshaler *DefaultParser) formatTime(t time.Time) string {
+	if marshaler.field != nil && marshaler.field.timeLocation != nil {
+		t = t.In(marshaler.field.timeLocation)
+	}
+	return t.Format(marshaler.timeLayouts()[0])
+}
+
+// Formats a struct as a record, i.e. the values of its record fields joined by the field
+// separator, each formatted according to the tags of its field.
+func (marshaler *DefaultParser) formatRecord(v reflect.Value) (string, error) {
+	values, err := marshaler.formatRecordValues(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(values, marshaler.fieldSeparator()), nil
+}
+
+// Formats a struct with two record fields as a key=value entry.
+func (marshaler *DefaultParser) formatKeyValueRecord(v reflect.Value) (string, error) {
+	values, err := marshaler.formatRecordValues(v)
+	if err != nil {
+		return "", err
+	}
+	if len(values) != 2 {
+		return "", errors.Errorf(
+			"Cannot marshal type %s as key%svalue entries: expected 2 fields with env tags, found %d",
+			v.Type(), marshaler.keyValueSeparator(), len(values))
+	}
+	return values[0] + marshaler.keyValueSeparator() + values[1], nil
+}
+
+// Formats the values of the record fields of a struct, each according to the tags of its
+// field.
+func (marshaler *DefaultParser) formatRecordValues(v reflect.Value) ([]string, error) {
+	recordParser := *marshaler
+	recordParser.field = nil
+
+	fields := recordFields(v.Type())
+	values := make([]string, len(fields))
+	for i, fieldStruct := range fields {
+		opts, err := parseFieldOptions(fieldStruct, "")
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid tags on field %s", fieldStruct.Name)
+		}
+
+		values[i], err = opts.fieldParser(&recordParser).format(v.FieldByIndex(fieldStruct.Index))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not marshal field %s", fieldStruct.Name)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,76 @@
+package goenv
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// A field of a struct to unmarshal, with its options.
+type structField struct {
+	index int
+	opts  *fieldOptions
+}
+
+// Orders the fields of a struct, nested under envPrefix, so that fields come after the
+// fields whose keys their templates reference, and otherwise stay in the order they are
+// declared in. It returns an error if references form a cycle. The keys named by
+// defaultFrom tags are read from the environment rather than from fields, so that fields
+// may fall back to each other.
+func (marshaler *DefaultEnvMarshaler) dependencyOrder(fields []structField, envPrefix string) ([]structField, error) {
+	positions := map[string]int{}
+	for i, field := range fields {
+		if field.opts.key != NoEnvKey {
+			positions[marshaler.normalizeKey(field.opts.envKey(envPrefix))] = i
+		}
+	}
+
+	deps := make([][]int, len(fields))
+	hasDeps := false
+	for i, field := range fields {
+		for _, ref := range templateKeys(field.opts.template) {
+			if pos, ok := positions[marshaler.normalizeKey(ref)]; ok && pos != i {
+				deps[i] = append(deps[i], pos)
+				hasDeps = true
+			}
+		}
+	}
+	if !hasDeps {
+		return fields, nil
+	}
+
+	// repeatedly take the first field whose dependencies have all been taken
+	ordered := make([]structField, 0, len(fields))
+	taken := make([]bool, len(fields))
+	for len(ordered) < len(fields) {
+		next := -1
+		for i := range fields {
+			if !taken[i] && allTaken(deps[i], taken) {
+				next = i
+				break
+			}
+		}
+
+		if next < 0 {
+			cycle := []string{}
+			for i, field := range fields {
+				if !taken[i] {
+					cycle = append(cycle, field.opts.path)
+				}
+			}
+			return nil, errors.Errorf("cannot order fields %s: their references form a cycle", strings.Join(cycle, ", "))
+		}
+		taken[next] = true
+		ordered = append(ordered, fields[next])
+	}
+	return ordered, nil
+}
+
+// Determines whether or not all of the given fields have been taken.
+func allTaken(indices []int, taken []bool) bool {
+	for _, i := range indices {
+		if !taken[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package goenv
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// UnmarshalWithOverrides - Unmarshals a given value, like Unmarshal, with the values of
+// overrides, keyed by env keys, taking precedence over those of the Environment, e.g. to
+// inject values in tests. Only the overridden keys are resolved differently: fields whose
+// keys are not overridden are unmarshalled from the Environment as usual, including their
+// defaults. The overrides match keys regardless of case if the Environment does. The
+// marshaler itself, including its Environment, is left untouched, so that it can be
+// shared.
+//
+// Usage:
+//
+//	err := unmarshaller.UnmarshalWithOverrides(&config, map[string]string{
+//		"DB_HOST": "localhost",
+//	})
+func (marshaler *DefaultEnvMarshaler) UnmarshalWithOverrides(i interface{}, overrides map[string]string) error {
+	v := reflect.ValueOf(i)
+	if v.Kind() == reflect.Ptr {
+		v = reflect.Indirect(v)
+	}
+
+	// unmarshal with a copy of the marshaler, which holds the overrides of this Unmarshal
+	overridesMarshaler := *marshaler
+	overridesMarshaler.overrides = make(map[string]string, len(overrides))
+	for key, val := range overrides {
+		overridesMarshaler.overrides[marshaler.normalizeKey(key)] = val
+	}
+	return overridesMarshaler.UnmarshalValue(v)
+}
+
+// Looks up a key from the overrides of the UnmarshalWithOverrides in progress, if any.
+func (marshaler *DefaultEnvMarshaler) lookupOverride(key string) (string, bool) {
+	if len(marshaler.overrides) == 0 {
+		return "", false
+	}
+	if val, ok := marshaler.overrides[key]; ok || !isCaseInsensitive(marshaler.Environment) {
+		return val, ok
+	}
+
+	// fall back to the first override whose key matches regardless of case, in sorted order
+	for _, overrideKey := range sortedKeys(marshaler.overrides) {
+		if strings.EqualFold(overrideKey, key) {
+			return marshaler.overrides[overrideKey], true
+		}
+	}
+	return "", false
+}
+
+// Appends the keys of the overrides of the UnmarshalWithOverrides in progress, if any, that
+// start with a prefix and are not among the given keys of the environment.
+func (marshaler *DefaultEnvMarshaler) appendOverrideKeys(keys []string, prefix string) []string {
+	if len(marshaler.overrides) == 0 {
+		return keys
+	}
+
+	caseInsensitive := isCaseInsensitive(marshaler.Environment)
+	present := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if caseInsensitive {
+			key = strings.ToUpper(key)
+		}
+		present[key] = true
+	}
+
+	for _, key := range sortedKeys(marshaler.overrides) {
+		if len(key) < len(prefix) {
+			continue
+		}
+		if caseInsensitive {
+			if !strings.EqualFold(key[:len(prefix)], prefix) || present[strings.ToUpper(key)] {
+				continue
+			}
+		} else if key[:len(prefix)] != prefix || present[key] {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Determines whether or not an environment matches keys regardless of case.
+func isCaseInsensitive(env EnvReader) bool {
+	osEnv, ok := env.(*OsEnvReader)
+	return ok && osEnv.caseInsensitive
+}
+
+// Returns the keys of a map of strings in sorted order.
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,46 @@
+package goenv
+
+import (
+	"github.com/pkg/errors"
+	"regexp"
+	"sync"
+)
+
+// the regexps of pattern tags compiled so far, keyed by the pattern, so that each is
+// compiled only once however often its fields are unmarshalled
+var patterns = struct {
+	sync.RWMutex
+	values map[string]*regexp.Regexp
+}{values: map[string]*regexp.Regexp{}}
+
+// Compiles the regexp of a pattern tag, or returns it from the cache if it was compiled
+// before.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	patterns.RLock()
+	re, ok := patterns.values[pattern]
+	patterns.RUnlock()
+	if ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid pattern %s", pattern)
+	}
+
+	patterns.Lock()
+	defer patterns.Unlock()
+	patterns.values[pattern] = re
+	return re, nil
+}
+
+// Checks a parsed string against the pattern tag of the field being parsed, if any.
+func (marshaler *DefaultParser) checkPattern(str string) error {
+	if marshaler.field == nil || marshaler.field.pattern == nil {
+		return nil
+	}
+	if !marshaler.field.pattern.MatchString(str) {
+		return errors.Errorf("%s does not match the pattern %s", str, marshaler.field.pattern)
+	}
+	return nil
+}
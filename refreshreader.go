@@ -0,0 +1,113 @@
+package goenv
+
+import (
+	"sync"
+	"time"
+)
+
+// RefreshableEnvReader is an environment variable reader that implements the EnvReader
+// interface by caching the values returned by a fetch function, e.g. one that reads a file
+// or a remote store, for a TTL. The first lookup after the values expire fetches them
+// again, so that long-lived processes see fresh values without reloading explicitly:
+//
+//	env := NewRefreshableEnvReader(func() (map[string]string, error) {
+//		return readSecrets("/etc/app/secrets")
+//	}, time.Minute)
+//
+// If a fetch fails, the reader keeps serving the values it last fetched until the TTL
+// elapses again, or fetches again on the next lookup if it has none. It is safe for use by
+// multiple goroutines.
+type RefreshableEnvReader struct {
+	fetch func() (map[string]string, error)
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu      sync.Mutex
+	values  map[string]string
+	expires time.Time
+	err     error
+}
+
+// NewRefreshableEnvReader creates a new instance of RefreshableEnvReader that caches the
+// values returned by fetch for ttl. The values are fetched on the first lookup.
+func NewRefreshableEnvReader(fetch func() (map[string]string, error), ttl time.Duration) *RefreshableEnvReader {
+	return &RefreshableEnvReader{
+		fetch: fetch,
+		ttl:   ttl,
+		now:   time.Now,
+	}
+}
+
+// Refresh - Fetches the values regardless of whether or not they have expired. Returns the
+// error of the fetch, if any, in which case the values last fetched are kept.
+func (env *RefreshableEnvReader) Refresh() error {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	env.refresh()
+	return env.err
+}
+
+// Err - Returns the error of the last fetch, if it failed, and nil otherwise.
+func (env *RefreshableEnvReader) Err() error {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	return env.err
+}
+
+// Fetches the values, keeping those last fetched if the fetch fails. The caller must hold
+// the lock.
+func (env *RefreshableEnvReader) refresh() {
+	values, err := env.fetch()
+	env.expires = env.now().Add(env.ttl)
+	env.err = err
+	if err != nil {
+		return
+	}
+	if values == nil {
+		values = map[string]string{}
+	}
+	env.values = values
+}
+
+// Returns the cached values, fetching them first if they have expired.
+func (env *RefreshableEnvReader) cachedValues() map[string]string {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	if env.values == nil || !env.now().Before(env.expires) {
+		env.refresh()
+	}
+	return env.values
+}
+
+// LookupEnv - Looks up the value of a key from the cached values, fetching them first if
+// they have expired. Returns an unspecific value and false if the key is missing.
+func (env *RefreshableEnvReader) LookupEnv(key string) (string, bool) {
+	val, ok := env.cachedValues()[key]
+	return val, ok
+}
+
+// HasKeys - Returns whether or not a set of keys have values in the cached values, fetching
+// them at most once, along with a list of keys that do not.
+func (env *RefreshableEnvReader) HasKeys(keys []string) (bool, []string) {
+	values := env.cachedValues()
+	missingKeys := []string{}
+	for _, key := range keys {
+		if _, ok := values[key]; !ok {
+			missingKeys = append(missingKeys, key)
+		}
+	}
+	return len(missingKeys) == 0, missingKeys
+}
+
+// Keys - Returns the keys of all cached values, fetching them first if they have expired.
+func (env *RefreshableEnvReader) Keys() []string {
+	values := env.cachedValues()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,159 @@
+package goenv
+
+import (
+	"reflect"
+)
+
+// UnmarshalResult - Describes how the fields of a struct were unmarshalled by
+// UnmarshalWithResult, for diagnostics. Fields are identified by their paths from the root
+// struct, e.g. Database.Port.
+type UnmarshalResult struct {
+	// Keys maps the paths of fields set from the environment to the keys their values were
+	// read from, which are the keys named by their defaultFrom tags if their own are missing.
+	Keys map[string]string
+
+	// Defaulted lists the paths of the fields that fell back to the literal values of their
+	// default tags, in the order of the fields.
+	Defaulted []string
+
+	// Skipped lists the paths of the fields that were skipped, either because they were
+	// disabled by their enabledBy tags or because their values could not be parsed with
+	// SkipOnParseError set, in the order of the fields.
+	Skipped []string
+
+	// RawValues maps the paths of fields to the raw values they were parsed from, including
+	// the values of default tags. The values of secret fields are omitted.
+	RawValues map[string]string
+
+	// Sources maps the paths of fields to the sources their values were resolved from, in
+	// the order of precedence described by Unmarshal.
+	Sources map[string]ValueSource
+
+	// Warnings lists the non-fatal issues found while unmarshalling, in the order they were
+	// found, whether or not the marshaler has a Logger.
+	Warnings []Warning
+}
+
+// ValueSource - The source the value of a field was resolved from, see Unmarshal.
+type ValueSource string
+
+const (
+	// SourceEnv - The field's own variable.
+	SourceEnv ValueSource = "env"
+
+	// SourceDefaultFrom - The variable named by the field's defaultFrom tag.
+	SourceDefaultFrom ValueSource = "defaultFrom"
+
+	// SourceEnvironmentDefault - The field's default tag for the EnvironmentName, e.g.
+	// defaultProd.
+	SourceEnvironmentDefault ValueSource = "environmentDefault"
+
+	// SourceDefault - The field's default tag.
+	SourceDefault ValueSource = "default"
+
+	// SourceTemplate - The field's template tag.
+	SourceTemplate ValueSource = "template"
+
+	// SourceZero - None: the optional field was left at its zero value.
+	SourceZero ValueSource = "zero"
+)
+
+// WarningKind - The kind of issue a Warning describes.
+type WarningKind string
+
+const (
+	// WarningDeprecated - A variable with the deprecated option is set.
+	WarningDeprecated WarningKind = "deprecated"
+
+	// WarningDefaulted - A field fell back to the literal value of its default tag.
+	WarningDefaulted WarningKind = "defaulted"
+
+	// WarningSkipped - A field was skipped because its value could not be parsed, with
+	// SkipOnParseError set.
+	WarningSkipped WarningKind = "skipped"
+
+	// WarningClamped - An overflowing duration was clamped by the clamp option.
+	WarningClamped WarningKind = "clamped"
+)
+
+// Warning - A non-fatal issue found while unmarshalling, see UnmarshalResult.
+type Warning struct {
+	Kind WarningKind
+
+	// Key is the key of the variable the issue concerns, if any.
+	Key string
+
+	Message string
+}
+
+// UnmarshalWithResult - Unmarshals a given value, like Unmarshal, and returns an
+// UnmarshalResult describing how its fields were unmarshalled. Unlike Unmarshal, it leaves
+// the state of the marshaler untouched, e.g. DefaultedFields still reports the last
+// Unmarshal, so that it can be used from several goroutines at once.
+//
+// Usage:
+//
+//	result, err := unmarshaller.UnmarshalWithResult(&config)
+//	for path, key := range result.Keys {
+//		log.Printf("%s was read from %s", path, key)
+//	}
+func (marshaler *DefaultEnvMarshaler) UnmarshalWithResult(i interface{}) (*UnmarshalResult, error) {
+	v := reflect.ValueOf(i)
+	if v.Kind() == reflect.Ptr {
+		v = reflect.Indirect(v)
+	}
+
+	// unmarshal with a copy of the marshaler, which holds the state of this Unmarshal
+	resultMarshaler := *marshaler
+	resultMarshaler.result = &UnmarshalResult{
+		Keys:      map[string]string{},
+		Defaulted: []string{},
+		Skipped:   []string{},
+		RawValues: map[string]string{},
+		Sources:   map[string]ValueSource{},
+		Warnings:  []Warning{},
+	}
+	if err := resultMarshaler.UnmarshalValue(v); err != nil {
+		return nil, err
+	}
+	return resultMarshaler.result, nil
+}
+
+// Records an unmarshalled field in the result, if any.
+func (result *UnmarshalResult) recordField(opts *fieldOptions) {
+	if result == nil {
+		return
+	}
+
+	if opts.resolvedKey != "" {
+		result.Keys[opts.path] = opts.resolvedKey
+	}
+	if opts.defaulted {
+		result.Defaulted = append(result.Defaulted, opts.path)
+	}
+	if opts.skipped {
+		result.Skipped = append(result.Skipped, opts.path)
+	}
+	if (opts.resolvedKey != "" || opts.defaulted) && !opts.secret {
+		result.RawValues[opts.path] = opts.rawValue
+	}
+	if opts.source != "" {
+		result.Sources[opts.path] = opts.source
+	}
+}
+
+// Records a warning in the result, if any.
+func (result *UnmarshalResult) recordWarning(kind WarningKind, key string, message string) {
+	if result == nil {
+		return
+	}
+	result.Warnings = append(result.Warnings, Warning{Kind: kind, Key: key, Message: message})
+}
+
+// Records a field disabled by its enabledBy tag in the result, if any.
+func (result *UnmarshalResult) recordDisabled(opts *fieldOptions) {
+	if result == nil {
+		return
+	}
+	result.Skipped = append(result.Skipped, opts.path)
+}
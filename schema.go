@@ -0,0 +1,76 @@
+package goenv
+
+import (
+	"reflect"
+)
+
+// FieldSpec - Settings for the field unmarshalled from an env key, given by a schema rather
+// than by the field's tags. Unset settings, i.e. nil pointers and empty strings, leave
+// those of the tags in place.
+type FieldSpec struct {
+	// Default, if set, replaces the default tag, including environment-specific defaults.
+	Default *string
+
+	// Required, if set, replaces the required option.
+	Required *bool
+
+	// Min and Max, if set, replace the min and max tags.
+	Min string
+	Max string
+
+	// Separator, if set, replaces the sep option.
+	Separator string
+}
+
+// UnmarshalWithSchema - Unmarshals a given value, like Unmarshal, with the settings of the
+// fields given by a schema, keyed by env keys, in preference to their tags. This decouples
+// the policy of a config, e.g. its defaults, from the definition of its structs.
+//
+// Usage:
+//
+//	region := "us-east-1"
+//	err := unmarshaller.UnmarshalWithSchema(&config, map[string]goenv.FieldSpec{
+//		"AWS_REGION": {Default: &region},
+//		"DB_PORT":    {Min: "1", Max: "65535"},
+//	})
+func (marshaler *DefaultEnvMarshaler) UnmarshalWithSchema(i interface{}, schema map[string]FieldSpec) error {
+	v := reflect.ValueOf(i)
+	if v.Kind() == reflect.Ptr {
+		v = reflect.Indirect(v)
+	}
+
+	marshaler.schema = make(map[string]FieldSpec, len(schema))
+	for key, spec := range schema {
+		marshaler.schema[marshaler.normalizeKey(key)] = spec
+	}
+	defer func() {
+		marshaler.schema = nil
+	}()
+
+	return marshaler.UnmarshalValue(v)
+}
+
+// Applies the spec of a field's key in the schema, if any, to its options.
+func (marshaler *DefaultEnvMarshaler) applySchema(fieldEnvTag string, opts *fieldOptions) {
+	spec, ok := marshaler.schema[marshaler.normalizeKey(fieldEnvTag)]
+	if !ok {
+		return
+	}
+
+	if spec.Default != nil {
+		// the default no longer comes from a tag for the EnvironmentName, e.g. defaultProd
+		opts.defaultValue, opts.hasDefault, opts.environmentDefault = *spec.Default, true, false
+	}
+	if spec.Required != nil {
+		opts.required = *spec.Required
+	}
+	if spec.Min != "" {
+		opts.minValue = spec.Min
+	}
+	if spec.Max != "" {
+		opts.maxValue = spec.Max
+	}
+	if spec.Separator != "" {
+		opts.separator = spec.Separator
+	}
+}
@@ -0,0 +1,81 @@
+package goenv
+
+import (
+	"strings"
+)
+
+// StripPrefixEnvReader is an environment variable reader that implements the EnvReader
+// interface by presenting the variables of another reader whose keys start with a prefix,
+// with the prefix removed, so that a struct with unprefixed env tags can be unmarshalled
+// from a prefixed environment:
+//
+//	// reads DB_HOST from APP_DB_HOST
+//	env := NewStripPrefixEnvReader(NewOsEnvReader(), "APP_")
+type StripPrefixEnvReader struct {
+	env    EnvReader
+	prefix string
+}
+
+// A StripPrefixEnvReader of a reader that can enumerate its keys, which enumerates the
+// keys with the prefix in turn.
+type enumeratingStripPrefixEnvReader struct {
+	*StripPrefixEnvReader
+}
+
+// NewStripPrefixEnvReader creates a new instance of StripPrefixEnvReader presenting the
+// variables of env whose keys start with prefix. The reader implements PrefixEnumerator
+// and EnvEnumerator only if env implements either of them, so that features that discover
+// keys fail for readers that cannot enumerate them rather than finding none.
+func NewStripPrefixEnvReader(env EnvReader, prefix string) EnvReader {
+	reader := &StripPrefixEnvReader{
+		env:    env,
+		prefix: prefix,
+	}
+
+	switch env.(type) {
+	case PrefixEnumerator, EnvEnumerator:
+		return &enumeratingStripPrefixEnvReader{reader}
+	}
+	return reader
+}
+
+// LookupEnv - Looks up the value of a key, without the prefix, from the other reader.
+// Returns an unspecific value and false if the prefixed key is missing.
+func (env *StripPrefixEnvReader) LookupEnv(key string) (string, bool) {
+	return env.env.LookupEnv(env.prefix + key)
+}
+
+// HasKeys - Returns whether or not a set of keys, without the prefix, have values along
+// with a list of keys that do not.
+func (env *StripPrefixEnvReader) HasKeys(keys []string) (bool, []string) {
+	return hasKeys(env, keys)
+}
+
+// Keys - Returns the keys of the variables of the other reader that start with the
+// prefix, with the prefix removed.
+func (env *enumeratingStripPrefixEnvReader) Keys() []string {
+	return env.KeysWithPrefix("")
+}
+
+// KeysWithPrefix - Returns the keys, without the prefix, that start with another prefix,
+// e.g. the keys DB_HOST and DB_PORT for DB_ from APP_DB_HOST and APP_DB_PORT.
+func (env *enumeratingStripPrefixEnvReader) KeysWithPrefix(prefix string) []string {
+	var prefixedKeys []string
+	switch enumerator := env.env.(type) {
+	case PrefixEnumerator:
+		prefixedKeys = enumerator.KeysWithPrefix(env.prefix + prefix)
+	case EnvEnumerator:
+		for _, key := range enumerator.Keys() {
+			if strings.HasPrefix(key, env.prefix+prefix) {
+				prefixedKeys = append(prefixedKeys, key)
+			}
+		}
+	}
+
+	// the prefix of the keys may differ in case from the prefix for case-insensitive readers
+	keys := make([]string, 0, len(prefixedKeys))
+	for _, key := range prefixedKeys {
+		keys = append(keys, key[len(env.prefix):])
+	}
+	return keys
+}
@@ -0,0 +1,403 @@
+package goenv
+
+import (
+	"github.com/pkg/errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fieldOptions holds the settings for unmarshalling a struct field that are derived
+// from the field's tags, along with the state of unmarshalling it.
+type fieldOptions struct {
+	// the key given by the env tag, without any options
+	key string
+
+	// whether the key is absolute rather than nested under the field's prefix
+	absolute bool
+
+	// whether the field collects the values of all keys with its key as a prefix
+	collect bool
+
+	// whether the field receives the raw values of the keys consumed by its struct, given
+	// by the raw option, rather than a value of its own
+	raw bool
+
+	// the key named by the defaultFrom tag, if any, sharing the field's prefix
+	defaultFrom string
+
+	// the key named by the enabledBy tag, if any, sharing the field's prefix
+	enabledBy string
+
+	// the key named by the typeFrom tag, if any, sharing the field's prefix, whose value
+	// selects the concrete type of an interface
+	typeFrom string
+
+	// the keys named by the conflictsWith tag, if any, sharing the field's prefix, which
+	// cannot be set along with the field's key
+	conflictsWith []string
+
+	// the template given by the template tag, if any, whose references, e.g. ${DB_HOST},
+	// are nested under the field's prefix, from which the field's value is computed
+	template string
+
+	// whether the field's value is redacted from error messages
+	secret bool
+
+	// the message given by the errmsg tag, if any, that leads the field's errors
+	errMsg string
+
+	// the literal value given by the default tag, if any
+	defaultValue string
+	hasDefault   bool
+
+	// whether the field must be set by the environment, ignoring its default tag
+	required bool
+
+	// whether setting the field's key logs a warning, given by the deprecated option
+	deprecated bool
+
+	// whether a missing value leaves the field unset rather than failing, as for pointers,
+	// unless the field is required
+	optional bool
+
+	// the separator of slice and map elements given by the sep or recsep options, or by
+	// the nlsep option as a newline, if any
+	separator string
+
+	// the separator of map keys and values given by the kvsep option, if any
+	kvSeparator string
+
+	// the separator of the values of records, i.e. structs parsed from a single value, given
+	// by the fieldsep option, if any
+	fieldSeparator string
+
+	// whether structs are parsed from key=value entries, whose keys and values map to the
+	// struct's two fields with env tags, given by the kv option
+	keyValue bool
+
+	// whether a struct is populated from the key=value entries of a single value, given by
+	// the split option, rather than from a variable per field
+	split bool
+
+	// whether strings are trimmed of trailing newlines only, rather than of surrounding
+	// whitespace, given by the trimnewline option
+	trimNewline bool
+
+	// whether a trailing comment, e.g. " # the primary host", is stripped from values read
+	// from the environment, given by the stripcomment option
+	stripComment bool
+
+	// whether a single empty element after a trailing separator is dropped, given by the
+	// trimtrailing option
+	trimTrailing bool
+
+	// whether strings, including the elements of slices and maps, are lowercased or
+	// uppercased, given by the lower and upper options
+	lower bool
+	upper bool
+
+	// whether empty strings are parsed as nil slices and maps, rather than empty ones, given
+	// by the nilempty option
+	nilEmpty bool
+
+	// whether gaps in the indices of slices of structs are skipped, compacting the slice,
+	// rather than rejected, given by the gaps=skip option
+	skipGaps bool
+
+	// the maximum number of elements of slices given by the maxlen option, or 0 if unbounded
+	maxLen int
+
+	// the scale of Decimals given by the scale option, if any
+	scale    int
+	hasScale bool
+
+	// the base in which integers are parsed, or 0 for base 10
+	base int
+
+	// the values that alone are parsed as true and false for bools, given by the true and
+	// false options, if any
+	trueValue  string
+	falseValue string
+
+	// whether a bool is set by the presence of its key rather than parsed from its value
+	presence bool
+
+	// whether integers may be written in scientific notation, e.g. 1e3
+	sci bool
+
+	// whether integers with insignificant leading zeros are rejected, given by the strict
+	// option
+	strict bool
+
+	// whether overflowing durations are clamped rather than rejected
+	clamp bool
+
+	// the bounds of numeric values and durations, or of each of their elements for slices,
+	// given by the min and max tags or options, if any
+	minValue string
+	maxValue string
+
+	// the regexp given by the pattern tag, if any, that strings, or each of their elements
+	// for slices, must match
+	pattern *regexp.Regexp
+
+	// whether durations must be non-negative, or negative, given by the nonneg and neg
+	// options
+	nonNegative bool
+	negative    bool
+
+	// the layout given by the envFormat tag and the location named by the envTZ
+	// tag with which times are parsed, if any
+	timeLayout   string
+	timeLocation *time.Location
+
+	// the path of the field from the root struct, e.g. Database.Port
+	path string
+
+	// the raw value looked up for the field, if any, once it has been unmarshalled
+	rawValue string
+
+	// the key the raw value was read from, if any, once the field has been unmarshalled
+	resolvedKey string
+
+	// whether the field fell back to its default tag once it has been unmarshalled
+	defaulted bool
+
+	// whether the field's value could not be parsed, and was skipped, once it has been
+	// unmarshalled
+	skipped bool
+
+	// whether an overflowing duration was clamped once the field has been unmarshalled
+	clamped bool
+
+	// whether the default is given by the tag for the EnvironmentName rather than the
+	// default tag
+	environmentDefault bool
+
+	// the source the field's value was resolved from once it has been unmarshalled, if it
+	// was looked up
+	source ValueSource
+}
+
+// Derives the unmarshalling options of a struct field, nested under envPrefix, from its tags.
+// It returns an error if any of the options are malformed.
+func parseFieldOptions(fieldStruct reflect.StructField, envPrefix string) (*fieldOptions, error) {
+	opts := &fieldOptions{}
+
+	var tagOpts map[string]string
+	opts.key, tagOpts = parseEnvTag(fieldStruct.Tag.Get("env"))
+	_, opts.collect = tagOpts["collect"]
+	if _, opts.raw = tagOpts["raw"]; opts.raw && opts.key != "" {
+		return nil, errors.Errorf("invalid option raw: the field cannot have the key %s", opts.key)
+	}
+	_, opts.absolute = tagOpts["abs"]
+	if opts.absolute {
+		// keys named by the field's other tags are absolute too
+		envPrefix = ""
+	}
+	_, opts.clamp = tagOpts["clamp"]
+	_, opts.nonNegative = tagOpts["nonneg"]
+	_, opts.negative = tagOpts["neg"]
+	if opts.nonNegative && opts.negative {
+		return nil, errors.New("invalid options nonneg and neg: a duration cannot be both")
+	}
+	_, opts.sci = tagOpts["sci"]
+	_, opts.strict = tagOpts["strict"]
+	_, opts.presence = tagOpts["presence"]
+	_, opts.required = tagOpts["required"]
+	_, opts.deprecated = tagOpts["deprecated"]
+	_, opts.trimTrailing = tagOpts["trimtrailing"]
+	_, opts.trimNewline = tagOpts["trimnewline"]
+	_, opts.stripComment = tagOpts["stripcomment"]
+	_, opts.nilEmpty = tagOpts["nilempty"]
+	_, opts.lower = tagOpts["lower"]
+	_, opts.upper = tagOpts["upper"]
+	if opts.lower && opts.upper {
+		return nil, errors.New("invalid options lower and upper: a string cannot be both")
+	}
+
+	opts.trueValue, opts.falseValue = tagOpts["true"], tagOpts["false"]
+	if (opts.trueValue == "") != (opts.falseValue == "") {
+		return nil, errors.New("invalid options true and false: both must be given")
+	}
+	if opts.trueValue != "" && opts.trueValue == opts.falseValue {
+		return nil, errors.Errorf("invalid options true and false: both are %s", opts.trueValue)
+	}
+
+	opts.separator = tagOpts["sep"]
+	opts.kvSeparator = tagOpts["kvsep"]
+	opts.fieldSeparator = tagOpts["fieldsep"]
+	_, opts.keyValue = tagOpts["kv"]
+	if recsep, ok := tagOpts["recsep"]; ok {
+		opts.separator = recsep
+	}
+	if _, ok := tagOpts["nlsep"]; ok {
+		opts.separator = "\n"
+	}
+	if _, opts.split = tagOpts["split"]; opts.split && opts.separator == "" {
+		opts.separator = DefaultSplitSeparator
+	}
+
+	switch gaps := tagOpts["gaps"]; gaps {
+	case "", "strict":
+	case "skip":
+		opts.skipGaps = true
+	default:
+		return nil, errors.Errorf("invalid gaps %s: expected strict or skip", gaps)
+	}
+
+	if maxLen, ok := tagOpts["maxlen"]; ok {
+		var err error
+		opts.maxLen, err = strconv.Atoi(maxLen)
+		if err != nil || opts.maxLen < 1 {
+			return nil, errors.Errorf("invalid maxlen %s: expected a positive integer", maxLen)
+		}
+	}
+
+	if scale, ok := tagOpts["scale"]; ok {
+		var err error
+		opts.hasScale = true
+		opts.scale, err = strconv.Atoi(scale)
+		if err != nil || opts.scale < 0 || opts.scale > maxDecimalScale {
+			return nil, errors.Errorf("invalid scale %s: expected an integer from 0 to %d", scale, maxDecimalScale)
+		}
+	}
+
+	if base, ok := tagOpts["base"]; ok {
+		var err error
+		opts.base, err = strconv.Atoi(base)
+		if err != nil || opts.base < 2 || opts.base > 36 {
+			return nil, errors.Errorf("invalid base %s: expected an integer from 2 to 36", base)
+		}
+	}
+
+	if secret := fieldStruct.Tag.Get("secret"); secret != "" {
+		var err error
+		opts.secret, err = strconv.ParseBool(secret)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid secret tag %s", secret)
+		}
+	}
+
+	opts.errMsg = fieldStruct.Tag.Get("errmsg")
+	// the min and max tags take precedence over the min and max options
+	opts.minValue, opts.maxValue = tagOpts["min"], tagOpts["max"]
+	if minValue := fieldStruct.Tag.Get("min"); minValue != "" {
+		opts.minValue = minValue
+	}
+	if maxValue := fieldStruct.Tag.Get("max"); maxValue != "" {
+		opts.maxValue = maxValue
+	}
+
+	if pattern := fieldStruct.Tag.Get("pattern"); pattern != "" {
+		var err error
+		if opts.pattern, err = compilePattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	opts.timeLayout = fieldStruct.Tag.Get("envFormat")
+	if tz := fieldStruct.Tag.Get("envTZ"); tz != "" {
+		var err error
+		opts.timeLocation, err = time.LoadLocation(tz)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot load location %s", tz)
+		}
+	}
+
+	if defaultFrom := fieldStruct.Tag.Get("defaultFrom"); defaultFrom != "" {
+		opts.defaultFrom = envPrefix + defaultFrom
+	}
+	opts.defaultValue, opts.hasDefault = fieldStruct.Tag.Lookup("default")
+
+	if enabledBy := fieldStruct.Tag.Get("enabledBy"); enabledBy != "" {
+		opts.enabledBy = envPrefix + enabledBy
+	}
+
+	if conflictsWith := fieldStruct.Tag.Get("conflictsWith"); conflictsWith != "" {
+		for _, key := range strings.Split(conflictsWith, ",") {
+			opts.conflictsWith = append(opts.conflictsWith, envPrefix+strings.TrimSpace(key))
+		}
+	}
+
+	if template := fieldStruct.Tag.Get("template"); template != "" {
+		opts.template = prefixTemplate(template, envPrefix)
+	}
+
+	if typeFrom := fieldStruct.Tag.Get("typeFrom"); typeFrom != "" {
+		opts.typeFrom = envPrefix + typeFrom
+	}
+
+	return opts, nil
+}
+
+// Returns the default of a field for the named environment, given by the tag named default
+// followed by the capitalized environment name, e.g. defaultProd for the environment prod,
+// and whether or not the field has such a tag.
+func environmentDefault(tag reflect.StructTag, environmentName string) (string, bool) {
+	if environmentName == "" {
+		return "", false
+	}
+	return tag.Lookup("default" + strings.ToUpper(environmentName[:1]) + environmentName[1:])
+}
+
+// Returns the source of the field's default, i.e. its default tag for the EnvironmentName
+// or its default tag.
+func (opts *fieldOptions) defaultSource() ValueSource {
+	if opts.environmentDefault {
+		return SourceEnvironmentDefault
+	}
+	return SourceDefault
+}
+
+// Returns the key of the field nested under envPrefix, unless the key is absolute.
+func (opts *fieldOptions) envKey(envPrefix string) string {
+	if opts.absolute {
+		return opts.key
+	}
+	return envPrefix + opts.key
+}
+
+// Determines whether or not the field is parsed from records, i.e. it has the fieldsep or
+// kv options or a separator, rather than discovered from indexed keys.
+func (opts *fieldOptions) isRecords() bool {
+	return opts.fieldSeparator != "" || opts.separator != "" || opts.keyValue
+}
+
+// Returns a copy of parser that parses values according to the field's options.
+func (opts *fieldOptions) fieldParser(parser *DefaultParser) *DefaultParser {
+	fieldParser := *parser
+	fieldParser.field = opts
+	return &fieldParser
+}
+
+// Splits an env tag, e.g. `KEY,flag,name=value`, into its key and its options. Options
+// without a value map to the empty string. An option's value may also follow a colon,
+// e.g. `base:8`, as long as the option has no `=`.
+func parseEnvTag(tag string) (string, map[string]string) {
+	parts := strings.Split(tag, ",")
+	tagOpts := map[string]string{}
+	for _, part := range parts[1:] {
+		sep := "="
+		if !strings.Contains(part, sep) {
+			sep = ":"
+		}
+
+		nameVal := strings.SplitN(part, sep, 2)
+		name := strings.TrimSpace(nameVal[0])
+		if name == "" {
+			continue
+		}
+
+		if len(nameVal) == 2 {
+			tagOpts[name] = nameVal[1]
+		} else {
+			tagOpts[name] = ""
+		}
+	}
+
+	return parts[0], tagOpts
+}
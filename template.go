@@ -0,0 +1,81 @@
+package goenv
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"reflect"
+)
+
+// NoEnvKey is the key of the env tag of fields without a variable of their own, e.g. those
+// computed from the template tag.
+const NoEnvKey = "-"
+
+// Unmarshals a field with the template tag into structFieldVal. References, e.g.
+// ${DB_HOST}, take the values of the fields of their keys in the same struct, including
+// their defaults, given by resolved, or otherwise those of the environment.
+func (marshaler *DefaultEnvMarshaler) unmarshalTemplate(
+	fieldStruct reflect.StructField,
+	structFieldVal reflect.Value,
+	fieldEnvTag string,
+	opts *fieldOptions,
+	resolved map[string]string,
+) error {
+	expanded, err := marshaler.expandTemplate(opts.template, resolved)
+	if err != nil {
+		return errors.Wrapf(err, "cannot resolve the template of field %s", fieldStruct.Name)
+	}
+	if err := opts.fieldParser(marshaler.parser()).ParseInto(expanded, structFieldVal); err != nil {
+		return errors.Wrapf(err, "cannot parse the template of field %s", fieldStruct.Name)
+	}
+
+	opts.rawValue = expanded
+	opts.source = SourceTemplate
+	if opts.key != NoEnvKey {
+		resolved[marshaler.normalizeKey(fieldEnvTag)] = expanded
+	}
+	marshaler.result.recordField(opts)
+	return nil
+}
+
+// Expands the references of a template, e.g. ${DB_HOST}, to the values of the keys they
+// name. It returns a MissingKeyError for the first reference to a key that is neither
+// resolved nor set.
+func (marshaler *DefaultEnvMarshaler) expandTemplate(template string, resolved map[string]string) (string, error) {
+	var expandErr error
+	expanded := os.Expand(template, func(name string) string {
+		key := marshaler.normalizeKey(name)
+		if val, ok := resolved[key]; ok {
+			return val
+		}
+
+		envVal, hasVal, err := marshaler.lookupEnv(key)
+		if err == nil && !hasVal {
+			err = errors.WithStack(&MissingKeyError{Key: key})
+		}
+		if err != nil && expandErr == nil {
+			expandErr = err
+		}
+		return envVal
+	})
+	return expanded, expandErr
+}
+
+// Returns the keys referenced by a template, in order.
+func templateKeys(template string) []string {
+	keys := []string{}
+	os.Expand(template, func(name string) string {
+		keys = append(keys, name)
+		return ""
+	})
+	return keys
+}
+
+// Nests the references of a template, e.g. ${HOST}, under envPrefix, e.g. ${DB_HOST}.
+func prefixTemplate(template string, envPrefix string) string {
+	if envPrefix == "" {
+		return template
+	}
+	return os.Expand(template, func(name string) string {
+		return "${" + envPrefix + name + "}"
+	})
+}
@@ -0,0 +1,154 @@
+package goenv
+
+import (
+	"github.com/pkg/errors"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MapEntry is a single key=value entry of an OrderedMap.
+type MapEntry struct {
+	Key   string
+	Value string
+}
+
+// OrderedMap is a map of strings that retains the order of its entries, for configs where
+// order matters, e.g. a chain of middleware. It is parsed from separated key=value
+// entries, e.g. "a=1,b=2,c=3", in the same way as a map[string]string.
+type OrderedMap []MapEntry
+
+var orderedMapType = reflect.TypeOf(OrderedMap{})
+
+// Get - Returns the value of the last entry with a given key, and whether or not such an
+// entry exists.
+func (m OrderedMap) Get(key string) (string, bool) {
+	for i := len(m) - 1; i >= 0; i-- {
+		if m[i].Key == key {
+			return m[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// Keys - Returns the keys of the entries in order.
+func (m OrderedMap) Keys() []string {
+	keys := make([]string, len(m))
+	for i, entry := range m {
+		keys[i] = entry.Key
+	}
+	return keys
+}
+
+// StringSet is a set of strings, for configs where duplicates are meaningless, e.g. an
+// allowlist of roles. It is parsed from separated values, e.g. "admin,user,admin", in the
+// same way as a []string, with duplicate values collapsed.
+type StringSet map[string]struct{}
+
+var stringSetType = reflect.TypeOf(StringSet{})
+
+// Contains - Returns whether or not the set contains a value.
+func (s StringSet) Contains(val string) bool {
+	_, ok := s[val]
+	return ok
+}
+
+// Values - Returns the values of the set in sorted order.
+func (s StringSet) Values() []string {
+	values := make([]string, 0, len(s))
+	for val := range s {
+		values = append(values, val)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// Decimal is a fixed-point decimal number, for configs where floats would round, e.g.
+// prices. Its value is Units / 10^Scale, e.g. 12.34 is Decimal{Units: 1234, Scale: 2}. It is
+// parsed from decimal strings, e.g. "12.34", with the scale given by the scale option, e.g.
+// `env:"PRICE,scale=2"`, rejecting values with more decimal places than the scale, or with
+// as many decimal places as the string has otherwise.
+type Decimal struct {
+	Units int64
+	Scale int
+}
+
+var decimalType = reflect.TypeOf(Decimal{})
+
+// the largest scale of Decimals, beyond which any non-zero value overflows an int64
+const maxDecimalScale = 18
+
+// Parses a decimal string, e.g. "-12.34", at a given scale, or at the scale of its decimal
+// places if scale is negative.
+func parseDecimal(str string, scale int) (Decimal, error) {
+	sign := ""
+	digits := str
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		sign, digits = digits[:1], digits[1:]
+	}
+
+	intPart, fracPart := digits, ""
+	if dot := strings.Index(digits, "."); dot >= 0 {
+		intPart, fracPart = digits[:dot], digits[dot+1:]
+	}
+	if intPart == "" && fracPart == "" || strings.Trim(intPart+fracPart, "0123456789") != "" {
+		return Decimal{}, errors.Errorf("Cannot convert %s to a decimal", str)
+	}
+
+	if scale < 0 {
+		scale = len(fracPart)
+	}
+	if scale > maxDecimalScale {
+		return Decimal{}, errors.Errorf("Cannot convert %s to a decimal: scale %d exceeds %d", str, scale, maxDecimalScale)
+	}
+	if len(fracPart) > scale {
+		return Decimal{}, errors.Errorf("Cannot convert %s to a decimal: more than %d decimal places", str, scale)
+	}
+	fracPart += strings.Repeat("0", scale-len(fracPart))
+
+	units, err := strconv.ParseInt(sign+intPart+fracPart, 10, 64)
+	if err != nil {
+		return Decimal{}, errors.Wrapf(err, "Cannot convert %s to a decimal", str)
+	}
+	return Decimal{Units: units, Scale: scale}, nil
+}
+
+// String - Returns the decimal string of the value with Scale decimal places, e.g. 12.30.
+func (d Decimal) String() string {
+	digits := strconv.FormatInt(d.Units, 10)
+	if d.Scale <= 0 {
+		return digits
+	}
+
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	if len(digits) <= d.Scale {
+		digits = strings.Repeat("0", d.Scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-d.Scale] + "." + digits[len(digits)-d.Scale:]
+}
+
+// Float64 - Returns the nearest float64 to the value, e.g. for display.
+func (d Decimal) Float64() float64 {
+	return float64(d.Units) / math.Pow10(d.Scale)
+}
+
+// MarshalText - Implements encoding.TextMarshaler with the decimal string of the value.
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText - Implements encoding.TextUnmarshaler, parsing a decimal string at the
+// scale of its decimal places.
+func (d *Decimal) UnmarshalText(text []byte) error {
+	decimal, err := parseDecimal(strings.TrimSpace(string(text)), -1)
+	if err != nil {
+		return err
+	}
+	*d = decimal
+	return nil
+}
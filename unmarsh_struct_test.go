@@ -3,7 +3,16 @@ package goenv
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -31,6 +40,14 @@ func (reader *MockEnvReader) HasKeys(keys []string) (bool, []string) {
 	return len(missingEnvVars) == 0, missingEnvVars
 }
 
+func (reader *MockEnvReader) Keys() []string {
+	keys := []string{}
+	for key := range reader.EnvValues {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
 type Equaler interface {
 	fmt.Stringer
 	Equal(i interface{}) bool
@@ -47,7 +64,7 @@ func ref(s string) *string {
 
 func test(c TestCase, t *testing.T, obj Equaler) {
 	marsh := DefaultEnvMarshaler{
-		&MockEnvReader{c.Env},
+		Environment: &MockEnvReader{c.Env},
 	}
 
 	err := marsh.Unmarshal(obj)
@@ -65,7 +82,7 @@ func test(c TestCase, t *testing.T, obj Equaler) {
 
 func testFail(env map[string]string, t *testing.T, obj Equaler) {
 	marsh := DefaultEnvMarshaler{
-		&MockEnvReader{env},
+		Environment: &MockEnvReader{env},
 	}
 
 	err := marsh.Unmarshal(obj)
@@ -604,8 +621,4275 @@ func TestUnmarshalStructFailDirectly(t *testing.T) {
 	marshaler := DefaultEnvMarshaler{}
 
 	badType := reflect.TypeOf("")
-	_, err := marshaler.unmarshalStruct(badType, "")
+	_, err := marshaler.unmarshalStruct(badType, "", "")
 	if err == nil {
 		t.Error("We do not expect to succeed unmarshaling a string in unmarshalStruct")
 	}
 }
+
+type FuncFieldObj struct {
+	A string `env:"FUNC_FIELD_OBJ_A"`
+	B func() `env:"FUNC_FIELD_OBJ_B"`
+}
+
+func TestUnmarshalUnsupportedKindFail(t *testing.T) {
+	env := map[string]string{
+		"FUNC_FIELD_OBJ_A": "hello",
+		"FUNC_FIELD_OBJ_B": "world",
+	}
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{env},
+	}
+
+	cases := []struct {
+		Obj      interface{}
+		Field    string
+		KindName string
+	}{
+		{&FuncFieldObj{}, "B", "func"},
+		{&struct {
+			C chan int `env:"FUNC_FIELD_OBJ_B"`
+		}{}, "C", "chan"},
+		{&struct {
+			D *func() `env:"FUNC_FIELD_OBJ_B"`
+		}{}, "D", "func"},
+	}
+
+	for i, c := range cases {
+		err := marsh.Unmarshal(c.Obj)
+		if err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+			continue
+		}
+
+		expected := fmt.Sprintf("field %s of unsupported kind %s", c.Field, c.KindName)
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("TC %d: Expect error to contain \"%s\", actual \"%s\"",
+				i, expected, err.Error(),
+			)
+		}
+	}
+}
+
+func TestUnmarshalWithCustomParser(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"SEPARATOR_PATHS": "/usr/local/bin:/usr/bin",
+		}},
+		Parser: &DefaultParser{SliceSeparator: ":"},
+	}
+
+	obj := struct {
+		Paths []string `env:"SEPARATOR_PATHS"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	expected := []string{"/usr/local/bin", "/usr/bin"}
+	if !reflect.DeepEqual(obj.Paths, expected) {
+		t.Errorf("Expected %v, actual %v", expected, obj.Paths)
+	}
+}
+
+type DefaultsObj struct {
+	AdvertiseHost string `env:"ADVERTISE_HOST" defaultFrom:"BIND_HOST" default:"localhost"`
+	BindHost      string `env:"BIND_HOST" default:"0.0.0.0"`
+	Port          *int   `env:"PORT" default:"8080"`
+	Name          string `env:"NAME" default:""`
+}
+
+func (o *DefaultsObj) Equal(i interface{}) bool {
+	other, ok := i.(*DefaultsObj)
+	if !ok {
+		return false
+	}
+	return other.AdvertiseHost == o.AdvertiseHost &&
+		other.BindHost == o.BindHost &&
+		*(other.Port) == *(o.Port) &&
+		other.Name == o.Name
+}
+
+func (o *DefaultsObj) String() string {
+	return fmt.Sprintf("{AdvertiseHost: %s, BindHost: %s, Port: %d, Name: %s}",
+		o.AdvertiseHost, o.BindHost, *(o.Port), o.Name,
+	)
+}
+
+func TestUnmarshalDefaults(t *testing.T) {
+	port := 8080
+	otherPort := 9090
+	cases := []TestCase{
+		// primary key wins over defaultFrom and default
+		{
+			map[string]string{
+				"ADVERTISE_HOST": "example.com",
+				"BIND_HOST":      "10.0.0.1",
+				"PORT":           "9090",
+				"NAME":           "app",
+			},
+			&DefaultsObj{"example.com", "10.0.0.1", &otherPort, "app"},
+		},
+		// defaultFrom wins over default
+		{
+			map[string]string{
+				"BIND_HOST": "10.0.0.1",
+			},
+			&DefaultsObj{"10.0.0.1", "10.0.0.1", &port, ""},
+		},
+		// default is used when neither are set
+		{
+			map[string]string{},
+			&DefaultsObj{"localhost", "0.0.0.0", &port, ""},
+		},
+	}
+
+	for _, c := range cases {
+		var obj DefaultsObj
+		test(c, t, &obj)
+	}
+}
+
+func TestUnmarshalDefaultFromPrefixed(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"SERVER_BIND_HOST": "10.0.0.1",
+			"BIND_HOST":        "10.0.0.2",
+		}},
+	}
+
+	obj := struct {
+		Server struct {
+			AdvertiseHost string `env:"ADVERTISE_HOST" defaultFrom:"BIND_HOST"`
+		} `env:"SERVER_"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if obj.Server.AdvertiseHost != "10.0.0.1" {
+		t.Errorf("Expected 10.0.0.1, actual %s", obj.Server.AdvertiseHost)
+	}
+}
+
+func TestUnmarshalDefaultsFail(t *testing.T) {
+	cases := []interface{}{
+		// the default value must be parseable
+		&struct {
+			A int `env:"DEFAULTS_FAIL_A" default:"abc"`
+		}{},
+		// neither the key nor the defaultFrom key is set, and there is no default
+		&struct {
+			B string `env:"DEFAULTS_FAIL_B" defaultFrom:"DEFAULTS_FAIL_C"`
+		}{},
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{}},
+	}
+	for i, c := range cases {
+		if err := marsh.Unmarshal(c); err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+		}
+	}
+}
+
+func TestNormalizeUpperSnake(t *testing.T) {
+	cases := map[string]string{
+		"db.host":      "DB_HOST",
+		"db-read.port": "DB_READ_PORT",
+		"DB_HOST":      "DB_HOST",
+		"":             "",
+	}
+
+	for key, expected := range cases {
+		if actual := NormalizeUpperSnake(key); actual != expected {
+			t.Errorf("Expected %s to normalize to %s, actual %s", key, expected, actual)
+		}
+	}
+}
+
+func TestUnmarshalKeyNormalizer(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"DB_HOST":        "localhost",
+			"DB_READ_PORT":   "5432",
+			"DB_REPLICA_URL": "replica.local",
+		}},
+		KeyNormalizer: NormalizeUpperSnake,
+	}
+
+	obj := struct {
+		DB struct {
+			Host       string `env:"host"`
+			Port       int    `env:"read-port"`
+			ReplicaURL string `env:"replica.url" defaultFrom:"host"`
+			PrimaryURL string `env:"primary.url" defaultFrom:"host"`
+		} `env:"db."`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if obj.DB.Host != "localhost" || obj.DB.Port != 5432 ||
+		obj.DB.ReplicaURL != "replica.local" || obj.DB.PrimaryURL != "localhost" {
+		t.Errorf("Unexpected config %+v", obj)
+	}
+}
+
+func TestUnmarshalKey(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"HOSTS":  "a.local, b.local",
+			"LABELS": "team=infra,tier=1",
+		}},
+	}
+
+	hosts := []string{}
+	if err := marsh.UnmarshalKey("HOSTS", &hosts); err != nil {
+		t.Errorf("UnmarshalKey should not raise error. Error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(hosts, []string{"a.local", "b.local"}) {
+		t.Errorf("Unexpected slice %v", hosts)
+	}
+
+	labels := map[string]string{}
+	if err := marsh.UnmarshalKey("LABELS", &labels); err != nil {
+		t.Errorf("UnmarshalKey should not raise error. Error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(labels, map[string]string{"team": "infra", "tier": "1"}) {
+		t.Errorf("Unexpected map %v", labels)
+	}
+}
+
+func TestUnmarshalKeyFail(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"LABELS": "team",
+		}},
+	}
+
+	hosts := []string{}
+	if err := marsh.UnmarshalKey("HOSTS", &hosts); err == nil {
+		t.Error("Expecting an error for a missing key.")
+	}
+
+	labels := map[string]string{}
+	if err := marsh.UnmarshalKey("LABELS", &labels); err == nil {
+		t.Error("Expecting an error for a malformed map.")
+	}
+
+	if err := marsh.UnmarshalKey("LABELS", labels); err == nil {
+		t.Error("Expecting an error for a non-pointer.")
+	}
+}
+
+func TestUnmarshalNumErrorPreserved(t *testing.T) {
+	cases := []struct {
+		Env      map[string]string
+		Expected error
+	}{
+		{map[string]string{"NUM_ERR_A": "300", "NUM_ERR_B": "1"}, strconv.ErrRange},
+		{map[string]string{"NUM_ERR_A": "1", "NUM_ERR_B": "99999999999999999999"}, strconv.ErrRange},
+		{map[string]string{"NUM_ERR_A": "abc", "NUM_ERR_B": "1"}, strconv.ErrSyntax},
+	}
+
+	for i, c := range cases {
+		obj := struct {
+			A int8 `env:"NUM_ERR_A"`
+			B int  `env:"NUM_ERR_B"`
+		}{}
+		marsh := DefaultEnvMarshaler{
+			Environment: &MockEnvReader{c.Env},
+		}
+
+		err := marsh.Unmarshal(&obj)
+		var numErr *strconv.NumError
+		if !errors.As(err, &numErr) {
+			t.Errorf("TC %d: Expect error to wrap a *strconv.NumError, actual %v", i, err)
+			continue
+		}
+
+		if numErr.Err != c.Expected {
+			t.Errorf("TC %d: Expect %v, actual %v", i, c.Expected, numErr.Err)
+		}
+	}
+}
+
+type lookupOnlyEnvReader struct {
+	EnvReader
+}
+
+func TestUnmarshalCollect(t *testing.T) {
+	env := map[string]string{
+		"ALLOWED_ORIGIN_B":     "https://b.example.com",
+		"ALLOWED_ORIGIN_A":     " https://a.example.com ",
+		"ALLOWED_ORIGIN_C":     "https://c.example.com",
+		"ALLOWED_ORIGINS":      "https://unrelated.example.com",
+		"OTHER_ALLOWED_ORIGIN": "https://other.example.com",
+		"PORT_1":               "80",
+		"PORT_2":               "443",
+	}
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{env},
+	}
+
+	obj := struct {
+		Origins []string `env:"ALLOWED_ORIGIN_,collect"`
+		Ports   *[]int   `env:"PORT_,collect"`
+		None    []string `env:"NONE_,collect"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	expected := []string{
+		"https://a.example.com",
+		"https://b.example.com",
+		"https://c.example.com",
+	}
+	if !reflect.DeepEqual(obj.Origins, expected) {
+		t.Errorf("Expected %v, actual %v", expected, obj.Origins)
+	}
+	if !reflect.DeepEqual(*obj.Ports, []int{80, 443}) {
+		t.Errorf("Expected [80 443], actual %v", *obj.Ports)
+	}
+	if len(obj.None) != 0 {
+		t.Errorf("Expected no values, actual %v", obj.None)
+	}
+
+	// the values are looked up like those of other fields
+	env["ALLOWED_ORIGIN_B"] = ""
+	marsh.TreatEmptyAsAbsent = true
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	expected = []string{"https://a.example.com", "https://c.example.com"}
+	if !reflect.DeepEqual(obj.Origins, expected) {
+		t.Errorf("Expected %v, actual %v", expected, obj.Origins)
+	}
+}
+
+func TestUnmarshalCollectFail(t *testing.T) {
+	env := map[string]string{
+		"PORT_1": "80",
+		"PORT_2": "http",
+	}
+
+	cases := []struct {
+		Env EnvReader
+		Obj interface{}
+	}{
+		{
+			&MockEnvReader{env},
+			&struct {
+				Ports []int `env:"PORT_,collect"`
+			}{},
+		},
+		{
+			&MockEnvReader{env},
+			&struct {
+				Port int `env:"PORT_,collect"`
+			}{},
+		},
+		{
+			&lookupOnlyEnvReader{&MockEnvReader{env}},
+			&struct {
+				Ports []string `env:"PORT_,collect"`
+			}{},
+		},
+	}
+
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{Environment: c.Env}
+		if err := marsh.Unmarshal(c.Obj); err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+		}
+	}
+}
+
+func TestUnmarshalFileIndirection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secretPath := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretPath, []byte("s3cr3t \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	type secretObj struct {
+		Password string `env:"DB_PASSWORD"`
+	}
+
+	cases := []struct {
+		Env      map[string]string
+		Expected string
+	}{
+		// direct value
+		{map[string]string{"DB_PASSWORD": "direct"}, "direct"},
+		// file indirection, less the trailing newline
+		{map[string]string{"DB_PASSWORD_FILE": secretPath}, "s3cr3t"},
+	}
+
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{
+			Environment:     &MockEnvReader{c.Env},
+			FileIndirection: true,
+		}
+		obj := secretObj{}
+		if err := marsh.Unmarshal(&obj); err != nil {
+			t.Errorf("TC %d: Unmarshal should not raise error. Error: %s", i, err.Error())
+		} else if obj.Password != c.Expected {
+			t.Errorf("TC %d: Expected %s, actual %s", i, c.Expected, obj.Password)
+		}
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment:     &MockEnvReader{map[string]string{"DB_PASSWORD_FILE": secretPath}},
+		FileIndirection: true,
+	}
+	raw := ""
+	if err := marsh.UnmarshalKey("DB_PASSWORD", &raw); err != nil || raw != "s3cr3t" {
+		t.Errorf("Expected UnmarshalKey to read s3cr3t, actual %s (%v)", raw, err)
+	}
+}
+
+func TestUnmarshalFileConflict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secretPath := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretPath, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env := &MockEnvReader{map[string]string{
+		"DB_PASSWORD":      "direct",
+		"DB_PASSWORD_FILE": secretPath,
+	}}
+
+	cases := []struct {
+		Policy   FileConflictPolicy
+		Expected string
+	}{
+		{FileConflictPreferValue, "direct"},
+		{FileConflictPreferFile, "from-file"},
+	}
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{
+			Environment:     env,
+			FileIndirection: true,
+			FileConflict:    c.Policy,
+		}
+		obj := struct {
+			Password string `env:"DB_PASSWORD"`
+		}{}
+		if err := marsh.Unmarshal(&obj); err != nil {
+			t.Errorf("TC %d: Unmarshal should not raise error. Error: %s", i, err.Error())
+		} else if obj.Password != c.Expected {
+			t.Errorf("TC %d: Expected %s, actual %s", i, c.Expected, obj.Password)
+		}
+	}
+
+	marsh := DefaultEnvMarshaler{Environment: env, FileIndirection: true}
+	obj := struct {
+		Password string `env:"DB_PASSWORD"`
+	}{}
+	err = marsh.Unmarshal(&obj)
+	if err == nil || !strings.Contains(err.Error(), "both DB_PASSWORD and DB_PASSWORD_FILE are set") {
+		t.Errorf("Expected a conflict error by default, actual %v", err)
+	}
+
+	// without FileIndirection, the _FILE variant is just another variable
+	marsh.FileIndirection = false
+	if err := marsh.Unmarshal(&obj); err != nil || obj.Password != "direct" {
+		t.Errorf("Expected direct, actual %s (Error: %v)", obj.Password, err)
+	}
+}
+
+func TestUnmarshalFileIndirectionFail(t *testing.T) {
+	cases := []DefaultEnvMarshaler{
+		// missing file
+		{
+			Environment: &MockEnvReader{map[string]string{
+				"DB_PASSWORD_FILE": "/path/does/not/exist",
+			}},
+			FileIndirection: true,
+		},
+		// file indirection not enabled
+		{
+			Environment: &MockEnvReader{map[string]string{
+				"DB_PASSWORD_FILE": "/path/does/not/exist",
+			}},
+		},
+	}
+
+	for i, marsh := range cases {
+		obj := struct {
+			Password string `env:"DB_PASSWORD"`
+		}{}
+		if err := marsh.Unmarshal(&obj); err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+		}
+	}
+}
+
+func TestUnmarshalBase(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"BASE_MASK":   "755",
+			"BASE_COLOR":  "ff8800",
+			"BASE_OFFSET": "-1f",
+			"BASE_FLAGS":  "101, 11",
+		}},
+	}
+
+	obj := struct {
+		Mask   uint32  `env:"BASE_MASK,base:8"`
+		Color  uint64  `env:"BASE_COLOR,base:16"`
+		Offset int     `env:"BASE_OFFSET,base=16"`
+		Flags  []uint8 `env:"BASE_FLAGS,base:2"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if obj.Mask != 0755 || obj.Color != 0xff8800 || obj.Offset != -0x1f ||
+		!reflect.DeepEqual(obj.Flags, []uint8{5, 3}) {
+		t.Errorf("Unexpected config %+v", obj)
+	}
+}
+
+func TestUnmarshalBaseFail(t *testing.T) {
+	cases := []struct {
+		Env map[string]string
+		Obj interface{}
+	}{
+		// 8 is not an octal digit
+		{
+			map[string]string{"BASE_MASK": "758"},
+			&struct {
+				Mask uint32 `env:"BASE_MASK,base:8"`
+			}{},
+		},
+		// g is not a hex digit
+		{
+			map[string]string{"BASE_COLOR": "ffg800"},
+			&struct {
+				Color int `env:"BASE_COLOR,base:16"`
+			}{},
+		},
+		// invalid bases
+		{
+			map[string]string{"BASE_COLOR": "1"},
+			&struct {
+				Color int `env:"BASE_COLOR,base:1"`
+			}{},
+		},
+		{
+			map[string]string{"BASE_COLOR": "1"},
+			&struct {
+				Color int `env:"BASE_COLOR,base:hex"`
+			}{},
+		},
+	}
+
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{Environment: &MockEnvReader{c.Env}}
+		if err := marsh.Unmarshal(c.Obj); err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+		}
+	}
+}
+
+func TestUnmarshalValue(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"OBJ1_A":               "hello",
+			"OBJ1_B":               "14",
+			"OBJ1_C":               "true",
+			"OBJ1_D":               "1, 2",
+			"OBJ1_E":               "12m",
+			"OBJ1_F":               "2017-10-05T22:12:59Z",
+			"ENV_MARSHALER_OBJ1_B": "b",
+		}},
+	}
+
+	v := reflect.New(reflect.TypeOf(Obj1{})).Elem()
+	if err := marsh.UnmarshalValue(v); err != nil {
+		t.Fatalf("UnmarshalValue should not raise error. Error: %s", err.Error())
+	}
+
+	obj := v.Interface().(Obj1)
+	expected := &Obj1{
+		A: "hello",
+		B: 14,
+		C: true,
+		D: []int{1, 2},
+		E: 12 * time.Minute,
+		F: time.Date(2017, time.October, 5, 22, 12, 59, 0, time.UTC),
+	}
+	if !expected.Equal(&obj) {
+		t.Errorf("Expected %+v, actual %+v", expected, obj)
+	}
+
+	envMarshVal := reflect.New(reflect.TypeOf(EnvMarshalerObj1{})).Elem()
+	if err := marsh.UnmarshalValue(envMarshVal); err != nil {
+		t.Fatalf("UnmarshalValue should not raise error. Error: %s", err.Error())
+	}
+	if envMarshObj := envMarshVal.Interface().(EnvMarshalerObj1); envMarshObj.A != 3 || envMarshObj.B != "b" {
+		t.Errorf("Unexpected object %+v", envMarshObj)
+	}
+}
+
+func TestUnmarshalValueFail(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{}},
+	}
+
+	cases := []reflect.Value{
+		{},
+		reflect.ValueOf(Obj1{}),
+		reflect.ValueOf(EnvMarshalerObj1{}),
+		reflect.New(reflect.TypeOf("")).Elem(),
+	}
+
+	for i, c := range cases {
+		if err := marsh.UnmarshalValue(c); err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+		}
+	}
+
+	var nilObj *Obj1
+	if err := marsh.Unmarshal(nilObj); err == nil {
+		t.Error("Expecting an error from unmarshalling a nil pointer.")
+	}
+}
+
+func TestUnmarshalDurationClampTag(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"CLAMP_TIMEOUT": "9999999999h",
+		}},
+	}
+
+	obj := struct {
+		Timeout time.Duration `env:"CLAMP_TIMEOUT,clamp"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if obj.Timeout != time.Duration(math.MaxInt64) {
+		t.Errorf("Expected the timeout to be clamped, actual %s", obj.Timeout)
+	}
+
+	unclamped := struct {
+		Timeout time.Duration `env:"CLAMP_TIMEOUT"`
+	}{}
+	if err := marsh.Unmarshal(&unclamped); !errors.Is(err, ErrDurationOverflow) {
+		t.Errorf("Expected an overflow error, actual %v", err)
+	}
+}
+
+type Credentials struct {
+	User     string
+	Password string
+}
+
+func (c *Credentials) UnmarshalEnv(env EnvReader) error {
+	user, hasUser := env.LookupEnv("USER")
+	password, hasPassword := env.LookupEnv("PASSWORD")
+	if !hasUser || !hasPassword {
+		return errors.New("missing USER or PASSWORD")
+	}
+	c.User = user
+	c.Password = "decrypted:" + password
+	return nil
+}
+
+func TestUnmarshalFieldUnmarshalers(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"DB_USER":     "mbluth",
+			"DB_PASSWORD": "abcd",
+			"ORIGIN":      `{"x": 3, "y": 4}`,
+			"LEVEL":       "info",
+		}},
+	}
+
+	obj := struct {
+		DB     *Credentials `env:"DB_"`
+		Origin JSONPoint    `env:"ORIGIN"`
+		Level  *TextLevel   `env:"LEVEL"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if *obj.DB != (Credentials{"mbluth", "decrypted:abcd"}) {
+		t.Errorf("Unexpected credentials %+v", *obj.DB)
+	}
+	if obj.Origin != (JSONPoint{3, 4}) {
+		t.Errorf("Unexpected origin %+v", obj.Origin)
+	}
+	if *obj.Level != 1 {
+		t.Errorf("Unexpected level %d", *obj.Level)
+	}
+}
+
+func TestUnmarshalFieldUnmarshalersFail(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"DB_USER": "mbluth",
+		}},
+	}
+
+	obj := struct {
+		DB Credentials `env:"DB_"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err == nil {
+		t.Error("Expecting an error from unmarshalling.")
+	}
+}
+
+func TestUnmarshalTimeLocation(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Time zone database unavailable: %s", err.Error())
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"CUTOFF":   "2017-10-05 22:12",
+			"STARTED":  "2017-10-05 22:12",
+			"FINISHED": "2017-10-05T22:12:59+02:00",
+		}},
+	}
+
+	obj := struct {
+		Cutoff   time.Time  `env:"CUTOFF" envFormat:"2006-01-02 15:04" envTZ:"America/New_York"`
+		Started  *time.Time `env:"STARTED" envFormat:"2006-01-02 15:04"`
+		Finished time.Time  `env:"FINISHED"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if expected := time.Date(2017, time.October, 5, 22, 12, 0, 0, newYork); !obj.Cutoff.Equal(expected) {
+		t.Errorf("Expected %s, actual %s", expected, obj.Cutoff)
+	}
+	if obj.Cutoff.Location().String() != "America/New_York" {
+		t.Errorf("Expected the location to be America/New_York, actual %s", obj.Cutoff.Location())
+	}
+	if expected := time.Date(2017, time.October, 5, 22, 12, 0, 0, time.UTC); !obj.Started.Equal(expected) {
+		t.Errorf("Expected %s, actual %s", expected, *obj.Started)
+	}
+	if expected := time.Date(2017, time.October, 5, 20, 12, 59, 0, time.UTC); !obj.Finished.Equal(expected) {
+		t.Errorf("Expected %s, actual %s", expected, obj.Finished)
+	}
+}
+
+func TestUnmarshalTimeLocationFail(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"CUTOFF": "2017-10-05 22:12",
+		}},
+	}
+
+	obj := struct {
+		Cutoff time.Time `env:"CUTOFF" envFormat:"2006-01-02 15:04" envTZ:"Mars/Olympus_Mons"`
+	}{}
+	err := marsh.Unmarshal(&obj)
+	if err == nil {
+		t.Fatal("Expecting an error from unmarshalling.")
+	}
+	if !strings.Contains(err.Error(), "Mars/Olympus_Mons") {
+		t.Errorf("Expected the error to name the location, actual %s", err.Error())
+	}
+
+	badLayout := struct {
+		Cutoff time.Time `env:"CUTOFF" envFormat:"2006-01-02"`
+	}{}
+	if err := marsh.Unmarshal(&badLayout); err == nil {
+		t.Error("Expecting an error from unmarshalling a mismatched layout.")
+	}
+}
+
+func TestUnmarshalSeparatorOptions(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"RULES":       "allow a, b\r\n deny c\n\nallow d\n\n",
+			"PORTS":       "80\n443\n",
+			"EMPTY_RULES": "\n",
+			"PATHS":       "/usr/bin:/bin",
+			"TAGS":        "a;b",
+		}},
+		Parser: &DefaultParser{SliceSeparator: ";"},
+	}
+
+	obj := struct {
+		Rules      []string `env:"RULES,nlsep"`
+		Ports      []uint16 `env:"PORTS,nlsep"`
+		EmptyRules []string `env:"EMPTY_RULES,nlsep"`
+		Paths      []string `env:"PATHS,sep=:"`
+		Tags       []string `env:"TAGS"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	expectedRules := []string{"allow a, b", "deny c", "", "allow d"}
+	if !reflect.DeepEqual(obj.Rules, expectedRules) {
+		t.Errorf("Expected %q, actual %q", expectedRules, obj.Rules)
+	}
+	if !reflect.DeepEqual(obj.Ports, []uint16{80, 443}) {
+		t.Errorf("Expected [80 443], actual %v", obj.Ports)
+	}
+	if len(obj.EmptyRules) != 0 {
+		t.Errorf("Expected no rules, actual %q", obj.EmptyRules)
+	}
+	if !reflect.DeepEqual(obj.Paths, []string{"/usr/bin", "/bin"}) {
+		t.Errorf("Expected the field separator to take precedence, actual %v", obj.Paths)
+	}
+	if !reflect.DeepEqual(obj.Tags, []string{"a", "b"}) {
+		t.Errorf("Expected the parser separator to apply, actual %v", obj.Tags)
+	}
+}
+
+func TestUnmarshalSecretRedacted(t *testing.T) {
+	cases := []struct {
+		Env map[string]string
+		Obj interface{}
+	}{
+		{
+			map[string]string{"SECRET_PIN": "hunter2"},
+			&struct {
+				Pin int `env:"SECRET_PIN" secret:"true"`
+			}{},
+		},
+		{
+			map[string]string{"SECRET_PINS": "1234,hunter2"},
+			&struct {
+				Pins *[]int `env:"SECRET_PINS" secret:"true"`
+			}{},
+		},
+		{
+			map[string]string{"SECRET_KEY_1": "hunter2"},
+			&struct {
+				Keys []int `env:"SECRET_KEY_,collect" secret:"true"`
+			}{},
+		},
+		{
+			map[string]string{"SECRET_POINT": `{"x": "hunter2"}`},
+			&struct {
+				Point JSONPoint `env:"SECRET_POINT" secret:"true"`
+			}{},
+		},
+	}
+
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{
+			Environment: &MockEnvReader{c.Env},
+		}
+
+		err := marsh.Unmarshal(c.Obj)
+		if err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+			continue
+		}
+
+		if strings.Contains(err.Error(), "hunter2") {
+			t.Errorf("TC %d: Expected the secret to be redacted, actual %s", i, err.Error())
+		}
+		if !strings.Contains(err.Error(), RedactedValue) {
+			t.Errorf("TC %d: Expected the error to contain %s, actual %s", i, RedactedValue, err.Error())
+		}
+	}
+
+	// the cause is still available
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{"SECRET_PIN": "hunter2"}},
+	}
+	obj := struct {
+		Pin int `env:"SECRET_PIN" secret:"true"`
+	}{}
+	var numErr *strconv.NumError
+	if err := marsh.Unmarshal(&obj); !errors.As(err, &numErr) {
+		t.Errorf("Expected the cause to be a *strconv.NumError, actual %v", err)
+	}
+
+	// values of non-secret fields still appear
+	notSecret := struct {
+		Pin int `env:"SECRET_PIN" secret:"false"`
+	}{}
+	if err := marsh.Unmarshal(&notSecret); err == nil || !strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("Expected the value to appear in the error, actual %v", err)
+	}
+}
+
+type DBConfig struct {
+	Host string `env:"HOST"`
+	Port int    `env:"PORT"`
+}
+
+func TestUnmarshalStructMap(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"DB_primary_HOST": "primary.local",
+			"DB_primary_PORT": "5432",
+			"DB_replica_HOST": "replica.local",
+			"DB_replica_PORT": "5433",
+			"DB_TIMEOUT":      "12s",
+			"DBS_1_HOST":      "unrelated.local",
+			"SHARD_1_HOST":    "shard1.local",
+			"SHARD_1_PORT":    "1",
+		}},
+	}
+
+	obj := struct {
+		DBs    map[string]DBConfig `env:"DB_"`
+		Shards map[int]*DBConfig   `env:"SHARD_"`
+		None   map[string]DBConfig `env:"NONE_"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	expected := map[string]DBConfig{
+		"primary": {"primary.local", 5432},
+		"replica": {"replica.local", 5433},
+	}
+	if !reflect.DeepEqual(obj.DBs, expected) {
+		t.Errorf("Expected %v, actual %v", expected, obj.DBs)
+	}
+	if len(obj.Shards) != 1 || *obj.Shards[1] != (DBConfig{"shard1.local", 1}) {
+		t.Errorf("Unexpected shards %v", obj.Shards)
+	}
+	if obj.None == nil || len(obj.None) != 0 {
+		t.Errorf("Expected an empty map, actual %v", obj.None)
+	}
+}
+
+func TestUnmarshalStructMapFail(t *testing.T) {
+	cases := []struct {
+		Env EnvReader
+		Obj interface{}
+	}{
+		// missing field of a discovered struct
+		{
+			&MockEnvReader{map[string]string{"DB_primary_HOST": "primary.local"}},
+			&struct {
+				DBs map[string]DBConfig `env:"DB_"`
+			}{},
+		},
+		// unparseable map key
+		{
+			&MockEnvReader{map[string]string{"DB_primary_HOST": "primary.local", "DB_primary_PORT": "1"}},
+			&struct {
+				DBs map[int]DBConfig `env:"DB_"`
+			}{},
+		},
+		// environment cannot enumerate keys
+		{
+			&lookupOnlyEnvReader{&MockEnvReader{map[string]string{}}},
+			&struct {
+				DBs map[string]DBConfig `env:"DB_"`
+			}{},
+		},
+	}
+
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{Environment: c.Env}
+		if err := marsh.Unmarshal(c.Obj); err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+		}
+	}
+}
+
+// Time is a struct that coincidentally shares its name with time.Time
+type Time struct {
+	Hour   int `env:"HOUR"`
+	Minute int `env:"MINUTE"`
+}
+
+func TestUnmarshalNamedTimeStruct(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"START_HOUR":   "9",
+			"START_MINUTE": "30",
+		}},
+	}
+
+	obj := struct {
+		Start Time `env:"START_"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if obj.Start != (Time{9, 30}) {
+		t.Errorf("Expected {9 30}, actual %v", obj.Start)
+	}
+}
+
+type MetricsConfig struct {
+	Host     string        `env:"HOST"`
+	Interval time.Duration `env:"INTERVAL"`
+}
+
+func TestUnmarshalMissingKeyError(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{"DB_HOST": "db.local", "DB_PORT": "x"}},
+	}
+
+	obj := struct {
+		DB struct {
+			Host     string `env:"HOST"`
+			Password string `env:"PASSWORD"`
+		} `env:"DB_"`
+	}{}
+	err := marsh.Unmarshal(&obj)
+	var missing *MissingKeyError
+	if !errors.As(err, &missing) || missing.Key != "DB_PASSWORD" {
+		t.Fatalf("Expected a MissingKeyError for DB_PASSWORD, actual %v", err)
+	}
+	if !strings.Contains(err.Error(), "cannot retrieve any value from environment var DB_PASSWORD") {
+		t.Errorf("Unexpected error message %s", err.Error())
+	}
+
+	if err := marsh.UnmarshalKey("API_KEY", new(string)); !errors.As(err, &missing) || missing.Key != "API_KEY" {
+		t.Errorf("Expected a MissingKeyError for API_KEY, actual %v", err)
+	}
+
+	// parse errors are not missing keys
+	invalid := struct {
+		Port int `env:"DB_PORT"`
+	}{}
+	if err := marsh.Unmarshal(&invalid); err == nil || errors.As(err, &missing) {
+		t.Errorf("Expected a parse error rather than a MissingKeyError, actual %v", err)
+	}
+}
+
+func TestUnmarshalEnabledBy(t *testing.T) {
+	type Config struct {
+		Name    string         `env:"NAME"`
+		Metrics MetricsConfig  `env:"METRICS_" enabledBy:"METRICS_ENABLED"`
+		Tracing *MetricsConfig `env:"TRACING_" enabledBy:"TRACING_ENABLED"`
+	}
+
+	cases := []struct {
+		Env      map[string]string
+		Expected Config
+	}{
+		// enabled
+		{
+			map[string]string{
+				"APP_NAME":             "app",
+				"APP_METRICS_ENABLED":  "true",
+				"APP_METRICS_HOST":     "statsd.local",
+				"APP_METRICS_INTERVAL": "10s",
+			},
+			Config{Name: "app", Metrics: MetricsConfig{"statsd.local", 10 * time.Second}},
+		},
+		// disabled, with its keys missing
+		{
+			map[string]string{
+				"APP_NAME":            "app",
+				"APP_METRICS_ENABLED": "false",
+			},
+			Config{Name: "app"},
+		},
+		// disabled by a missing gate
+		{
+			map[string]string{
+				"APP_NAME":         "app",
+				"APP_METRICS_HOST": "statsd.local",
+			},
+			Config{Name: "app"},
+		},
+	}
+
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{Environment: &MockEnvReader{c.Env}}
+		obj := struct {
+			App Config `env:"APP_"`
+		}{}
+		if err := marsh.Unmarshal(&obj); err != nil {
+			t.Errorf("TC %d: Unmarshal should not raise error. Error: %s", i, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(obj.App, c.Expected) {
+			t.Errorf("TC %d: Expected %v, actual %v", i, c.Expected, obj.App)
+		}
+	}
+}
+
+func TestUnmarshalEnabledByFail(t *testing.T) {
+	cases := []map[string]string{
+		// enabled, with its keys missing
+		{"METRICS_ENABLED": "true"},
+		// gate is not a boolean
+		{"METRICS_ENABLED": "yes please", "METRICS_HOST": "statsd.local", "METRICS_INTERVAL": "10s"},
+	}
+
+	for i, env := range cases {
+		marsh := DefaultEnvMarshaler{Environment: &MockEnvReader{env}}
+		obj := struct {
+			Metrics MetricsConfig `env:"METRICS_" enabledBy:"METRICS_ENABLED"`
+		}{}
+		if err := marsh.Unmarshal(&obj); err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+		}
+	}
+}
+
+func TestUnmarshalConflictsWith(t *testing.T) {
+	type TLSConfig struct {
+		UseTLS   bool   `env:"USE_TLS" conflictsWith:"INSECURE" default:"false"`
+		Insecure bool   `env:"INSECURE" default:"false"`
+		CertFile string `env:"CERT_FILE" conflictsWith:"INSECURE, KEYLESS" default:""`
+	}
+
+	cases := []struct {
+		Env      map[string]string
+		Expected string
+	}{
+		{map[string]string{"APP_USE_TLS": "true"}, ""},
+		{map[string]string{"APP_INSECURE": "true"}, ""},
+		{map[string]string{"APP_USE_TLS": "true", "APP_INSECURE": "true"}, "APP_USE_TLS and APP_INSECURE are mutually exclusive"},
+		{map[string]string{"APP_CERT_FILE": "cert.pem", "APP_KEYLESS": ""}, "APP_CERT_FILE and APP_KEYLESS are mutually exclusive"},
+	}
+
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{Environment: &MockEnvReader{c.Env}}
+		obj := struct {
+			TLS TLSConfig `env:"APP_"`
+		}{}
+		err := marsh.Unmarshal(&obj)
+		if c.Expected == "" && err != nil {
+			t.Errorf("TC %d: Unmarshal should not raise error. Error: %s", i, err.Error())
+		}
+		if c.Expected != "" && (err == nil || !strings.Contains(err.Error(), c.Expected)) {
+			t.Errorf("TC %d: Expected an error containing %q, actual %v", i, c.Expected, err)
+		}
+	}
+}
+
+func TestUnmarshalOnField(t *testing.T) {
+	type fieldEvent struct {
+		Path, Key, RawValue string
+		Failed              bool
+	}
+
+	events := []fieldEvent{}
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"NAME":        "app",
+			"DB_HOST":     "db.local",
+			"DB_PORT":     "5432",
+			"DB_PASSWORD": "hunter2",
+			"TIMEOUT":     "soon",
+		}},
+		OnField: func(path, key, rawValue string, err error) {
+			events = append(events, fieldEvent{path, key, rawValue, err != nil})
+		},
+	}
+
+	obj := struct {
+		Name     string `env:"NAME"`
+		Database struct {
+			Host     string `env:"HOST"`
+			Port     int    `env:"PORT"`
+			Password string `env:"PASSWORD" secret:"true"`
+		} `env:"DB_"`
+		Timeout time.Duration `env:"TIMEOUT"`
+		Retries int           `env:"RETRIES"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err == nil {
+		t.Fatal("Expecting an error from unmarshalling.")
+	}
+
+	expected := []fieldEvent{
+		{"Name", "NAME", "app", false},
+		{"Database.Host", "DB_HOST", "db.local", false},
+		{"Database.Port", "DB_PORT", "5432", false},
+		{"Database.Password", "DB_PASSWORD", "", false},
+		{"Database", "DB_", "", false},
+		{"Timeout", "TIMEOUT", "soon", true},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected %v, actual %v", expected, events)
+	}
+}
+
+func TestUnmarshalScientific(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"SCI_COUNT":  "1e3",
+			"SCI_LIMIT":  "1.5e2",
+			"SCI_OFFSET": "-2E2",
+			"SCI_PLAIN":  "42",
+			"SCI_SIZES":  "1e1, 2.5e1",
+		}},
+	}
+
+	obj := struct {
+		Count  int     `env:"SCI_COUNT,sci"`
+		Limit  uint16  `env:"SCI_LIMIT,sci"`
+		Offset int16   `env:"SCI_OFFSET,sci"`
+		Plain  int     `env:"SCI_PLAIN,sci"`
+		Sizes  []int64 `env:"SCI_SIZES,sci"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if obj.Count != 1000 || obj.Limit != 150 || obj.Offset != -200 ||
+		obj.Plain != 42 || !reflect.DeepEqual(obj.Sizes, []int64{10, 25}) {
+		t.Errorf("Unexpected config %+v", obj)
+	}
+}
+
+func TestUnmarshalScientificFail(t *testing.T) {
+	cases := []struct {
+		Env map[string]string
+		Obj interface{}
+	}{
+		// not a whole number
+		{
+			map[string]string{"SCI_COUNT": "1.5e0"},
+			&struct {
+				Count int `env:"SCI_COUNT,sci"`
+			}{},
+		},
+		// scientific notation without the sci option
+		{
+			map[string]string{"SCI_COUNT": "1e3"},
+			&struct {
+				Count int `env:"SCI_COUNT"`
+			}{},
+		},
+		// out of range
+		{
+			map[string]string{"SCI_COUNT": "1e3"},
+			&struct {
+				Count int8 `env:"SCI_COUNT,sci"`
+			}{},
+		},
+		{
+			map[string]string{"SCI_COUNT": "-1e1"},
+			&struct {
+				Count uint `env:"SCI_COUNT,sci"`
+			}{},
+		},
+		{
+			map[string]string{"SCI_COUNT": "1e19"},
+			&struct {
+				Count int64 `env:"SCI_COUNT,sci"`
+			}{},
+		},
+	}
+
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{Environment: &MockEnvReader{c.Env}}
+		if err := marsh.Unmarshal(c.Obj); err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+		}
+	}
+}
+
+type EndpointConfig struct {
+	Host string `env:"HOST"`
+	Port int    `env:"PORT"`
+}
+
+func TestUnmarshalFrom(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"SERVER_HOST": "0.0.0.0",
+			"SERVER_PORT": "8080",
+			"HOST":        "unprefixed",
+		}},
+	}
+
+	config := EndpointConfig{}
+	if err := marsh.UnmarshalFrom("SERVER_", &config); err != nil {
+		t.Fatalf("UnmarshalFrom should not raise error. Error: %s", err.Error())
+	}
+	if config != (EndpointConfig{"0.0.0.0", 8080}) {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	if err := marsh.UnmarshalFrom("CLIENT_", &config); err == nil {
+		t.Error("Expecting an error from unmarshalling missing keys.")
+	}
+}
+
+func TestUnmarshalMany(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"CLIENT_HOST": "api.local",
+			"CLIENT_PORT": "443",
+			"SERVER_HOST": "0.0.0.0",
+			"SERVER_PORT": "8443",
+		}},
+	}
+
+	instances, err := marsh.UnmarshalMany(
+		[]string{"CLIENT_", "SERVER_"},
+		func() interface{} { return &EndpointConfig{} },
+	)
+	if err != nil {
+		t.Fatalf("UnmarshalMany should not raise error. Error: %s", err.Error())
+	}
+
+	expected := []interface{}{
+		&EndpointConfig{"api.local", 443},
+		&EndpointConfig{"0.0.0.0", 8443},
+	}
+	if !reflect.DeepEqual(instances, expected) {
+		t.Errorf("Expected %v, actual %v", expected, instances)
+	}
+
+	_, err = marsh.UnmarshalMany(
+		[]string{"CLIENT_", "PROXY_"},
+		func() interface{} { return &EndpointConfig{} },
+	)
+	if err == nil {
+		t.Error("Expecting an error from unmarshalling missing keys.")
+	}
+}
+
+func TestUnmarshalPresence(t *testing.T) {
+	type Flag bool
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"VERBOSE": "no",
+			"DEBUG":   "",
+		}},
+	}
+
+	obj := struct {
+		Verbose bool  `env:"VERBOSE,presence"`
+		Debug   *Flag `env:"DEBUG,presence"`
+		Quiet   bool  `env:"QUIET,presence"`
+	}{Quiet: true}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if !obj.Verbose || obj.Debug == nil || !bool(*obj.Debug) || obj.Quiet {
+		t.Errorf("Unexpected config %+v", obj)
+	}
+
+	badObj := struct {
+		Verbose string `env:"VERBOSE,presence"`
+	}{}
+	if err := marsh.Unmarshal(&badObj); err == nil {
+		t.Error("Expecting an error from unmarshalling presence into a string.")
+	}
+}
+
+func TestUnmarshalBoolSentinels(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"MODE":   "active",
+			"BACKUP": " idle ",
+			"FLAGS":  "on|off|on",
+		}},
+	}
+
+	obj := struct {
+		Mode   bool   `env:"MODE,true=active,false=idle"`
+		Backup *bool  `env:"BACKUP,true=active,false=idle"`
+		Flags  []bool `env:"FLAGS,sep=|,true=on,false=off"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if !obj.Mode || obj.Backup == nil || *obj.Backup || !reflect.DeepEqual(obj.Flags, []bool{true, false, true}) {
+		t.Errorf("Unexpected config %+v", obj)
+	}
+
+	vars, err := marsh.Marshal(&obj)
+	if err != nil {
+		t.Fatalf("Marshal should not raise error. Error: %s", err.Error())
+	}
+	if vars["MODE"] != "active" || vars["BACKUP"] != "idle" || vars["FLAGS"] != "on|off|on" {
+		t.Errorf("Unexpected marshalled vars %v", vars)
+	}
+
+	cases := []struct {
+		Value    string
+		Expected string
+	}{
+		{"paused", `expected "active" for true or "idle" for false`},
+		{"true", `expected "active" for true or "idle" for false`},
+		{"Active", `expected "active" for true or "idle" for false`},
+	}
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{Environment: &MockEnvReader{map[string]string{"MODE": c.Value}}}
+		obj := struct {
+			Mode bool `env:"MODE,true=active,false=idle"`
+		}{}
+		err := marsh.Unmarshal(&obj)
+		if err == nil || !strings.Contains(err.Error(), c.Expected) {
+			t.Errorf("TC %d: Expected an error containing %q, actual %v", i, c.Expected, err)
+		}
+	}
+
+	badTags := []interface{}{
+		&struct {
+			Mode bool `env:"MODE,true=active"`
+		}{},
+		&struct {
+			Mode bool `env:"MODE,true=on,false=on"`
+		}{},
+	}
+	for i, obj := range badTags {
+		if err := marsh.Unmarshal(obj); err == nil {
+			t.Errorf("TC %d: Expecting an error from invalid true and false options.", i)
+		}
+	}
+}
+
+func TestUnmarshalNestedMap(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"CACHE_REGIONS_US_TTL":       "30s",
+			"CACHE_REGIONS_US_STALE_TTL": "1m",
+			"CACHE_REGIONS_EU_TTL":       "10s",
+			"CACHE_REGIONS_EU_STALE_TTL": "2m",
+			"CACHE_REGIONS_":             "ignored",
+			"CACHE_REGIONS_ASIA":         "ignored",
+			"CACHE_SIZE":                 "100",
+		}},
+	}
+
+	obj := struct {
+		Regions map[string]map[string]time.Duration `env:"CACHE_REGIONS_"`
+		Labels  map[string]map[string]string        `env:"LABELS_"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	expected := map[string]map[string]time.Duration{
+		"US": {"TTL": 30 * time.Second, "STALE_TTL": time.Minute},
+		"EU": {"TTL": 10 * time.Second, "STALE_TTL": 2 * time.Minute},
+	}
+	if !reflect.DeepEqual(obj.Regions, expected) {
+		t.Errorf("Expected %v, actual %v", expected, obj.Regions)
+	}
+	if obj.Labels == nil || len(obj.Labels) != 0 {
+		t.Errorf("Expected an empty map, actual %v", obj.Labels)
+	}
+}
+
+func TestUnmarshalNestedMapFail(t *testing.T) {
+	cases := []struct {
+		Env EnvReader
+		Obj interface{}
+	}{
+		// unparseable value
+		{
+			&MockEnvReader{map[string]string{"REGIONS_US_TTL": "soon"}},
+			&struct {
+				Regions map[string]map[string]time.Duration `env:"REGIONS_"`
+			}{},
+		},
+		// unparseable outer key
+		{
+			&MockEnvReader{map[string]string{"SHARDS_A_WEIGHT": "1"}},
+			&struct {
+				Shards map[int]map[string]int `env:"SHARDS_"`
+			}{},
+		},
+		// environment cannot enumerate keys
+		{
+			&lookupOnlyEnvReader{&MockEnvReader{map[string]string{}}},
+			&struct {
+				Regions map[string]map[string]string `env:"REGIONS_"`
+			}{},
+		},
+	}
+
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{Environment: c.Env}
+		if err := marsh.Unmarshal(c.Obj); err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+		}
+	}
+}
+
+func TestUnmarshalTimePointers(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"TIMEOUT":  "1m30s",
+			"DEADLINE": "2020-03-01T12:00:00Z",
+			"BACKOFFS": "1s,2s",
+		}},
+	}
+
+	obj := struct {
+		Timeout  *time.Duration   `env:"TIMEOUT"`
+		Deadline *time.Time       `env:"DEADLINE"`
+		Backoffs []*time.Duration `env:"BACKOFFS"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if obj.Timeout == nil || *obj.Timeout != 90*time.Second {
+		t.Errorf("Expected a timeout of 1m30s, actual %v", obj.Timeout)
+	}
+	if obj.Deadline == nil || !obj.Deadline.Equal(time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected a deadline of 2020-03-01T12:00:00Z, actual %v", obj.Deadline)
+	}
+	if len(obj.Backoffs) != 2 || *obj.Backoffs[0] != time.Second || *obj.Backoffs[1] != 2*time.Second {
+		t.Errorf("Expected backoffs of [1s 2s], actual %v", obj.Backoffs)
+	}
+
+	badObj := struct {
+		Deadline *time.Time `env:"TIMEOUT"`
+	}{}
+	if err := marsh.Unmarshal(&badObj); err == nil {
+		t.Error("Expecting an error from unmarshalling a duration into a *time.Time.")
+	}
+}
+
+func TestUnmarshalMapSeparators(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"LABELS":  "team:core, search;owner:a=b;empty:",
+			"ROUTES":  "a->1|b->2",
+			"WEIGHTS": "a:1,b:2",
+			"ORDER":   "c:3;a:1",
+		}},
+		Parser: &DefaultParser{KeyValueSeparator: ":"},
+	}
+
+	obj := struct {
+		Labels  map[string]string `env:"LABELS,sep=;,kvsep=:"`
+		Routes  map[string]int    `env:"ROUTES,sep=|,kvsep=->"`
+		Weights map[string]int    `env:"WEIGHTS"`
+		Order   OrderedMap        `env:"ORDER,sep=;"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	expectedLabels := map[string]string{"team": "core, search", "owner": "a=b", "empty": ""}
+	if !reflect.DeepEqual(obj.Labels, expectedLabels) {
+		t.Errorf("Expected %v, actual %v", expectedLabels, obj.Labels)
+	}
+	if !reflect.DeepEqual(obj.Routes, map[string]int{"a": 1, "b": 2}) {
+		t.Errorf("Expected map[a:1 b:2], actual %v", obj.Routes)
+	}
+	if !reflect.DeepEqual(obj.Weights, map[string]int{"a": 1, "b": 2}) {
+		t.Errorf("Expected the parser separator to apply, actual %v", obj.Weights)
+	}
+	if !reflect.DeepEqual(obj.Order, OrderedMap{{"c", "3"}, {"a", "1"}}) {
+		t.Errorf("Expected [c:3 a:1], actual %v", obj.Order)
+	}
+
+	badObj := struct {
+		Labels map[string]string `env:"LABELS,sep=;,kvsep=="`
+	}{}
+	if err := marsh.Unmarshal(&badObj); err == nil {
+		t.Error("Expecting an error from entries without the key value separator.")
+	}
+}
+
+func TestDefaultedFields(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"NAME":      "app",
+			"BIND_HOST": "0.0.0.0",
+			"DB_HOST":   "db.local",
+		}},
+	}
+
+	if defaulted := marsh.DefaultedFields(); len(defaulted) != 0 {
+		t.Errorf("Expected no defaulted fields before unmarshalling, actual %v", defaulted)
+	}
+
+	obj := struct {
+		Name          string `env:"NAME" default:"default-app"`
+		AdvertiseHost string `env:"ADVERTISE_HOST" defaultFrom:"BIND_HOST" default:"localhost"`
+		LogLevel      string `env:"LOG_LEVEL" default:"info"`
+		Database      struct {
+			Host string `env:"HOST" default:"localhost"`
+			Port int    `env:"PORT" default:"5432"`
+		} `env:"DB_"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	expected := []string{"LogLevel", "Database.Port"}
+	if defaulted := marsh.DefaultedFields(); !reflect.DeepEqual(defaulted, expected) {
+		t.Errorf("Expected %v, actual %v", expected, defaulted)
+	}
+
+	marsh.Environment = &MockEnvReader{map[string]string{"LOG_LEVEL": "debug", "DB_PORT": "5433"}}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	expected = []string{"Name", "AdvertiseHost", "Database.Host"}
+	if defaulted := marsh.DefaultedFields(); !reflect.DeepEqual(defaulted, expected) {
+		t.Errorf("Expected %v, actual %v", expected, defaulted)
+	}
+}
+
+func TestUnmarshalDurationSign(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"TIMEOUT": "30s",
+			"NONE":    "0s",
+			"SKEW":    "-1m",
+			"OFFSETS": "-1s,-2s",
+		}},
+	}
+
+	obj := struct {
+		Timeout time.Duration   `env:"TIMEOUT,nonneg"`
+		None    *time.Duration  `env:"NONE,nonneg"`
+		Skew    time.Duration   `env:"SKEW,neg"`
+		Offsets []time.Duration `env:"OFFSETS,neg"`
+		Any     time.Duration   `env:"SKEW"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if obj.Timeout != 30*time.Second || obj.None == nil || *obj.None != 0 || obj.Skew != -time.Minute ||
+		!reflect.DeepEqual(obj.Offsets, []time.Duration{-time.Second, -2 * time.Second}) ||
+		obj.Any != -time.Minute {
+		t.Errorf("Unexpected config %+v", obj)
+	}
+}
+
+func TestUnmarshalDurationSignFail(t *testing.T) {
+	cases := []interface{}{
+		&struct {
+			Skew time.Duration `env:"SKEW,nonneg"`
+		}{},
+		&struct {
+			Timeout time.Duration `env:"TIMEOUT,neg"`
+		}{},
+		&struct {
+			None time.Duration `env:"NONE,neg"`
+		}{},
+		&struct {
+			Offsets []time.Duration `env:"OFFSETS,nonneg"`
+		}{},
+		&struct {
+			Timeout time.Duration `env:"TIMEOUT,nonneg,neg"`
+		}{},
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"TIMEOUT": "30s",
+			"NONE":    "0s",
+			"SKEW":    "-1m",
+			"OFFSETS": "1s,-2s",
+		}},
+	}
+	for i, obj := range cases {
+		if err := marsh.Unmarshal(obj); err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+		}
+	}
+}
+
+func TestUnmarshalRequired(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{"API_KEY": "abc"}},
+	}
+
+	obj := struct {
+		APIKey string `env:"API_KEY,required"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil || obj.APIKey != "abc" {
+		t.Errorf("Expected an API key of abc, actual %s (Error: %v)", obj.APIKey, err)
+	}
+
+	defaultedObj := struct {
+		Secret string `env:"SECRET,required" default:"ignored"`
+	}{}
+	if err := marsh.Unmarshal(&defaultedObj); err == nil {
+		t.Error("Expecting an error from unmarshalling a missing required field with a default.")
+	}
+}
+
+func TestUnmarshalTreatEmptyAsAbsent(t *testing.T) {
+	env := &MockEnvReader{map[string]string{"HOST": "", "PORT": "", "API_KEY": ""}}
+
+	obj := struct {
+		Host string `env:"HOST" default:"localhost"`
+		Port int    `env:"PORT" default:"8080"`
+	}{}
+	marsh := DefaultEnvMarshaler{Environment: env, TreatEmptyAsAbsent: true}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if obj.Host != "localhost" || obj.Port != 8080 {
+		t.Errorf("Expected the defaults of empty values, actual %+v", obj)
+	}
+
+	required := struct {
+		APIKey string `env:"API_KEY,required"`
+	}{}
+	err := marsh.Unmarshal(&required)
+	var missing *MissingKeyError
+	if !errors.As(err, &missing) || missing.Key != "API_KEY" {
+		t.Errorf("Expected a MissingKeyError for API_KEY, actual %v", err)
+	}
+
+	// empty values are parsed as usual unless the flag is set
+	marsh = DefaultEnvMarshaler{Environment: env}
+	if err := marsh.Unmarshal(&required); err != nil || required.APIKey != "" {
+		t.Errorf("Expected an empty API key, actual %q (Error: %v)", required.APIKey, err)
+	}
+}
+
+type lintCallback struct {
+	OnChange func() `env:"ON_CHANGE"`
+}
+
+func TestLint(t *testing.T) {
+	type Endpoint struct {
+		Host string `env:"HOST"`
+		Port int    `env:"PORT"`
+	}
+
+	obj := struct {
+		Host       string        `env:"HOST"`
+		Server     Endpoint      `env:"SERVER_"`
+		Client     Endpoint      `env:"SERVER_"`
+		Peer       *Endpoint     `env:","`
+		password   string        `env:"PASSWORD"`
+		Updates    chan string   `env:"UPDATES"`
+		Callback   lintCallback  `env:"CALLBACK_"`
+		APIKey     string        `env:"API_KEY,required" default:"changeme"`
+		Mask       uint32        `env:"MASK,base:hex"`
+		Timeout    time.Duration `env:"TIMEOUT,nonneg" default:"10s"`
+		Untagged   func()
+		Deadline   time.Time           `env:"DEADLINE"`
+		Replicas   map[string]Endpoint `env:"REPLICA_"`
+		OtherHosts []string            `env:"HOST_,collect"`
+	}{}
+
+	expected := []string{
+		"fields Server.Host and Client.Host share the env key SERVER_HOST",
+		"fields Server.Port and Client.Port share the env key SERVER_PORT",
+		"fields Host and Peer.Host share the env key HOST",
+		"struct field Peer has no prefix and its keys collide with other fields'",
+		"field password is unexported and cannot be unmarshalled",
+		"field Updates is of unsupported kind chan",
+		"field Callback.OnChange is of unsupported kind func",
+		"field APIKey is required but has a default, which is ignored",
+		"field Mask has invalid tags: invalid base hex: expected an integer from 2 to 36",
+	}
+	if warnings := Lint(&obj); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Expected warnings:\n%s\nactual:\n%s", strings.Join(expected, "\n"), strings.Join(warnings, "\n"))
+	}
+
+	if warnings := Lint(Endpoint{}); len(warnings) != 0 {
+		t.Errorf("Expected no warnings, actual %v", warnings)
+	}
+	if warnings := Lint("HOST"); len(warnings) != 1 {
+		t.Errorf("Expected a warning about linting a string, actual %v", warnings)
+	}
+}
+
+func TestUnmarshalMaxLen(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"IPS":   "10.0.0.1,10.0.0.2,10.0.0.3",
+			"ROLES": "admin,user,admin,guest",
+			"EMPTY": "",
+		}},
+	}
+
+	obj := struct {
+		IPs   []string  `env:"IPS,maxlen=3"`
+		Roles StringSet `env:"ROLES,maxlen=4"`
+		Empty []int     `env:"EMPTY,maxlen=1"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if len(obj.IPs) != 3 || len(obj.Roles) != 3 || len(obj.Empty) != 0 {
+		t.Errorf("Unexpected config %+v", obj)
+	}
+
+	cases := []interface{}{
+		&struct {
+			IPs []string `env:"IPS,maxlen=2"`
+		}{},
+		&struct {
+			Roles StringSet `env:"ROLES,maxlen=3"`
+		}{},
+		&struct {
+			IPs []string `env:"IPS,maxlen=0"`
+		}{},
+		&struct {
+			IPs []string `env:"IPS,maxlen=many"`
+		}{},
+	}
+	for i, c := range cases {
+		if err := marsh.Unmarshal(c); err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+		}
+	}
+}
+
+func TestUnmarshalTrimTrailing(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"TRAILING":       "a,b,",
+			"LEADING":        ",a,b",
+			"INTERIOR":       "a,,b",
+			"DOUBLE":         "a,b,,",
+			"ONLY_SEPARATOR": ",",
+			"PORTS":          "80;443;",
+			"UNTRIMMED":      "a,b,",
+		}},
+	}
+
+	obj := struct {
+		Trailing      []string `env:"TRAILING,trimtrailing"`
+		Leading       []string `env:"LEADING,trimtrailing"`
+		Interior      []string `env:"INTERIOR,trimtrailing"`
+		Double        []string `env:"DOUBLE,trimtrailing"`
+		OnlySeparator []string `env:"ONLY_SEPARATOR,trimtrailing"`
+		Ports         []int    `env:"PORTS,sep=;,trimtrailing,maxlen=2"`
+		Untrimmed     []string `env:"UNTRIMMED"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	expected := map[string][]string{
+		"Trailing":      {"a", "b"},
+		"Leading":       {"", "a", "b"},
+		"Interior":      {"a", "", "b"},
+		"Double":        {"a", "b", ""},
+		"OnlySeparator": {""},
+		"Untrimmed":     {"a", "b", ""},
+	}
+	actual := map[string][]string{
+		"Trailing":      obj.Trailing,
+		"Leading":       obj.Leading,
+		"Interior":      obj.Interior,
+		"Double":        obj.Double,
+		"OnlySeparator": obj.OnlySeparator,
+		"Untrimmed":     obj.Untrimmed,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v, actual %v", expected, actual)
+	}
+	if !reflect.DeepEqual(obj.Ports, []int{80, 443}) {
+		t.Errorf("Expected ports [80 443], actual %v", obj.Ports)
+	}
+}
+
+type CommonConfig struct {
+	Region   string `env:"REGION"`
+	LogLevel string `env:"LOG_LEVEL" default:"info"`
+}
+
+type ValueCommonConfig struct {
+	Debug bool `env:"DEBUG"`
+}
+
+func TestUnmarshalEmbeddedStructPointer(t *testing.T) {
+	type ServiceConfig struct {
+		*CommonConfig
+		ValueCommonConfig
+		Name string `env:"NAME"`
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"REGION":       "us-east-1",
+			"DEBUG":        "true",
+			"NAME":         "api",
+			"DB_REGION":    "eu-west-1",
+			"DB_DEBUG":     "false",
+			"DB_NAME":      "postgres",
+			"DB_LOG_LEVEL": "debug",
+		}},
+	}
+
+	config := struct {
+		ServiceConfig
+		DB ServiceConfig `env:"DB_"`
+	}{}
+	if err := marsh.Unmarshal(&config); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if config.CommonConfig == nil || config.DB.CommonConfig == nil {
+		t.Fatalf("Expected embedded pointers to be allocated, actual %+v", config)
+	}
+	if config.Region != "us-east-1" || config.LogLevel != "info" || !config.Debug || config.Name != "api" {
+		t.Errorf("Unexpected promoted fields %+v %+v", *config.CommonConfig, config.ServiceConfig)
+	}
+	if config.DB.Region != "eu-west-1" || config.DB.LogLevel != "debug" || config.DB.Debug || config.DB.Name != "postgres" {
+		t.Errorf("Unexpected prefixed promoted fields %+v %+v", *config.DB.CommonConfig, config.DB)
+	}
+
+	expectedDefaulted := []string{"ServiceConfig.CommonConfig.LogLevel"}
+	if defaulted := marsh.DefaultedFields(); !reflect.DeepEqual(defaulted, expectedDefaulted) {
+		t.Errorf("Expected defaulted fields %v, actual %v", expectedDefaulted, defaulted)
+	}
+
+	key, err := marsh.KeyFor(&config, "DB.Region")
+	if err != nil || key != "DB_REGION" {
+		t.Errorf("Expected key DB_REGION, actual %s (Error: %v)", key, err)
+	}
+
+	vars, err := marsh.Marshal(&config)
+	if err != nil {
+		t.Fatalf("Marshal should not raise error. Error: %s", err.Error())
+	}
+	if vars["REGION"] != "us-east-1" || vars["DB_LOG_LEVEL"] != "debug" || vars["DEBUG"] != "true" {
+		t.Errorf("Unexpected marshalled vars %v", vars)
+	}
+}
+
+type ServiceAddr struct {
+	Host string
+	Port int
+}
+
+func (a *ServiceAddr) UnmarshalEnv(env EnvReader) error {
+	addr, ok := env.LookupEnv("ADDR")
+	if !ok {
+		return errors.New("missing ADDR")
+	}
+
+	hostPort := strings.SplitN(addr, ":", 2)
+	if len(hostPort) != 2 {
+		return fmt.Errorf("expected host:port but received %s", addr)
+	}
+	port, err := strconv.Atoi(hostPort[1])
+	if err != nil {
+		return err
+	}
+	a.Host, a.Port = hostPort[0], port
+	return nil
+}
+
+func (a ServiceAddr) MarshalEnv() (map[string]string, error) {
+	if a.Host == "" {
+		return nil, errors.New("missing host")
+	}
+	return map[string]string{"ADDR": fmt.Sprintf("%s:%d", a.Host, a.Port)}, nil
+}
+
+func TestMarshalEnvMarshaler(t *testing.T) {
+	marsh := DefaultEnvMarshaler{}
+
+	vars, err := marsh.Marshal(&ServiceAddr{"api.local", 443})
+	if err != nil {
+		t.Fatalf("Marshal should not raise error. Error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(vars, map[string]string{"ADDR": "api.local:443"}) {
+		t.Errorf("Unexpected vars %v", vars)
+	}
+
+	type Config struct {
+		API   ServiceAddr  `env:"API_"`
+		Cache *ServiceAddr `env:"CACHE_"`
+	}
+	vars, err = marsh.Marshal(Config{API: ServiceAddr{"api.local", 443}, Cache: &ServiceAddr{"cache.local", 6379}})
+	if err != nil {
+		t.Fatalf("Marshal should not raise error. Error: %s", err.Error())
+	}
+	expected := map[string]string{"API_ADDR": "api.local:443", "CACHE_ADDR": "cache.local:6379"}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("Expected %v, actual %v", expected, vars)
+	}
+
+	roundTrip := Config{}
+	marsh.Environment = &MockEnvReader{vars}
+	if err := marsh.Unmarshal(&roundTrip); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if roundTrip.API != (ServiceAddr{"api.local", 443}) || roundTrip.Cache == nil ||
+		*roundTrip.Cache != (ServiceAddr{"cache.local", 6379}) {
+		t.Errorf("Unexpected round trip %+v", roundTrip)
+	}
+
+	if _, err := marsh.Marshal(Config{}); err == nil {
+		t.Error("Expecting an error from MarshalEnv.")
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	type Config struct {
+		Name     string                       `env:"NAME"`
+		Port     uint16                       `env:"PORT"`
+		Mask     uint32                       `env:"MASK,base:8"`
+		Ratio    float32                      `env:"RATIO"`
+		Verbose  bool                         `env:"VERBOSE,presence"`
+		Quiet    bool                         `env:"QUIET,presence"`
+		Timeout  time.Duration                `env:"TIMEOUT"`
+		Cutoff   time.Time                    `env:"CUTOFF" envFormat:"2006-01-02 15:04" envTZ:"America/New_York"`
+		Hosts    []string                     `env:"HOSTS"`
+		Paths    []string                     `env:"PATHS,sep=:"`
+		Labels   map[string]string            `env:"LABELS,sep=;,kvsep=:"`
+		Order    OrderedMap                   `env:"ORDER"`
+		Roles    StringSet                    `env:"ROLES"`
+		Origins  []string                     `env:"ORIGIN_,collect"`
+		DBs      map[string]*DBConfig         `env:"DB_"`
+		Regions  map[string]map[string]string `env:"REGION_"`
+		IP       net.IP                       `env:"IP"`
+		Endpoint EndpointConfig               `env:"ENDPOINT_"`
+		Missing  *string                      `env:"MISSING"`
+	}
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Cannot load location America/New_York: %s", err)
+	}
+	config := Config{
+		Name:     "app",
+		Port:     8080,
+		Mask:     0755,
+		Ratio:    0.25,
+		Verbose:  true,
+		Timeout:  90 * time.Second,
+		Cutoff:   time.Date(2020, 3, 1, 9, 30, 0, 0, newYork),
+		Hosts:    []string{"a.local", "b.local"},
+		Paths:    []string{"/usr/bin", "/bin"},
+		Labels:   map[string]string{"team": "core, search", "owner": "a=b"},
+		Order:    OrderedMap{{"c", "3"}, {"a", "1"}},
+		Roles:    StringSet{"admin": {}, "user": {}},
+		Origins:  []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"},
+		DBs:      map[string]*DBConfig{"primary": {"primary.local", 5432}},
+		Regions:  map[string]map[string]string{"US": {"STALE_TTL": "1m"}},
+		IP:       net.ParseIP("10.0.0.1"),
+		Endpoint: EndpointConfig{"0.0.0.0", 443},
+	}
+
+	marsh := DefaultEnvMarshaler{}
+	vars, err := marsh.Marshal(&config)
+	if err != nil {
+		t.Fatalf("Marshal should not raise error. Error: %s", err.Error())
+	}
+
+	for key, val := range map[string]string{
+		"MASK":                "755",
+		"IP":                  "10.0.0.1",
+		"VERBOSE":             "",
+		"CUTOFF":              "2020-03-01 09:30",
+		"PATHS":               "/usr/bin:/bin",
+		"LABELS":              "owner:a=b;team:core, search",
+		"ROLES":               "admin,user",
+		"ORIGIN_01":           "1",
+		"ORIGIN_10":           "10",
+		"DB_primary_PORT":     "5432",
+		"REGION_US_STALE_TTL": "1m",
+	} {
+		if vars[key] != val {
+			t.Errorf("Expected %s=%s, actual %s", key, val, vars[key])
+		}
+	}
+	for _, key := range []string{"QUIET", "MISSING"} {
+		if _, ok := vars[key]; ok {
+			t.Errorf("Expected %s to be omitted", key)
+		}
+	}
+
+	roundTrip := Config{}
+	marsh.Environment = &MockEnvReader{vars}
+	if err := marsh.Unmarshal(&roundTrip); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if roundTrip.Missing != nil {
+		t.Errorf("Expected the omitted MISSING to be nil, actual %s", *roundTrip.Missing)
+	}
+
+	missing := "found"
+	config.Missing = &missing
+	vars["MISSING"] = "found"
+	roundTrip = Config{}
+	if err := marsh.Unmarshal(&roundTrip); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if !roundTrip.Cutoff.Equal(config.Cutoff) {
+		t.Errorf("Expected a cutoff of %v, actual %v", config.Cutoff, roundTrip.Cutoff)
+	}
+	roundTrip.Cutoff = config.Cutoff
+	if !reflect.DeepEqual(roundTrip, config) {
+		t.Errorf("Expected %+v, actual %+v", config, roundTrip)
+	}
+}
+
+// LoggerMock is a Logger that records its messages.
+type LoggerMock struct {
+	Messages []string
+}
+
+func (l *LoggerMock) Printf(format string, v ...interface{}) {
+	l.Messages = append(l.Messages, fmt.Sprintf(format, v...))
+}
+
+func TestUnmarshalSkipOnParseError(t *testing.T) {
+	logger := &LoggerMock{}
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"NAME":        "app",
+			"WORKERS":     "many",
+			"TIMEOUT":     "soon",
+			"RATIO":       "half",
+			"BAD_DEFAULT": "x",
+			"PIN":         "hunter2",
+		}},
+		SkipOnParseError: true,
+		Logger:           logger,
+	}
+
+	obj := struct {
+		Name       string         `env:"NAME"`
+		Workers    int            `env:"WORKERS"`
+		Timeout    *time.Duration `env:"TIMEOUT"`
+		Ratio      float64        `env:"RATIO" default:"0.5"`
+		BadDefault int            `env:"BAD_DEFAULT" default:"y"`
+		Pin        int            `env:"PIN" secret:"true"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if obj.Name != "app" || obj.Workers != 0 || obj.Timeout != nil || obj.Ratio != 0.5 ||
+		obj.BadDefault != 0 || obj.Pin != 0 {
+		t.Errorf("Unexpected config %+v", obj)
+	}
+	if !reflect.DeepEqual(marsh.DefaultedFields(), []string{"Ratio"}) {
+		t.Errorf("Expected Ratio to be defaulted, actual %v", marsh.DefaultedFields())
+	}
+
+	if len(logger.Messages) != 5 {
+		t.Fatalf("Expected 5 warnings, actual %q", logger.Messages)
+	}
+	for i, field := range []string{"Workers", "Timeout", "Ratio", "BadDefault", "Pin"} {
+		if !strings.HasPrefix(logger.Messages[i], "skipping field "+field+": ") {
+			t.Errorf("Expected a warning about %s, actual %s", field, logger.Messages[i])
+		}
+	}
+	if strings.Contains(logger.Messages[4], "hunter2") {
+		t.Errorf("Expected the secret to be redacted, actual %s", logger.Messages[4])
+	}
+
+	required := struct {
+		Workers int `env:"WORKERS,required"`
+	}{}
+	if err := marsh.Unmarshal(&required); err == nil {
+		t.Error("Expecting an error from unmarshalling a malformed required field.")
+	}
+
+	missing := struct {
+		Port int `env:"PORT"`
+	}{}
+	if err := marsh.Unmarshal(&missing); err == nil {
+		t.Error("Expecting an error from unmarshalling a missing field.")
+	}
+}
+
+func TestUnmarshalAbsoluteKey(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"GLOBAL_REGION":      "us-east-1",
+			"DEFAULT_BUCKET":     "shared",
+			"APP_STORAGE_REGION": "eu-west-1",
+			"APP_STORAGE_PATH":   "/data",
+		}},
+	}
+
+	type Storage struct {
+		Region string `env:"GLOBAL_REGION,abs"`
+		Bucket string `env:"BUCKET,abs" defaultFrom:"DEFAULT_BUCKET"`
+		Path   string `env:"PATH"`
+	}
+	obj := struct {
+		Storage Storage `env:"STORAGE_"`
+	}{}
+	if err := marsh.UnmarshalFrom("APP_", &obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	expected := Storage{Region: "us-east-1", Bucket: "shared", Path: "/data"}
+	if obj.Storage != expected {
+		t.Errorf("Expected %+v, actual %+v", expected, obj.Storage)
+	}
+
+	vars, err := marsh.Marshal(&obj)
+	if err != nil {
+		t.Fatalf("Marshal should not raise error. Error: %s", err.Error())
+	}
+	if _, ok := vars["GLOBAL_REGION"]; !ok {
+		t.Errorf("Expected GLOBAL_REGION to be marshalled, actual %v", vars)
+	}
+}
+
+func TestKeyFor(t *testing.T) {
+	type Config struct {
+		Name     string `env:"NAME"`
+		Database *struct {
+			Host   string `env:"HOST"`
+			Region string `env:"GLOBAL_REGION,abs"`
+			Port   int
+		} `env:"DB_"`
+		Server struct {
+			TLS struct {
+				Cert string `env:"cert-file"`
+			} `env:"tls."`
+		} `env:"server."`
+	}
+
+	marsh := DefaultEnvMarshaler{}
+	cases := []struct {
+		Field    string
+		Expected string
+	}{
+		{"Name", "NAME"},
+		{"Database", "DB_"},
+		{"Database.Host", "DB_HOST"},
+		{"Database.Region", "GLOBAL_REGION"},
+		{"Server.TLS.Cert", "server.tls.cert-file"},
+	}
+	for i, c := range cases {
+		key, err := marsh.KeyFor(&Config{}, c.Field)
+		if err != nil || key != c.Expected {
+			t.Errorf("TC %d: Expected %s, actual %s (Error: %v)", i, c.Expected, key, err)
+		}
+	}
+
+	normalized := DefaultEnvMarshaler{KeyNormalizer: NormalizeUpperSnake}
+	if key, err := normalized.KeyFor(Config{}, "Server.TLS.Cert"); err != nil || key != "SERVER_TLS_CERT_FILE" {
+		t.Errorf("Expected SERVER_TLS_CERT_FILE, actual %s (Error: %v)", key, err)
+	}
+
+	for i, field := range []string{"Missing", "Database.Port", "Name.Length", "Database.Missing", ""} {
+		if _, err := marsh.KeyFor(&Config{}, field); err == nil {
+			t.Errorf("TC %d: Expecting an error from the key for %s.", i, field)
+		}
+	}
+	if _, err := marsh.KeyFor(nil, "Name"); err == nil {
+		t.Error("Expecting an error from the key for a nil object.")
+	}
+}
+
+func TestUnmarshalEnvironmentDefaults(t *testing.T) {
+	type Config struct {
+		LogLevel string `env:"LOG_LEVEL" defaultProd:"warn" defaultDev:"debug" default:"info"`
+		Replicas int    `env:"REPLICAS" defaultProd:"3" default:"1"`
+		Region   string `env:"REGION" defaultProd:"us-east-1"`
+	}
+
+	cases := []struct {
+		EnvironmentName string
+		Env             map[string]string
+		Expected        Config
+	}{
+		{"prod", map[string]string{}, Config{"warn", 3, "us-east-1"}},
+		{"Prod", map[string]string{}, Config{"warn", 3, "us-east-1"}},
+		{"dev", map[string]string{"REGION": "local"}, Config{"debug", 1, "local"}},
+		{"staging", map[string]string{"REGION": "us-west-2"}, Config{"info", 1, "us-west-2"}},
+		{"", map[string]string{"REGION": "us-west-2"}, Config{"info", 1, "us-west-2"}},
+		{"prod", map[string]string{"LOG_LEVEL": "error"}, Config{"error", 3, "us-east-1"}},
+	}
+
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{
+			Environment:     &MockEnvReader{c.Env},
+			EnvironmentName: c.EnvironmentName,
+		}
+		obj := Config{}
+		if err := marsh.Unmarshal(&obj); err != nil {
+			t.Errorf("TC %d: Unmarshal should not raise error. Error: %s", i, err.Error())
+			continue
+		}
+		if obj != c.Expected {
+			t.Errorf("TC %d: Expected %+v, actual %+v", i, c.Expected, obj)
+		}
+	}
+
+	marsh := DefaultEnvMarshaler{Environment: &MockEnvReader{map[string]string{}}, EnvironmentName: "dev"}
+	if err := marsh.Unmarshal(&Config{}); err == nil {
+		t.Error("Expecting an error from unmarshalling REGION without a default for dev.")
+	}
+}
+
+func TestUnmarshalBounds(t *testing.T) {
+	type Config struct {
+		Port     int             `env:"PORT" min:"1" max:"65535"`
+		Workers  *uint8          `env:"WORKERS" min:"1"`
+		Ratio    float64         `env:"RATIO" min:"0" max:"1"`
+		Timeout  time.Duration   `env:"TIMEOUT" min:"1s" max:"1m"`
+		Priority int             `env:"PRIORITY,max=10"`
+		Ports    []int           `env:"PORTS,min=1,max=65535"`
+		Delays   []time.Duration `env:"RETRY_DELAYS,min=1ms,max=1m"`
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"PORT":         "65535",
+			"WORKERS":      "1",
+			"RATIO":        "0",
+			"TIMEOUT":      "30s",
+			"PRIORITY":     "-5",
+			"PORTS":        "1,443,65535",
+			"RETRY_DELAYS": "100ms,1s,5s",
+		}},
+	}
+	obj := Config{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if obj.Port != 65535 || obj.Workers == nil || *obj.Workers != 1 || obj.Ratio != 0 ||
+		obj.Timeout != 30*time.Second || obj.Priority != -5 || !reflect.DeepEqual(obj.Ports, []int{1, 443, 65535}) ||
+		!reflect.DeepEqual(obj.Delays, []time.Duration{100 * time.Millisecond, time.Second, 5 * time.Second}) {
+		t.Errorf("Unexpected config %+v", obj)
+	}
+
+	cases := []struct {
+		Key      string
+		Value    string
+		Expected string
+	}{
+		{"PORT", "0", "0 is out of range: min is 1"},
+		{"PORT", "65536", "65536 is out of range: max is 65535"},
+		{"WORKERS", "0", "0 is out of range: min is 1"},
+		{"RATIO", "1.5", "1.5 is out of range: max is 1"},
+		{"RATIO", "-0.1", "-0.1 is out of range: min is 0"},
+		{"TIMEOUT", "500ms", "500ms is out of range: min is 1s"},
+		{"TIMEOUT", "2m", "2m0s is out of range: max is 1m"},
+		{"PRIORITY", "11", "11 is out of range: max is 10"},
+		{"PORTS", "80,70000,443", "element 1: 70000 is out of range: max is 65535"},
+		{"PORTS", "0", "element 0: 0 is out of range: min is 1"},
+		{"RETRY_DELAYS", "100ms,2m,5s", "element 1: 2m0s is out of range: max is 1m"},
+		{"RETRY_DELAYS", "500us", "element 0: 500µs is out of range: min is 1ms"},
+	}
+	for i, c := range cases {
+		env := map[string]string{
+			"PORT":         "8080",
+			"WORKERS":      "4",
+			"RATIO":        "0.5",
+			"TIMEOUT":      "30s",
+			"PRIORITY":     "1",
+			"PORTS":        "80",
+			"RETRY_DELAYS": "1s",
+		}
+		env[c.Key] = c.Value
+		marsh := DefaultEnvMarshaler{Environment: &MockEnvReader{env}}
+
+		err := marsh.Unmarshal(&Config{})
+		if err == nil || !strings.Contains(err.Error(), c.Expected) {
+			t.Errorf("TC %d: Expected an error containing %q, actual %v", i, c.Expected, err)
+		}
+	}
+
+	badBound := struct {
+		Port int `env:"PORT" min:"one"`
+	}{}
+	if err := marsh.Unmarshal(&badBound); err == nil {
+		t.Error("Expecting an error from an invalid min tag.")
+	}
+}
+
+func TestUnmarshalBoundedDurations(t *testing.T) {
+	type Config struct {
+		RetryDelays []time.Duration `env:"RetryDelays,min=1ms,max=1m"`
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{"RetryDelays": "100ms,1s,5s,1m"}},
+	}
+	obj := Config{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	expected := []time.Duration{100 * time.Millisecond, time.Second, 5 * time.Second, time.Minute}
+	if !reflect.DeepEqual(obj.RetryDelays, expected) {
+		t.Errorf("Expected %v, actual %v", expected, obj.RetryDelays)
+	}
+
+	marsh.Environment = &MockEnvReader{map[string]string{"RetryDelays": "100ms,0.5ms,5s"}}
+	err := marsh.Unmarshal(&Config{})
+	if err == nil || !strings.Contains(err.Error(), "element 1: 500µs is out of range: min is 1ms") {
+		t.Errorf("Expected an error for element 1, actual %v", err)
+	}
+}
+
+func TestUnmarshalPattern(t *testing.T) {
+	type Config struct {
+		Service string   `env:"SERVICE" pattern:"^[a-z][a-z0-9-]*$"`
+		Regions []string `env:"REGIONS" pattern:"^[a-z]{2}-[a-z]+$"`
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"SERVICE": "billing-api",
+			"REGIONS": "us-east,eu-west",
+		}},
+	}
+	obj := Config{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if obj.Service != "billing-api" || !reflect.DeepEqual(obj.Regions, []string{"us-east", "eu-west"}) {
+		t.Errorf("Unexpected config %+v", obj)
+	}
+
+	cases := []struct {
+		Key      string
+		Value    string
+		Expected string
+	}{
+		{"SERVICE", "Billing_API", "Billing_API does not match the pattern ^[a-z][a-z0-9-]*$"},
+		{"REGIONS", "us-east,EU", "EU does not match the pattern ^[a-z]{2}-[a-z]+$"},
+	}
+	for i, c := range cases {
+		env := map[string]string{"SERVICE": "billing", "REGIONS": "us-east"}
+		env[c.Key] = c.Value
+		marsh := DefaultEnvMarshaler{Environment: &MockEnvReader{env}}
+
+		err := marsh.Unmarshal(&Config{})
+		if err == nil || !strings.Contains(err.Error(), c.Expected) {
+			t.Errorf("TC %d: Expected an error containing %q, actual %v", i, c.Expected, err)
+		}
+	}
+
+	invalid := struct {
+		Service string `env:"SERVICE" pattern:"^[a-z"`
+	}{}
+	err := marsh.Unmarshal(&invalid)
+	if err == nil || !strings.Contains(err.Error(), "invalid pattern ^[a-z") {
+		t.Errorf("Expected an error for an invalid pattern, actual %v", err)
+	}
+}
+
+func TestUnmarshalWithResult(t *testing.T) {
+	type DBConfig struct {
+		Host     string `env:"HOST" defaultFrom:"DEFAULT_HOST"`
+		Port     int    `env:"PORT" default:"5432"`
+		Password string `env:"PASSWORD" secret:"true"`
+	}
+	type Config struct {
+		Name    string    `env:"NAME"`
+		Workers int       `env:"WORKERS" default:"4"`
+		Timeout int       `env:"TIMEOUT" default:"30"`
+		Verbose bool      `env:"VERBOSE,presence"`
+		DB      DBConfig  `env:"DB_"`
+		Metrics *DBConfig `env:"METRICS_" enabledBy:"METRICS_ENABLED"`
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"NAME":            "api",
+			"TIMEOUT":         "soon",
+			"VERBOSE":         "",
+			"DB_DEFAULT_HOST": "db.local",
+			"DB_PASSWORD":     "hunter2",
+		}},
+		SkipOnParseError: true,
+	}
+
+	config := Config{}
+	result, err := marsh.UnmarshalWithResult(&config)
+	if err != nil {
+		t.Fatalf("UnmarshalWithResult should not raise error. Error: %s", err.Error())
+	}
+	if config.Name != "api" || config.Workers != 4 || config.Timeout != 30 || !config.Verbose ||
+		config.DB.Host != "db.local" || config.DB.Port != 5432 || config.Metrics != nil {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	expected := &UnmarshalResult{
+		Keys: map[string]string{
+			"Name":        "NAME",
+			"Verbose":     "VERBOSE",
+			"DB.Host":     "DB_DEFAULT_HOST",
+			"DB.Password": "DB_PASSWORD",
+		},
+		Defaulted: []string{"Workers", "Timeout", "DB.Port"},
+		Skipped:   []string{"Timeout", "Metrics"},
+		RawValues: map[string]string{
+			"Name":    "api",
+			"Workers": "4",
+			"Timeout": "30",
+			"Verbose": "",
+			"DB.Host": "db.local",
+			"DB.Port": "5432",
+		},
+		Sources: map[string]ValueSource{
+			"Name":        SourceEnv,
+			"Workers":     SourceDefault,
+			"Timeout":     SourceDefault,
+			"Verbose":     SourceEnv,
+			"DB.Host":     SourceDefaultFrom,
+			"DB.Port":     SourceDefault,
+			"DB.Password": SourceEnv,
+		},
+		Warnings: []Warning{
+			{WarningDefaulted, "WORKERS", "field Workers fell back to its default"},
+			{WarningSkipped, "TIMEOUT", "skipping field Timeout: cannot unmarshal soon to type int (Env: TIMEOUT): " +
+				`Cannot convert soon to int: strconv.ParseInt: parsing "soon": invalid syntax`},
+			{WarningDefaulted, "TIMEOUT", "field Timeout fell back to its default"},
+			{WarningDefaulted, "DB_PORT", "field DB.Port fell back to its default"},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected result %+v, actual %+v", expected, result)
+	}
+
+	if defaulted := marsh.DefaultedFields(); len(defaulted) != 0 {
+		t.Errorf("Expected the marshaler's state to be untouched, actual defaulted fields %v", defaulted)
+	}
+
+	marsh.SkipOnParseError = false
+	if result, err := marsh.UnmarshalWithResult(&Config{}); err == nil || result != nil {
+		t.Errorf("Expecting an error and no result, actual %+v", result)
+	}
+}
+
+type RecordUser struct {
+	Name   string `env:"NAME"`
+	Role   string `env:"ROLE"`
+	Notes  string
+	Groups []string `env:"GROUPS,sep=+"`
+	UID    int      `env:"UID,base=16"`
+}
+
+func TestUnmarshalRecords(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"USERS":  "alice;admin;ops+dev;ff | bob; user;;10",
+			"EMPTY":  "",
+			"ADMINS": "carol;admin;ops;1",
+		}},
+	}
+
+	obj := struct {
+		Users  []RecordUser `env:"USERS,recsep=|,fieldsep=;"`
+		Empty  []RecordUser `env:"EMPTY,recsep=|,fieldsep=;"`
+		Admins []RecordUser `env:"ADMINS,fieldsep=;"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	expected := []RecordUser{
+		{Name: "alice", Role: "admin", Groups: []string{"ops", "dev"}, UID: 255},
+		{Name: "bob", Role: "user", Groups: []string{}, UID: 16},
+	}
+	if !reflect.DeepEqual(obj.Users, expected) {
+		t.Errorf("Expected users %+v, actual %+v", expected, obj.Users)
+	}
+	if len(obj.Empty) != 0 || len(obj.Admins) != 1 || obj.Admins[0].Name != "carol" {
+		t.Errorf("Unexpected records %+v", obj)
+	}
+
+	vars, err := marsh.Marshal(&obj)
+	if err != nil {
+		t.Fatalf("Marshal should not raise error. Error: %s", err.Error())
+	}
+	if vars["USERS"] != "alice;admin;ops+dev;ff|bob;user;;10" {
+		t.Errorf("Unexpected marshalled users %s", vars["USERS"])
+	}
+
+	cases := []struct {
+		Value    string
+		Expected string
+	}{
+		{"alice;admin;ops;1|bob;user", "Expected 4 fields"},
+		{"alice;admin;ops;1;extra", "Expected 4 fields"},
+		{"alice;admin;ops;xyz", "Could not marshal field UID"},
+	}
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{
+			Environment: &MockEnvReader{map[string]string{"USERS": c.Value}},
+		}
+		obj := struct {
+			Users []RecordUser `env:"USERS,recsep=|,fieldsep=;"`
+		}{}
+		err := marsh.Unmarshal(&obj)
+		if err == nil || !strings.Contains(err.Error(), c.Expected) {
+			t.Errorf("TC %d: Expected an error containing %q, actual %v", i, c.Expected, err)
+		}
+	}
+
+	noSeparator := struct {
+		Users []RecordUser `env:"USERS,recsep=|"`
+	}{}
+	if err := marsh.Unmarshal(&noSeparator); err == nil {
+		t.Error("Expecting an error from records without a field separator.")
+	}
+}
+
+type KeyValueHeader struct {
+	Name  string `env:"NAME"`
+	Value string `env:"VALUE"`
+}
+
+func TestUnmarshalKeyValueRecords(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"HEADERS": "X-A=1,X-B=2",
+			"QUERY":   "q:a=b;page:2",
+		}},
+	}
+
+	obj := struct {
+		Headers []KeyValueHeader `env:"HEADERS,kv"`
+		Query   []KeyValueHeader `env:"QUERY,kv,sep=;,kvsep=:"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	expected := []KeyValueHeader{{Name: "X-A", Value: "1"}, {Name: "X-B", Value: "2"}}
+	if !reflect.DeepEqual(obj.Headers, expected) {
+		t.Errorf("Expected headers %+v, actual %+v", expected, obj.Headers)
+	}
+	expected = []KeyValueHeader{{Name: "q", Value: "a=b"}, {Name: "page", Value: "2"}}
+	if !reflect.DeepEqual(obj.Query, expected) {
+		t.Errorf("Expected query %+v, actual %+v", expected, obj.Query)
+	}
+
+	vars, err := marsh.Marshal(&obj)
+	if err != nil {
+		t.Fatalf("Marshal should not raise error. Error: %s", err.Error())
+	}
+	if vars["HEADERS"] != "X-A=1,X-B=2" || vars["QUERY"] != "q:a=b;page:2" {
+		t.Errorf("Unexpected marshalled values %+v", vars)
+	}
+
+	cases := []struct {
+		Value    string
+		Expected string
+	}{
+		{"X-A=1,X-B", "Expected an entry of the form key=value"},
+		{"X-A", "Expected an entry of the form key=value"},
+	}
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{
+			Environment: &MockEnvReader{map[string]string{"HEADERS": c.Value}},
+		}
+		obj := struct {
+			Headers []KeyValueHeader `env:"HEADERS,kv"`
+		}{}
+		err := marsh.Unmarshal(&obj)
+		if err == nil || !strings.Contains(err.Error(), c.Expected) {
+			t.Errorf("TC %d: Expected an error containing %q, actual %v", i, c.Expected, err)
+		}
+	}
+
+	marsh = DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{"USERS": "alice=admin"}},
+	}
+	threeFields := struct {
+		Users []RecordUser `env:"USERS,kv"`
+	}{}
+	err = marsh.Unmarshal(&threeFields)
+	if err == nil || !strings.Contains(err.Error(), "expected 2 fields with env tags, found 4") {
+		t.Errorf("Expected an error for a struct without exactly two fields, actual %v", err)
+	}
+}
+
+func TestUnmarshalSplit(t *testing.T) {
+	type SplitDB struct {
+		Host string `env:"HOST"`
+		Port int    `env:"PORT" default:"5432"`
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"DB":      "host=localhost; Port=6543",
+			"REPLICA": "HOST=replica|port=5433",
+		}},
+	}
+
+	obj := struct {
+		DB      SplitDB  `env:"DB,split"`
+		Replica SplitDB  `env:"REPLICA,split,sep=|"`
+		Backup  *SplitDB `env:"BACKUP,split"`
+		Cache   SplitDB  `env:"CACHE,split" default:"host=cache"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if obj.DB != (SplitDB{Host: "localhost", Port: 6543}) {
+		t.Errorf("Unexpected DB %+v", obj.DB)
+	}
+	if obj.Replica != (SplitDB{Host: "replica", Port: 5433}) {
+		t.Errorf("Unexpected replica %+v", obj.Replica)
+	}
+	if obj.Backup != nil {
+		t.Errorf("Expected a nil backup, actual %+v", obj.Backup)
+	}
+	if obj.Cache != (SplitDB{Host: "cache", Port: 5432}) {
+		t.Errorf("Unexpected cache %+v", obj.Cache)
+	}
+
+	vars, err := marsh.Marshal(&obj)
+	if err != nil {
+		t.Fatalf("Marshal should not raise error. Error: %s", err.Error())
+	}
+	if vars["DB"] != "HOST=localhost;PORT=6543" || vars["REPLICA"] != "HOST=replica|PORT=5433" {
+		t.Errorf("Unexpected marshalled vars %v", vars)
+	}
+
+	cases := []struct {
+		Value    string
+		Expected string
+	}{
+		{"host=localhost;port", "is not of the form key=value"},
+		{"host=localhost;port=x", "PORT"},
+	}
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{
+			Environment: &MockEnvReader{map[string]string{"DB": c.Value}},
+		}
+		obj := struct {
+			DB SplitDB `env:"DB,split"`
+		}{}
+		err := marsh.Unmarshal(&obj)
+		if err == nil || !strings.Contains(err.Error(), c.Expected) {
+			t.Errorf("TC %d: Expected an error containing %q, actual %v", i, c.Expected, err)
+		}
+	}
+
+	for i, value := range []string{"user=a;hunter2", "user=a;port=hunter2"} {
+		marsh := DefaultEnvMarshaler{
+			Environment: &MockEnvReader{map[string]string{"DB": value}},
+		}
+		obj := struct {
+			DB SplitDB `env:"DB,split" secret:"true"`
+		}{}
+		err := marsh.Unmarshal(&obj)
+		if err == nil {
+			t.Errorf("TC %d: Expected an error unmarshalling %s.", i, value)
+			continue
+		}
+		if strings.Contains(err.Error(), "hunter2") {
+			t.Errorf("TC %d: Expected the secret value to be redacted, actual %s", i, err.Error())
+		}
+		if !strings.Contains(err.Error(), RedactedValue) {
+			t.Errorf("TC %d: Expected the error to contain %s, actual %s", i, RedactedValue, err.Error())
+		}
+	}
+}
+
+func TestUnmarshalBlob(t *testing.T) {
+	type Config struct {
+		Name string `json:"name"`
+		DB   struct {
+			Host string `json:"host"`
+			Port int    `json:"port"`
+		} `json:"db"`
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"PLAIN":     ` {"name":"app","db":{"host":"db.local","port":5432}} `,
+			"ENCODED":   "eyJuYW1lIjoiYXBwIiwgImRiIjp7Imhvc3QiOiJkYi5sb2NhbCIsInBvcnQiOjU0MzJ9fQ==",
+			"UNPADDED":  "eyJuYW1lIjoiYXBwIiwgImRiIjp7Imhvc3QiOiJkYi5sb2NhbCIsInBvcnQiOjU0MzJ9fQ",
+			"BAD_B64":   "not base64!",
+			"BAD_JSON":  `{"name":`,
+			"BAD_TYPES": `{"db":{"port":"x"}}`,
+		}},
+	}
+
+	for _, key := range []string{"PLAIN", "ENCODED", "UNPADDED"} {
+		obj := Config{}
+		if err := marsh.UnmarshalBlob(key, &obj); err != nil {
+			t.Fatalf("%s: UnmarshalBlob should not raise error. Error: %s", key, err.Error())
+		}
+		if obj.Name != "app" || obj.DB.Host != "db.local" || obj.DB.Port != 5432 {
+			t.Errorf("%s: Unexpected config %+v", key, obj)
+		}
+	}
+
+	cases := []struct {
+		Key      string
+		Expected string
+	}{
+		{"MISSING", "cannot retrieve any value from environment var MISSING"},
+		{"BAD_B64", "cannot decode BAD_B64 as base64"},
+		{"BAD_JSON", "cannot unmarshal BAD_JSON as JSON"},
+		{"BAD_TYPES", "cannot unmarshal BAD_TYPES as JSON"},
+	}
+	for i, c := range cases {
+		err := marsh.UnmarshalBlob(c.Key, &Config{})
+		if err == nil || !strings.Contains(err.Error(), c.Expected) {
+			t.Errorf("TC %d: Expected an error containing %q, actual %v", i, c.Expected, err)
+		}
+	}
+}
+
+func TestUnmarshalStripComment(t *testing.T) {
+	cases := []struct {
+		Value    string
+		Expected string
+	}{
+		{"db.local # the primary", "db.local"},
+		{"db.local\t#", "db.local"},
+		{"db.local  #  # twice", "db.local"},
+		{"#fff", "#fff"},
+		{"pass#word", "pass#word"},
+		{"color #fff", "color #fff"},
+		{"db.local", "db.local"},
+	}
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{
+			Environment: &MockEnvReader{map[string]string{"HOST": c.Value}},
+		}
+		obj := struct {
+			Host string `env:"HOST,stripcomment"`
+		}{}
+		if err := marsh.Unmarshal(&obj); err != nil {
+			t.Errorf("TC %d: Unmarshal should not raise error. Error: %s", i, err.Error())
+		}
+		if obj.Host != c.Expected {
+			t.Errorf("TC %d: Expected %q, actual %q", i, c.Expected, obj.Host)
+		}
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"PORT":  "8080 # the http port",
+			"PLAIN": "a # b",
+		}},
+	}
+	obj := struct {
+		Port  int    `env:"PORT,stripcomment"`
+		Plain string `env:"PLAIN"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if obj.Port != 8080 || obj.Plain != "a # b" {
+		t.Errorf("Unexpected values %+v", obj)
+	}
+}
+
+func TestUnmarshalCaseConversion(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"HOST":    " DB.Example.COM ",
+			"HOSTS":   "A.example.com,b.EXAMPLE.com",
+			"REGIONS": "us-East=Primary,eu-west=Secondary",
+			"LEVEL":   "warn",
+			"ROLES":   "Admin,ADMIN,user",
+		}},
+	}
+
+	obj := struct {
+		Host    string            `env:"HOST,lower"`
+		Hosts   []string          `env:"HOSTS,lower"`
+		Regions map[string]string `env:"REGIONS,upper"`
+		Level   *string           `env:"LEVEL,upper"`
+		Roles   StringSet         `env:"ROLES,lower"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if obj.Host != "db.example.com" {
+		t.Errorf("Expected a lowercased host, actual %s", obj.Host)
+	}
+	if !reflect.DeepEqual(obj.Hosts, []string{"a.example.com", "b.example.com"}) {
+		t.Errorf("Expected lowercased hosts, actual %v", obj.Hosts)
+	}
+	if !reflect.DeepEqual(obj.Regions, map[string]string{"US-EAST": "PRIMARY", "EU-WEST": "SECONDARY"}) {
+		t.Errorf("Expected uppercased regions, actual %v", obj.Regions)
+	}
+	if obj.Level == nil || *obj.Level != "WARN" {
+		t.Errorf("Expected an uppercased level, actual %v", obj.Level)
+	}
+	if !reflect.DeepEqual(obj.Roles, StringSet{"admin": {}, "user": {}}) {
+		t.Errorf("Expected lowercased roles, actual %v", obj.Roles)
+	}
+
+	both := struct {
+		Host string `env:"HOST,lower,upper"`
+	}{}
+	if err := marsh.Unmarshal(&both); err == nil {
+		t.Error("Expecting an error from the options lower and upper together.")
+	}
+}
+
+func TestUnmarshalTemplate(t *testing.T) {
+	type DBConfig struct {
+		DSN     string         `env:"-" template:"postgres://${HOST}:${PORT}/${NAME}"`
+		Host    string         `env:"HOST"`
+		Port    int            `env:"PORT" default:"5432"`
+		Name    string         `env:"NAME"`
+		Timeout *time.Duration `env:"TIMEOUT" template:"${TIMEOUT_SECONDS}s"`
+		Ignored string         `env:"-"`
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"DB_HOST":            "db.local",
+			"DB_NAME":            "orders",
+			"DB_TIMEOUT_SECONDS": "30",
+			"DB_TIMEOUT":         "ignored",
+		}},
+	}
+
+	obj := struct {
+		DB DBConfig `env:"DB_"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if obj.DB.DSN != "postgres://db.local:5432/orders" {
+		t.Errorf("Unexpected DSN %s", obj.DB.DSN)
+	}
+	if obj.DB.Timeout == nil || *obj.DB.Timeout != 30*time.Second {
+		t.Errorf("Unexpected timeout %v", obj.DB.Timeout)
+	}
+
+	vars, err := marsh.Marshal(&obj)
+	if err != nil {
+		t.Fatalf("Marshal should not raise error. Error: %s", err.Error())
+	}
+	if _, ok := vars["DB_TIMEOUT"]; ok {
+		t.Errorf("Expected computed fields not to be marshalled, actual %v", vars)
+	}
+
+	missing := struct {
+		Addr string `env:"-" template:"${DB_HOST}:${DB_PORT}"`
+	}{}
+	var missingErr *MissingKeyError
+	if err := marsh.Unmarshal(&missing); !errors.As(err, &missingErr) || missingErr.Key != "DB_PORT" {
+		t.Errorf("Expected a MissingKeyError for DB_PORT, actual %v", err)
+	}
+
+	invalid := struct {
+		Port int `env:"-" template:"${DB_HOST}"`
+	}{}
+	if err := marsh.Unmarshal(&invalid); err == nil {
+		t.Error("Expecting an error from a template that cannot be parsed.")
+	}
+}
+
+func TestUnmarshalDecimal(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"PRICE":    "12.34",
+			"FEE":      " 0.5 ",
+			"DISCOUNT": "-3",
+			"RATE":     "0.0125",
+			"PRICES":   "1.5,2,.25",
+		}},
+	}
+
+	obj := struct {
+		Price    Decimal   `env:"PRICE,scale=2"`
+		Fee      *Decimal  `env:"FEE,scale=2"`
+		Discount Decimal   `env:"DISCOUNT,scale=2"`
+		Rate     Decimal   `env:"RATE"`
+		Prices   []Decimal `env:"PRICES,scale=2"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if obj.Price != (Decimal{Units: 1234, Scale: 2}) || obj.Fee == nil || *obj.Fee != (Decimal{Units: 50, Scale: 2}) ||
+		obj.Discount != (Decimal{Units: -300, Scale: 2}) || obj.Rate != (Decimal{Units: 125, Scale: 4}) {
+		t.Errorf("Unexpected decimals %+v", obj)
+	}
+	if !reflect.DeepEqual(obj.Prices, []Decimal{{150, 2}, {200, 2}, {25, 2}}) {
+		t.Errorf("Unexpected prices %v", obj.Prices)
+	}
+
+	vars, err := marsh.Marshal(&obj)
+	if err != nil {
+		t.Fatalf("Marshal should not raise error. Error: %s", err.Error())
+	}
+	if vars["PRICE"] != "12.34" || vars["FEE"] != "0.50" || vars["DISCOUNT"] != "-3.00" || vars["RATE"] != "0.0125" ||
+		vars["PRICES"] != "1.50,2.00,0.25" {
+		t.Errorf("Unexpected marshalled vars %v", vars)
+	}
+
+	cases := []struct {
+		Value    string
+		Expected string
+	}{
+		{"12.345", "more than 2 decimal places"},
+		{"12.3.4", "Cannot convert 12.3.4 to a decimal"},
+		{"1e3", "Cannot convert 1e3 to a decimal"},
+		{".", "Cannot convert . to a decimal"},
+		{"92233720368547758.08", "value out of range"},
+	}
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{Environment: &MockEnvReader{map[string]string{"PRICE": c.Value}}}
+		obj := struct {
+			Price Decimal `env:"PRICE,scale=2"`
+		}{}
+		err := marsh.Unmarshal(&obj)
+		if err == nil || !strings.Contains(err.Error(), c.Expected) {
+			t.Errorf("TC %d: Expected an error containing %q, actual %v", i, c.Expected, err)
+		}
+	}
+
+	badScale := struct {
+		Price Decimal `env:"PRICE,scale=19"`
+	}{}
+	if err := marsh.Unmarshal(&badScale); err == nil {
+		t.Error("Expecting an error from an invalid scale.")
+	}
+}
+
+func TestUnmarshalDependencyOrder(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"DB_HOST": "db.local",
+			"DB_NAME": "orders",
+		}},
+	}
+
+	obj := struct {
+		URL  string `env:"-" template:"${DSN}?sslmode=require"`
+		DSN  string `env:"DSN" template:"postgres://${DB_HOST}:${DB_PORT}/${DB_NAME}"`
+		Host string `env:"DB_HOST"`
+		Port int    `env:"DB_PORT" default:"5432"`
+		Name string `env:"DB_NAME"`
+	}{}
+	result, err := marsh.UnmarshalWithResult(&obj)
+	if err != nil {
+		t.Fatalf("UnmarshalWithResult should not raise error. Error: %s", err.Error())
+	}
+	if obj.DSN != "postgres://db.local:5432/orders" || obj.URL != "postgres://db.local:5432/orders?sslmode=require" {
+		t.Errorf("Unexpected config %+v", obj)
+	}
+	if !reflect.DeepEqual(result.Defaulted, []string{"Port"}) {
+		t.Errorf("Expected Port to be defaulted, actual %v", result.Defaulted)
+	}
+
+	cycle := struct {
+		A string `env:"A" template:"${B}"`
+		B string `env:"B" template:"${C}"`
+		C string `env:"C" template:"${A}"`
+		D string `env:"D" default:"d"`
+	}{}
+	err = marsh.Unmarshal(&cycle)
+	if err == nil || !strings.Contains(err.Error(), "cannot order fields A, B, C: their references form a cycle") {
+		t.Errorf("Expected an error from a cycle, actual %v", err)
+	}
+}
+
+func TestUnmarshalTrimNewline(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"TOKEN":    "s3cret\n",
+			"CRLF":     "s3cret\r\n",
+			"PASSWORD": " pass word \n",
+			"TRIMMED":  " pass word \n",
+		}},
+	}
+
+	obj := struct {
+		Token    string  `env:"TOKEN,trimnewline" secret:"true"`
+		CRLF     *string `env:"CRLF,trimnewline"`
+		Password string  `env:"PASSWORD,trimnewline"`
+		Trimmed  string  `env:"TRIMMED"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if obj.Token != "s3cret" || obj.CRLF == nil || *obj.CRLF != "s3cret" {
+		t.Errorf("Expected trailing newlines to be trimmed, actual %q %v", obj.Token, obj.CRLF)
+	}
+	if obj.Password != " pass word " {
+		t.Errorf("Expected spaces to be preserved, actual %q", obj.Password)
+	}
+	if obj.Trimmed != "pass word" {
+		t.Errorf("Expected surrounding whitespace to be trimmed, actual %q", obj.Trimmed)
+	}
+}
+
+func TestUnmarshalAtomic(t *testing.T) {
+	env := &MockEnvReader{map[string]string{
+		"MAX_CONNS": "10",
+		"ENABLED":   "true",
+		"MODE":      "fast",
+	}}
+	marsh := DefaultEnvMarshaler{Environment: env}
+
+	obj := struct {
+		MaxConns atomic.Int64  `env:"MAX_CONNS"`
+		Enabled  atomic.Bool   `env:"ENABLED"`
+		Mode     atomic.Value  `env:"MODE"`
+		Retries  atomic.Uint32 `env:"RETRIES" default:"3"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if obj.MaxConns.Load() != 10 || !obj.Enabled.Load() || obj.Mode.Load() != "fast" || obj.Retries.Load() != 3 {
+		t.Errorf("Unexpected values %d, %t, %v, %d",
+			obj.MaxConns.Load(), obj.Enabled.Load(), obj.Mode.Load(), obj.Retries.Load())
+	}
+
+	vars, err := marsh.Marshal(&obj)
+	if err != nil {
+		t.Fatalf("Marshal should not raise error. Error: %s", err.Error())
+	}
+	if vars["MAX_CONNS"] != "10" || vars["ENABLED"] != "true" || vars["MODE"] != "fast" || vars["RETRIES"] != "3" {
+		t.Errorf("Unexpected marshalled vars %v", vars)
+	}
+
+	// a reload stores the new values
+	env.EnvValues["MAX_CONNS"] = "20"
+	env.EnvValues["ENABLED"] = "false"
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if obj.MaxConns.Load() != 20 || obj.Enabled.Load() {
+		t.Errorf("Expected reloaded values 20 and false, actual %d and %t", obj.MaxConns.Load(), obj.Enabled.Load())
+	}
+
+	env.EnvValues["MAX_CONNS"] = "many"
+	err = marsh.Unmarshal(&obj)
+	if err == nil || !strings.Contains(err.Error(), "error unmarshaling field MaxConns") {
+		t.Errorf("Expected an error for an invalid value, actual %v", err)
+	}
+}
+
+func TestUnmarshalRawValues(t *testing.T) {
+	type Database struct {
+		Host     string            `env:"HOST"`
+		Port     int               `env:"PORT" default:"5432"`
+		Password string            `env:"PASSWORD" secret:"true"`
+		Raw      map[string]string `env:",raw"`
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"NAME":        "billing",
+			"BIND_HOST":   "0.0.0.0",
+			"DB_HOST":     "db.local",
+			"DB_PASSWORD": "hunter2",
+			"UNUSED":      "ignored",
+		}},
+	}
+	obj := struct {
+		Name     string            `env:"NAME"`
+		Host     string            `env:"HOST" defaultFrom:"BIND_HOST"`
+		Database Database          `env:"DB_"`
+		Raw      map[string]string `env:",raw"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	expected := map[string]string{
+		"NAME":        "billing",
+		"BIND_HOST":   "0.0.0.0",
+		"DB_HOST":     "db.local",
+		"DB_PASSWORD": RedactedValue,
+	}
+	if !reflect.DeepEqual(obj.Raw, expected) {
+		t.Errorf("Expected raw values %v, actual %v", expected, obj.Raw)
+	}
+	expected = map[string]string{"DB_HOST": "db.local", "DB_PASSWORD": RedactedValue}
+	if !reflect.DeepEqual(obj.Database.Raw, expected) {
+		t.Errorf("Expected raw database values %v, actual %v", expected, obj.Database.Raw)
+	}
+
+	vars, err := marsh.Marshal(&obj)
+	if err != nil {
+		t.Fatalf("Marshal should not raise error. Error: %s", err.Error())
+	}
+	if _, ok := vars[""]; ok || len(vars) != 5 {
+		t.Errorf("Expected the raw fields not to be marshalled, actual %v", vars)
+	}
+
+	invalid := []interface{}{
+		&struct {
+			Raw map[string]int `env:",raw"`
+		}{},
+		&struct {
+			Raw map[string]string `env:"RAW,raw"`
+		}{},
+	}
+	for i, obj := range invalid {
+		if err := marsh.Unmarshal(obj); err == nil {
+			t.Errorf("TC %d: Expecting an error from an invalid raw field.", i)
+		}
+	}
+}
+
+func TestUnmarshalAtomicReloadRace(t *testing.T) {
+	type Limits struct {
+		MaxConns atomic.Int64 `env:"MAX_CONNS"`
+	}
+	obj := struct {
+		Enabled atomic.Bool  `env:"ENABLED"`
+		Mode    atomic.Value `env:"MODE"`
+		Limits  Limits       `env:"LIMITS_"`
+	}{}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"ENABLED":          "true",
+			"MODE":             "fast",
+			"LIMITS_MAX_CONNS": "10",
+		}},
+	}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	done := make(chan struct{})
+	started := make(chan struct{})
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		close(started)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				obj.Enabled.Load()
+				obj.Mode.Load()
+				obj.Limits.MaxConns.Load()
+			}
+		}
+	}()
+
+	// reload while the values are read, which the race detector checks
+	<-started
+	for i := 0; i < 100; i++ {
+		if err := marsh.Unmarshal(&obj); err != nil {
+			t.Errorf("Unmarshal should not raise error. Error: %s", err.Error())
+			break
+		}
+	}
+	close(done)
+	<-read
+
+	if !obj.Enabled.Load() || obj.Mode.Load() != "fast" || obj.Limits.MaxConns.Load() != 10 {
+		t.Errorf("Unexpected values %t, %v, %d", obj.Enabled.Load(), obj.Mode.Load(), obj.Limits.MaxConns.Load())
+	}
+}
+
+func TestUnmarshalLazyFunc(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"PORT":    "8080",
+			"HOSTS":   "a.local,b.local",
+			"TIMEOUT": "soon",
+		}},
+	}
+
+	obj := struct {
+		Port    func() (int, error)           `env:"PORT"`
+		Hosts   func() ([]string, error)      `env:"HOSTS"`
+		Timeout func() (time.Duration, error) `env:"TIMEOUT"`
+		Retries func() (uint, error)          `env:"RETRIES" default:"3"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if port, err := obj.Port(); err != nil || port != 8080 {
+		t.Errorf("Expected port 8080, actual %d (Error: %v)", port, err)
+	}
+	if hosts, err := obj.Hosts(); err != nil || !reflect.DeepEqual(hosts, []string{"a.local", "b.local"}) {
+		t.Errorf("Expected hosts [a.local b.local], actual %v (Error: %v)", hosts, err)
+	}
+	if retries, err := obj.Retries(); err != nil || retries != 3 {
+		t.Errorf("Expected 3 retries, actual %d (Error: %v)", retries, err)
+	}
+	timeout, err := obj.Timeout()
+	if err == nil || timeout != 0 || !strings.Contains(err.Error(), "TIMEOUT") {
+		t.Errorf("Expected a parse error for TIMEOUT, actual %s (Error: %v)", timeout, err)
+	}
+
+	missing := struct {
+		Port func() (int, error) `env:"MISSING_PORT"`
+	}{}
+	if err := marsh.Unmarshal(&missing); err == nil {
+		t.Error("Expecting an error from a missing lazy value.")
+	}
+
+	unsupported := struct {
+		Port func() int `env:"PORT"`
+	}{}
+	if err := marsh.Unmarshal(&unsupported); err == nil {
+		t.Error("Expecting an error from a func that is not lazy.")
+	}
+}
+
+type Backend interface {
+	Name() string
+}
+
+type RedisBackendConfig struct {
+	Addr string `env:"ADDR"`
+	DB   int    `env:"DB" default:"0"`
+}
+
+func (c *RedisBackendConfig) Name() string { return "redis" }
+
+type MemcacheBackendConfig struct {
+	Servers []string `env:"SERVERS"`
+}
+
+func (c MemcacheBackendConfig) Name() string { return "memcache" }
+
+func TestUnmarshalInterface(t *testing.T) {
+	factories := map[string]map[string]func() interface{}{
+		"CACHE_BACKEND_TYPE": {
+			"redis":    func() interface{} { return &RedisBackendConfig{} },
+			"memcache": func() interface{} { return MemcacheBackendConfig{} },
+			"invalid":  func() interface{} { return 42 },
+			"nil":      func() interface{} { return nil },
+		},
+	}
+	type Config struct {
+		Backend Backend `env:"BACKEND_" typeFrom:"BACKEND_TYPE"`
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"CACHE_BACKEND_TYPE":       "redis",
+			"CACHE_BACKEND_ADDR":       "localhost:6379",
+			"MEMCACHE_BACKEND_TYPE":    "memcache",
+			"MEMCACHE_BACKEND_SERVERS": "a:11211,b:11211",
+			"MEMCACHE_BACKEND_ADDR":    "ignored",
+		}},
+		TypeFactories: factories,
+	}
+
+	redis := Config{}
+	if err := marsh.UnmarshalFrom("CACHE_", &redis); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	expectedRedis := &RedisBackendConfig{Addr: "localhost:6379", DB: 0}
+	if !reflect.DeepEqual(redis.Backend, expectedRedis) {
+		t.Errorf("Expected backend %+v, actual %+v", expectedRedis, redis.Backend)
+	}
+
+	marsh.TypeFactories = map[string]map[string]func() interface{}{
+		"MEMCACHE_BACKEND_TYPE": factories["CACHE_BACKEND_TYPE"],
+	}
+	memcache := Config{}
+	if err := marsh.UnmarshalFrom("MEMCACHE_", &memcache); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	expectedMemcache := MemcacheBackendConfig{Servers: []string{"a:11211", "b:11211"}}
+	if !reflect.DeepEqual(memcache.Backend, expectedMemcache) {
+		t.Errorf("Expected backend %+v, actual %+v", expectedMemcache, memcache.Backend)
+	}
+
+	cases := []map[string]string{
+		{"CACHE_BACKEND_ADDR": "localhost:6379"},
+		{"CACHE_BACKEND_TYPE": "etcd"},
+		{"CACHE_BACKEND_TYPE": "invalid"},
+	}
+	for i, env := range cases {
+		marsh := DefaultEnvMarshaler{
+			Environment:   &MockEnvReader{env},
+			TypeFactories: factories,
+		}
+		if err := marsh.UnmarshalFrom("CACHE_", &Config{}); err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+		}
+	}
+
+	marsh = DefaultEnvMarshaler{
+		Environment:   &MockEnvReader{map[string]string{"CACHE_BACKEND_TYPE": "nil"}},
+		TypeFactories: factories,
+	}
+	err := marsh.UnmarshalFrom("CACHE_", &Config{})
+	if err == nil || !strings.Contains(err.Error(), "factory for nil returned nil (Env: CACHE_BACKEND_TYPE)") {
+		t.Errorf("Expected an error for a factory returning nil, actual %v", err)
+	}
+}
+
+func TestUnmarshalEmptyAggregates(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"LABELS": "",
+			"HOSTS":  "",
+			"ROLES":  "",
+			"FULL":   "a=1",
+		}},
+	}
+
+	empty := struct {
+		Labels map[string]string `env:"LABELS"`
+		Hosts  []string          `env:"HOSTS"`
+		Roles  StringSet         `env:"ROLES"`
+	}{}
+	if err := marsh.Unmarshal(&empty); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if empty.Labels == nil || len(empty.Labels) != 0 || empty.Hosts == nil || len(empty.Hosts) != 0 ||
+		empty.Roles == nil || len(empty.Roles) != 0 {
+		t.Errorf("Expected empty, non-nil aggregates, actual %#v", empty)
+	}
+
+	nilEmpty := struct {
+		Labels map[string]string `env:"LABELS,nilempty"`
+		Hosts  []string          `env:"HOSTS,nilempty"`
+		Roles  StringSet         `env:"ROLES,nilempty"`
+		Full   map[string]int    `env:"FULL,nilempty"`
+	}{}
+	if err := marsh.Unmarshal(&nilEmpty); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if nilEmpty.Labels != nil || nilEmpty.Hosts != nil || nilEmpty.Roles != nil {
+		t.Errorf("Expected nil aggregates, actual %#v", nilEmpty)
+	}
+	if !reflect.DeepEqual(nilEmpty.Full, map[string]int{"a": 1}) {
+		t.Errorf("Expected map[a:1], actual %v", nilEmpty.Full)
+	}
+}
+
+type DatabaseConfig struct {
+	Host string `env:"HOST"`
+	Port int    `env:"PORT"`
+}
+
+type HTTPServerConfig struct {
+	Addr string `env:"ADDR"`
+}
+
+func TestUnmarshalTypeNamePrefixes(t *testing.T) {
+	type Config struct {
+		Database DatabaseConfig
+		Server   *HTTPServerConfig
+		Replica  DatabaseConfig `env:"REPLICA_"`
+		Ignored  string
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"DATABASE_CONFIG_HOST":    "db.local",
+			"DATABASE_CONFIG_PORT":    "5432",
+			"HTTP_SERVER_CONFIG_ADDR": ":8080",
+			"REPLICA_HOST":            "replica.local",
+			"REPLICA_PORT":            "5433",
+			"IGNORED":                 "ignored",
+		}},
+		TypeNamePrefixes: true,
+	}
+
+	config := Config{}
+	if err := marsh.Unmarshal(&config); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if config.Database.Host != "db.local" || config.Database.Port != 5432 || config.Server == nil ||
+		config.Server.Addr != ":8080" || config.Replica.Host != "replica.local" || config.Ignored != "" {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	key, err := marsh.KeyFor(&config, "Server.Addr")
+	if err != nil || key != "HTTP_SERVER_CONFIG_ADDR" {
+		t.Errorf("Expected key HTTP_SERVER_CONFIG_ADDR, actual %s (Error: %v)", key, err)
+	}
+
+	untagged := Config{}
+	marsh.TypeNamePrefixes = false
+	if err := marsh.Unmarshal(&untagged); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if untagged.Database.Host != "" || untagged.Server != nil || untagged.Replica.Host != "replica.local" {
+		t.Errorf("Expected untagged structs to be skipped, actual %+v", untagged)
+	}
+}
+
+func TestUpperSnakeCase(t *testing.T) {
+	cases := map[string]string{
+		"DatabaseConfig":   "DATABASE_CONFIG",
+		"HTTPServerConfig": "HTTP_SERVER_CONFIG",
+		"DBConfig":         "DB_CONFIG",
+		"S3Bucket":         "S3_BUCKET",
+		"Config2":          "CONFIG2",
+		"config":           "CONFIG",
+	}
+	for name, expected := range cases {
+		if actual := upperSnakeCase(name); actual != expected {
+			t.Errorf("Expected %s for %s, actual %s", expected, name, actual)
+		}
+	}
+}
+
+func TestUnmarshalWithResultWarnings(t *testing.T) {
+	logger := &LoggerMock{}
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"OLD_HOST": "db.local",
+			"TTL":      "9999999999h",
+		}},
+		Logger: logger,
+	}
+
+	obj := struct {
+		Host string        `env:"OLD_HOST,deprecated"`
+		Port int           `env:"PORT" default:"5432"`
+		TTL  time.Duration `env:"TTL,clamp"`
+	}{}
+	result, err := marsh.UnmarshalWithResult(&obj)
+	if err != nil {
+		t.Fatalf("UnmarshalWithResult should not raise error. Error: %s", err.Error())
+	}
+
+	expected := []Warning{
+		{WarningDeprecated, "OLD_HOST", "environment var OLD_HOST of field Host is deprecated"},
+		{WarningDefaulted, "PORT", "field Port fell back to its default"},
+		{WarningClamped, "TTL", "duration 9999999999h of field TTL was clamped"},
+	}
+	if !reflect.DeepEqual(result.Warnings, expected) {
+		t.Errorf("Expected warnings %+v, actual %+v", expected, result.Warnings)
+	}
+
+	// only the warnings that were already logged are logged
+	if len(logger.Messages) != 1 || logger.Messages[0] != expected[0].Message {
+		t.Errorf("Unexpected logged messages %v", logger.Messages)
+	}
+}
+
+func TestUnmarshalWithResultSources(t *testing.T) {
+	type Config struct {
+		Host     string  `env:"HOST" defaultProd:"prod.local" default:"localhost"`
+		Replica  string  `env:"REPLICA" defaultFrom:"PRIMARY" defaultProd:"prod.local"`
+		Timeout  string  `env:"TIMEOUT" defaultProd:"5s" default:"30s"`
+		Retries  int     `env:"RETRIES" defaultStaging:"5" default:"3"`
+		Proxy    *string `env:"PROXY" default:""`
+		Token    *string `env:"TOKEN"`
+		Endpoint string  `env:"-" template:"${HOST}:${RETRIES}"`
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"HOST":    "env.local",
+			"PRIMARY": "primary.local",
+		}},
+		EnvironmentName: "prod",
+	}
+	config := Config{}
+	result, err := marsh.UnmarshalWithResult(&config)
+	if err != nil {
+		t.Fatalf("UnmarshalWithResult should not raise error. Error: %s", err.Error())
+	}
+	if config.Host != "env.local" || config.Replica != "primary.local" || config.Timeout != "5s" ||
+		config.Retries != 3 || config.Proxy == nil || config.Token != nil || config.Endpoint != "env.local:3" {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	expected := map[string]ValueSource{
+		"Host":     SourceEnv,
+		"Replica":  SourceDefaultFrom,
+		"Timeout":  SourceEnvironmentDefault,
+		"Retries":  SourceDefault,
+		"Proxy":    SourceDefault,
+		"Token":    SourceZero,
+		"Endpoint": SourceTemplate,
+	}
+	if !reflect.DeepEqual(result.Sources, expected) {
+		t.Errorf("Expected sources %v, actual %v", expected, result.Sources)
+	}
+}
+
+func TestUnmarshalWithSchema(t *testing.T) {
+	type Config struct {
+		Region string   `env:"REGION"`
+		Port   int      `env:"DB_PORT" default:"5432"`
+		Hosts  []string `env:"HOSTS"`
+		Token  string   `env:"TOKEN" default:"dev"`
+	}
+
+	region := "us-east-1"
+	required := true
+	schema := map[string]FieldSpec{
+		"region":  {Default: &region},
+		"DB_PORT": {Min: "1024"},
+		"HOSTS":   {Separator: ";"},
+		"TOKEN":   {Required: &required},
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"HOSTS": "a.local;b.local",
+			"TOKEN": "s3cret",
+		}},
+		KeyNormalizer: NormalizeUpperSnake,
+	}
+	config := Config{}
+	if err := marsh.UnmarshalWithSchema(&config, schema); err != nil {
+		t.Fatalf("UnmarshalWithSchema should not raise error. Error: %s", err.Error())
+	}
+	expected := Config{
+		Region: "us-east-1",
+		Port:   5432,
+		Hosts:  []string{"a.local", "b.local"},
+		Token:  "s3cret",
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Expected %+v, actual %+v", expected, config)
+	}
+	if defaulted := marsh.DefaultedFields(); !reflect.DeepEqual(defaulted, []string{"Region", "Port"}) {
+		t.Errorf("Expected defaulted fields [Region Port], actual %v", defaulted)
+	}
+
+	cases := []map[string]string{
+		{"HOSTS": "a.local"},
+		{"TOKEN": "s3cret", "DB_PORT": "80"},
+	}
+	for i, env := range cases {
+		marsh := DefaultEnvMarshaler{
+			Environment:   &MockEnvReader{env},
+			KeyNormalizer: NormalizeUpperSnake,
+		}
+		if err := marsh.UnmarshalWithSchema(&Config{}, schema); err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+		}
+	}
+
+	// without the schema, the tags alone apply
+	if err := marsh.Unmarshal(&Config{}); err == nil {
+		t.Error("Expecting an error from the missing REGION without the schema.")
+	}
+
+	// a default of the schema replaces those for the EnvironmentName, along with their source
+	type EnvironmentConfig struct {
+		Region string `env:"AWS_REGION" defaultProd:"us-west-2" default:"eu-west-1"`
+	}
+	region = "us-east-1"
+	marsh = DefaultEnvMarshaler{
+		Environment:     &MockEnvReader{map[string]string{}},
+		EnvironmentName: "prod",
+		schema:          map[string]FieldSpec{"AWS_REGION": {Default: &region}},
+	}
+	envConfig := EnvironmentConfig{}
+	result, err := marsh.UnmarshalWithResult(&envConfig)
+	if err != nil {
+		t.Fatalf("UnmarshalWithResult should not raise error. Error: %s", err.Error())
+	}
+	if envConfig.Region != "us-east-1" || result.Sources["Region"] != SourceDefault {
+		t.Errorf("Expected the region us-east-1 from %s, actual %s from %s",
+			SourceDefault, envConfig.Region, result.Sources["Region"])
+	}
+}
+
+func TestUnmarshalWithOverrides(t *testing.T) {
+	type Config struct {
+		Host    string   `env:"DB_HOST"`
+		Port    int      `env:"DB_PORT" default:"5432"`
+		User    string   `env:"DB_USER"`
+		Origins []string `env:"ORIGIN_,collect"`
+	}
+
+	env := &MockEnvReader{map[string]string{
+		"DB_HOST":  "db.prod",
+		"DB_USER":  "app",
+		"ORIGIN_1": "a.example.com",
+	}}
+	marsh := DefaultEnvMarshaler{Environment: env, KeyNormalizer: strings.ToUpper}
+
+	obj := Config{}
+	err := marsh.UnmarshalWithOverrides(&obj, map[string]string{
+		"db_host":  "localhost",
+		"DB_PORT":  "15432",
+		"ORIGIN_2": "b.example.com",
+	})
+	if err != nil {
+		t.Fatalf("UnmarshalWithOverrides should not raise error. Error: %s", err.Error())
+	}
+
+	expected := Config{
+		Host:    "localhost",
+		Port:    15432,
+		User:    "app",
+		Origins: []string{"a.example.com", "b.example.com"},
+	}
+	if !reflect.DeepEqual(obj, expected) {
+		t.Errorf("Expected %+v, actual %+v", expected, obj)
+	}
+	if marsh.Environment != env {
+		t.Error("Expected the Environment to be left untouched.")
+	}
+
+	if err := marsh.UnmarshalWithOverrides(&Config{}, map[string]string{"DB_PORT": "x"}); err == nil {
+		t.Error("Expecting an error from an invalid override.")
+	}
+}
+
+func TestUnmarshalWithOverridesLookups(t *testing.T) {
+	type Config struct {
+		Host string `env:"DB_HOST"`
+		Port int    `env:"DB_PORT"`
+	}
+
+	// the overrides leave the batch lookups of the environment in place
+	batchEnv := &BatchEnvReaderMock{
+		MockEnvReader: MockEnvReader{map[string]string{"DB_HOST": "db.prod", "DB_PORT": "5432"}},
+	}
+	marsh := DefaultEnvMarshaler{Environment: batchEnv}
+	obj := Config{}
+	if err := marsh.UnmarshalWithOverrides(&obj, map[string]string{"DB_PORT": "15432"}); err != nil {
+		t.Fatalf("UnmarshalWithOverrides should not raise error. Error: %s", err.Error())
+	}
+	if obj.Host != "db.prod" || obj.Port != 15432 {
+		t.Errorf("Unexpected config %+v", obj)
+	}
+	if len(batchEnv.Batches) != 1 || len(batchEnv.Lookups) != 0 {
+		t.Errorf("Expected a single batch and no lookups, actual %v and %v", batchEnv.Batches, batchEnv.Lookups)
+	}
+
+	// the overrides match keys regardless of case if the environment does
+	environ := []string{"DB_HOST=db.prod", "DB_PORT=5432"}
+	marsh = DefaultEnvMarshaler{
+		Environment: &OsEnvReader{
+			lookup:          func(string) (string, bool) { return "", false },
+			environ:         func() []string { return environ },
+			caseInsensitive: true,
+		},
+		KeyNormalizer: func(key string) string { return key },
+	}
+	obj = Config{}
+	if err := marsh.UnmarshalWithOverrides(&obj, map[string]string{"db_port": "15432"}); err != nil {
+		t.Fatalf("UnmarshalWithOverrides should not raise error. Error: %s", err.Error())
+	}
+	if obj.Host != "db.prod" || obj.Port != 15432 {
+		t.Errorf("Unexpected config %+v", obj)
+	}
+
+	// concurrent unmarshals of a shared marshaler see only their own overrides
+	marsh = DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{"DB_HOST": "db.prod", "DB_PORT": "5432"}},
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func(port int) {
+			defer wg.Done()
+			obj := Config{}
+			if err := marsh.UnmarshalWithOverrides(&obj, map[string]string{"DB_PORT": strconv.Itoa(port)}); err != nil {
+				errs <- err
+			} else if obj.Port != port {
+				errs <- fmt.Errorf("expected the overridden port %d, actual %d", port, obj.Port)
+			}
+		}(10000 + i)
+		go func() {
+			defer wg.Done()
+			obj := Config{}
+			if _, err := marsh.UnmarshalWithResult(&obj); err != nil {
+				errs <- err
+			} else if obj.Port != 5432 {
+				errs <- fmt.Errorf("expected the port 5432, actual %d", obj.Port)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestUnmarshalOptionalPointers(t *testing.T) {
+	type Config struct {
+		Absent   *bool          `env:"ABSENT"`
+		Enabled  *bool          `env:"ENABLED"`
+		Disabled *bool          `env:"DISABLED"`
+		Port     *int           `env:"PORT"`
+		Hosts    *[]string      `env:"HOSTS"`
+		Timeout  *time.Duration `env:"TIMEOUT" default:"5s"`
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"ENABLED":  "true",
+			"DISABLED": "false",
+		}},
+	}
+	config := Config{}
+	if err := marsh.Unmarshal(&config); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if config.Absent != nil || config.Port != nil || config.Hosts != nil {
+		t.Errorf("Expected absent pointers to be nil, actual %+v", config)
+	}
+	if config.Enabled == nil || !*config.Enabled || config.Disabled == nil || *config.Disabled {
+		t.Errorf("Expected explicit true and false, actual %v %v", config.Enabled, config.Disabled)
+	}
+	if config.Timeout == nil || *config.Timeout != 5*time.Second {
+		t.Errorf("Expected a defaulted timeout of 5s, actual %v", config.Timeout)
+	}
+
+	required := struct {
+		Absent *bool `env:"ABSENT,required"`
+	}{}
+	if err := marsh.Unmarshal(&required); err == nil {
+		t.Error("Expecting an error from a missing required pointer.")
+	}
+}
+
+func TestUnmarshalDeprecated(t *testing.T) {
+	type Config struct {
+		OldOption int    `env:"OLD_OPTION,deprecated" default:"1"`
+		Legacy    string `env:"LEGACY,deprecated" defaultFrom:"MODERN"`
+		Verbose   bool   `env:"VERBOSE,presence,deprecated"`
+	}
+
+	logger := &LoggerMock{}
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{"MODERN": "new"}},
+		Logger:      logger,
+	}
+	config := Config{}
+	if err := marsh.Unmarshal(&config); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if len(logger.Messages) != 0 {
+		t.Errorf("Expected no warnings for unset variables, actual %v", logger.Messages)
+	}
+
+	marsh.Environment = &MockEnvReader{map[string]string{
+		"OLD_OPTION": "5",
+		"LEGACY":     "old",
+		"VERBOSE":    "",
+	}}
+	if err := marsh.Unmarshal(&config); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if config.OldOption != 5 || config.Legacy != "old" || !config.Verbose {
+		t.Errorf("Expected deprecated variables to be parsed, actual %+v", config)
+	}
+
+	expected := []string{
+		"environment var OLD_OPTION of field OldOption is deprecated",
+		"environment var LEGACY of field Legacy is deprecated",
+		"environment var VERBOSE of field Verbose is deprecated",
+	}
+	if !reflect.DeepEqual(logger.Messages, expected) {
+		t.Errorf("Expected warnings %v, actual %v", expected, logger.Messages)
+	}
+}
+
+type ServerConfig struct {
+	Host  string   `env:"HOST"`
+	Port  int      `env:"PORT" default:"80"`
+	Alias []string `env:"ALIAS"`
+}
+
+func TestUnmarshalStructSlices(t *testing.T) {
+	type Config struct {
+		Servers  *[]*ServerConfig `env:"SERVER_"`
+		Backends []ServerConfig   `env:"BACKEND_,gaps=skip"`
+		Absent   *[]*ServerConfig `env:"ABSENT_"`
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"SERVER_0_HOST":    "a.local",
+			"SERVER_0_PORT":    "8080",
+			"SERVER_0_ALIAS":   "a,alpha",
+			"SERVER_1_HOST":    "b.local",
+			"SERVER_1_ALIAS":   "",
+			"SERVER_COUNT":     "2",
+			"BACKEND_10_HOST":  "ten.local",
+			"BACKEND_10_ALIAS": "ten",
+			"BACKEND_2_HOST":   "two.local",
+			"BACKEND_2_ALIAS":  "two",
+		}},
+	}
+
+	config := Config{}
+	if err := marsh.Unmarshal(&config); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+
+	if config.Servers == nil || len(*config.Servers) != 2 {
+		t.Fatalf("Expected two servers, actual %v", config.Servers)
+	}
+	for i, server := range *config.Servers {
+		if server == nil {
+			t.Fatalf("Expected server %d to be non-nil", i)
+		}
+	}
+	expected := []ServerConfig{
+		{Host: "a.local", Port: 8080, Alias: []string{"a", "alpha"}},
+		{Host: "b.local", Port: 80, Alias: []string{}},
+	}
+	for i, server := range *config.Servers {
+		if !reflect.DeepEqual(*server, expected[i]) {
+			t.Errorf("Expected server %d to be %+v, actual %+v", i, expected[i], *server)
+		}
+	}
+
+	if len(config.Backends) != 2 || config.Backends[0].Host != "two.local" || config.Backends[1].Host != "ten.local" {
+		t.Errorf("Expected backends in the order of their indices, actual %+v", config.Backends)
+	}
+	if config.Absent != nil {
+		t.Errorf("Expected absent servers to be nil, actual %v", *config.Absent)
+	}
+
+	vars, err := marsh.Marshal(&config)
+	if err != nil {
+		t.Fatalf("Marshal should not raise error. Error: %s", err.Error())
+	}
+	if vars["SERVER_1_HOST"] != "b.local" || vars["SERVER_0_ALIAS"] != "a,alpha" || vars["BACKEND_1_HOST"] != "ten.local" {
+		t.Errorf("Unexpected marshalled vars %v", vars)
+	}
+
+	lookupOnly := DefaultEnvMarshaler{Environment: &lookupOnlyEnvReader{marsh.Environment}}
+	if err := lookupOnly.Unmarshal(&Config{}); err == nil {
+		t.Error("Expecting an error from an environment that cannot enumerate its keys.")
+	}
+
+	// indices are read as written, so that zero-padded ones are rejected rather than read
+	// from the keys of other indices
+	for i, key := range []string{"SERVER_01_HOST", "SERVER_+1_HOST"} {
+		padded := DefaultEnvMarshaler{
+			Environment: &MockEnvReader{map[string]string{"SERVER_0_HOST": "a.local", key: "b.local"}},
+		}
+		err := padded.Unmarshal(&Config{})
+		if err == nil || !strings.Contains(err.Error(), "of "+key+" is not canonical, expected 1") {
+			t.Errorf("TC %d: Expected an error for the index of %s, actual %v", i, key, err)
+		}
+	}
+}
+
+type recursiveNode struct {
+	Name *string        `env:"NAME"`
+	Next *recursiveNode `env:"NEXT_"`
+}
+
+func TestUnmarshalMaxDepth(t *testing.T) {
+	type Level3 struct {
+		Name string `env:"NAME"`
+	}
+	type Level2 struct {
+		Inner Level3 `env:"C_"`
+	}
+	type Level1 struct {
+		Inner Level2 `env:"B_"`
+	}
+	type Root struct {
+		Inner Level1 `env:"A_"`
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{"A_B_C_NAME": "leaf"}},
+		MaxDepth:    4,
+	}
+	root := Root{}
+	if err := marsh.Unmarshal(&root); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if root.Inner.Inner.Inner.Name != "leaf" {
+		t.Errorf("Expected the leaf to be unmarshalled, actual %+v", root)
+	}
+
+	marsh.MaxDepth = 3
+	err := marsh.Unmarshal(&Root{})
+	if err == nil || !strings.Contains(err.Error(), "cannot unmarshal field Inner.Inner.Inner: structs are nested more than 3 deep") {
+		t.Errorf("Expected an error for structs nested too deep, actual %v", err)
+	}
+
+	marsh = DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{"NAME": "head"}},
+	}
+	err = marsh.Unmarshal(&recursiveNode{})
+	if err == nil || !strings.Contains(err.Error(), "structs are nested more than 32 deep") {
+		t.Errorf("Expected an error for a self-referential struct, actual %v", err)
+	}
+}
+
+func TestUnmarshalStructSliceGaps(t *testing.T) {
+	type Server struct {
+		Host string `env:"HOST"`
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"SERVER_0_HOST":  "a.local",
+			"SERVER_1_HOST":  "b.local",
+			"BACKEND_0_HOST": "a.local",
+			"BACKEND_2_HOST": "c.local",
+		}},
+	}
+
+	contiguous := struct {
+		Servers []Server `env:"SERVER_"`
+	}{}
+	if err := marsh.Unmarshal(&contiguous); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if len(contiguous.Servers) != 2 || contiguous.Servers[1].Host != "b.local" {
+		t.Errorf("Expected two servers, actual %+v", contiguous.Servers)
+	}
+
+	strict := struct {
+		Backends []Server `env:"BACKEND_,gaps=strict"`
+	}{}
+	err := marsh.Unmarshal(&strict)
+	if err == nil || !strings.Contains(err.Error(), "cannot unmarshal BACKEND_: element 1 is missing") {
+		t.Errorf("Expected an error for the missing element 1, actual %v", err)
+	}
+
+	skip := struct {
+		Backends []Server `env:"BACKEND_,gaps=skip"`
+	}{}
+	if err := marsh.Unmarshal(&skip); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if len(skip.Backends) != 2 || skip.Backends[0].Host != "a.local" || skip.Backends[1].Host != "c.local" {
+		t.Errorf("Expected the backends to be compacted, actual %+v", skip.Backends)
+	}
+
+	invalid := struct {
+		Backends []Server `env:"BACKEND_,gaps=fill"`
+	}{}
+	if err := marsh.Unmarshal(&invalid); err == nil {
+		t.Error("Expecting an error from an invalid gaps option.")
+	}
+
+	// an element given by differently written indices is reported rather than dropped
+	duplicates := []interface{}{
+		&struct {
+			Backends []Server `env:"BACKEND_"`
+		}{},
+		&struct {
+			Backends []Server `env:"BACKEND_,gaps=skip"`
+		}{},
+	}
+	marsh.Environment = &MockEnvReader{map[string]string{
+		"BACKEND_0_HOST":  "a.local",
+		"BACKEND_00_HOST": "b.local",
+		"BACKEND_1_HOST":  "c.local",
+	}}
+	for i, obj := range duplicates {
+		err := marsh.Unmarshal(obj)
+		expected := "element 0 is given by more than one index, in BACKEND_0_HOST and BACKEND_00_HOST"
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("TC %d: Expected an error for the duplicate element 0, actual %v", i, err)
+		}
+	}
+}
+
+func TestUnmarshalStrictIntegers(t *testing.T) {
+	type Config struct {
+		Port   uint16 `env:"PORT,strict"`
+		Offset int    `env:"OFFSET,strict"`
+		Loose  int    `env:"LOOSE"`
+	}
+
+	cases := []struct {
+		Port     string
+		Offset   string
+		Expected Config
+	}{
+		{"80", "-5", Config{Port: 80, Offset: -5, Loose: 8}},
+		{"0", "0", Config{Port: 0, Offset: 0, Loose: 8}},
+		{"8080", "-0", Config{Port: 8080, Offset: 0, Loose: 8}},
+	}
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{
+			Environment: &MockEnvReader{map[string]string{
+				"PORT":   c.Port,
+				"OFFSET": c.Offset,
+				"LOOSE":  "008",
+			}},
+		}
+		config := Config{}
+		if err := marsh.Unmarshal(&config); err != nil {
+			t.Errorf("TC %d: Unmarshal should not raise error. Error: %s", i, err.Error())
+		}
+		if config != c.Expected {
+			t.Errorf("TC %d: Expected %+v, actual %+v", i, c.Expected, config)
+		}
+	}
+
+	failCases := []map[string]string{
+		{"PORT": "0080", "OFFSET": "1", "LOOSE": "1"},
+		{"PORT": "80", "OFFSET": "-007", "LOOSE": "1"},
+		{"PORT": "00", "OFFSET": "1", "LOOSE": "1"},
+	}
+	for i, env := range failCases {
+		marsh := DefaultEnvMarshaler{Environment: &MockEnvReader{env}}
+		err := marsh.Unmarshal(&Config{})
+		if err == nil || !strings.Contains(err.Error(), "leading zeros") {
+			t.Errorf("TC %d: Expected a leading zeros error, actual %v", i, err)
+		}
+	}
+}
+
+func TestUnmarshalUseFieldNames(t *testing.T) {
+	type Config struct {
+		MaxConns  int
+		HTTPPort  uint16 `default:"8080"`
+		Hosts     []string
+		Database  DatabaseConfig
+		Servers   []ServerConfig
+		Tagged    string `env:"CUSTOM"`
+		unexposed string
+		Callback  func()
+	}
+
+	env := &MockEnvReader{map[string]string{
+		"MAX_CONNS":            "10",
+		"HOSTS":                "a.local,b.local",
+		"DATABASE_HOST":        "db.local",
+		"DATABASE_PORT":        "5432",
+		"SERVERS_0_HOST":       "a.local",
+		"SERVERS_0_ALIAS":      "a",
+		"CUSTOM":               "custom",
+		"DATABASE_CONFIG_HOST": "typed.local",
+		"DATABASE_CONFIG_PORT": "5433",
+	}}
+	marsh := DefaultEnvMarshaler{Environment: env, UseFieldNames: true}
+
+	config := Config{}
+	if err := marsh.Unmarshal(&config); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if config.MaxConns != 10 || config.HTTPPort != 8080 || len(config.Hosts) != 2 ||
+		config.Database.Host != "db.local" || config.Database.Port != 5432 || len(config.Servers) != 1 ||
+		config.Servers[0].Host != "a.local" || config.Tagged != "custom" || config.unexposed != "" {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	key, err := marsh.KeyFor(&config, "Database.Host")
+	if err != nil || key != "DATABASE_HOST" {
+		t.Errorf("Expected key DATABASE_HOST, actual %s (Error: %v)", key, err)
+	}
+
+	marsh.TypeNamePrefixes = true
+	typed := Config{}
+	if err := marsh.Unmarshal(&typed); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if typed.Database.Host != "typed.local" || typed.MaxConns != 10 {
+		t.Errorf("Expected type name prefixes to take precedence, actual %+v", typed)
+	}
+
+	skipped := Config{}
+	if err := (&DefaultEnvMarshaler{Environment: env}).Unmarshal(&skipped); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if skipped.MaxConns != 0 || skipped.Database.Host != "" || skipped.Tagged != "custom" {
+		t.Errorf("Expected untagged fields to be skipped by default, actual %+v", skipped)
+	}
+}
+
+func TestUnmarshalErrMsg(t *testing.T) {
+	type Config struct {
+		APIKey string `env:"API_KEY,required" errmsg:"Set API_KEY to your provisioned key from the dashboard."`
+		Port   int    `env:"PORT" default:"8080" errmsg:"PORT must be a port number."`
+		Token  string `env:"TOKEN" secret:"true" errmsg:"Set TOKEN."`
+	}
+
+	cases := []struct {
+		Env     map[string]string
+		Message string
+		Cause   string
+	}{
+		{
+			map[string]string{"TOKEN": "t"},
+			"Set API_KEY to your provisioned key from the dashboard.",
+			"cannot retrieve any value from environment var API_KEY",
+		},
+		{
+			map[string]string{"API_KEY": "key", "PORT": "http", "TOKEN": "t"},
+			"PORT must be a port number.",
+			"Cannot convert http to int",
+		},
+		{
+			map[string]string{"API_KEY": "key"},
+			"Set TOKEN.",
+			"TOKEN",
+		},
+	}
+	for i, c := range cases {
+		marsh := DefaultEnvMarshaler{Environment: &MockEnvReader{c.Env}}
+		err := marsh.Unmarshal(&Config{})
+		if err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), c.Message+": ") || !strings.Contains(err.Error(), c.Cause) {
+			t.Errorf("TC %d: Expected %q followed by %q, actual %s", i, c.Message, c.Cause, err.Error())
+		}
+	}
+}
+
+func TestUnmarshalUnsupportedNesting(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{
+			"CLUSTERS":             "east=a",
+			"GRID":                 "a",
+			"REGIONS_US_EAST_HOST": "a.local",
+		}},
+	}
+
+	cases := []struct {
+		Obj      interface{}
+		Expected string
+	}{
+		{
+			&struct {
+				Clusters map[string][]ServerConfig `env:"CLUSTERS"`
+			}{},
+			"unsupported nested type map[string][]goenv.ServerConfig at field Clusters",
+		},
+		{
+			&struct {
+				Grid *[][]*ServerConfig `env:"GRID"`
+			}{},
+			"unsupported nested type [][]*goenv.ServerConfig at field Grid",
+		},
+		{
+			&struct {
+				Regions map[string]map[string]ServerConfig `env:"REGIONS_"`
+			}{},
+			"unsupported nested type map[string]map[string]goenv.ServerConfig at field Regions",
+		},
+	}
+	for i, c := range cases {
+		err := marsh.Unmarshal(c.Obj)
+		if err == nil || !strings.Contains(err.Error(), c.Expected) {
+			t.Errorf("TC %d: Expected an error containing %q, actual %v", i, c.Expected, err)
+		}
+	}
+
+	supported := struct {
+		Grid    [][]int               `env:"GRID"`
+		Ordered map[string]OrderedMap `env:"CLUSTERS"`
+	}{}
+	marsh.Environment = &MockEnvReader{map[string]string{
+		"GRID":     "1",
+		"CLUSTERS": "east=a=1",
+	}}
+	if err := marsh.Unmarshal(&supported); err != nil {
+		t.Errorf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+}
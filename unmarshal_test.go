@@ -1,8 +1,18 @@
 package goenv
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"net/netip"
+	"net/url"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -102,6 +112,46 @@ func TestUnmarshalBoolFail(t *testing.T) {
 	}
 }
 
+func TestUnmarshalBoolWords(t *testing.T) {
+	registered := boolWords.values
+	boolWords.values = map[string]bool{}
+	defer func() {
+		boolWords.values = registered
+	}()
+
+	RegisterBoolWords(FrenchBoolWords.True, FrenchBoolWords.False)
+	RegisterBoolWords(GermanBoolWords.True, GermanBoolWords.False)
+
+	marshaler := DefaultParser{}
+	cases := []struct {
+		StrVal   string
+		Expected bool
+	}{
+		{"oui", true},
+		{"Non", false},
+		{"VRAI", true},
+		{"faux", false},
+		{"ja", true},
+		{"Nein", false},
+		{"wahr", true},
+		{"falsch", false},
+		{"true", true},
+	}
+	for i, c := range cases {
+		var b bool
+		if err := marshaler.Unmarshal(c.StrVal, &b); err != nil || b != c.Expected {
+			t.Errorf("TC %d: Expected %s to be %t, actual %t (Error: %v)", i, c.StrVal, c.Expected, b, err)
+		}
+	}
+
+	var b bool
+	err := marshaler.Unmarshal("si", &b)
+	var numErr *strconv.NumError
+	if err == nil || !errors.As(err, &numErr) {
+		t.Errorf("Expected an unregistered word to fail with a *strconv.NumError, actual %v", err)
+	}
+}
+
 func TestUnmarshalUint8(t *testing.T) {
 	marshaler := &DefaultParser{}
 
@@ -584,6 +634,8 @@ func TestUnmarshalFloat(t *testing.T) {
 		{"-4.0", -4.0},
 		{"-2.56", -2.56},
 		{"-922.3372036854775808", -922.3372036854775808},
+		{"1e10", 1e10},
+		{"-2.5E-3", -0.0025},
 	}
 
 	for _, c := range cases {
@@ -832,3 +884,962 @@ func TestParseTypeFail(t *testing.T) {
 		t.Error("We expect parse to fail for incorrect pointer.")
 	}
 }
+
+func TestUnmarshalSliceCustomSeparator(t *testing.T) {
+	marshaler := &DefaultParser{SliceSeparator: ":"}
+	cases := []struct {
+		StrVal   string
+		Expected []string
+	}{
+		{"/usr/bin:/bin", []string{"/usr/bin", "/bin"}},
+		{"/usr/bin", []string{"/usr/bin"}},
+		{"a,b:c", []string{"a,b", "c"}},
+		{"", []string{}},
+	}
+
+	for _, c := range cases {
+		var v []string
+		err := marshaler.Unmarshal(c.StrVal, &v)
+		if err != nil {
+			t.Errorf("Should not get error when unmarshaling \"%s\". Error: %s", c.StrVal, err.Error())
+		}
+
+		if !reflect.DeepEqual(v, c.Expected) {
+			t.Errorf("Expect marshal of %v but received %v instead", c.Expected, v)
+		}
+	}
+}
+
+func TestUnmarshalBoolSlice(t *testing.T) {
+	marshaler := &DefaultParser{}
+	cases := []struct {
+		StrVal   string
+		Expected []bool
+	}{
+		{"1,0,1,1", []bool{true, false, true, true}},
+		{"true,false", []bool{true, false}},
+		{"1, FALSE, t,0", []bool{true, false, true, false}},
+		{"", []bool{}},
+	}
+
+	for _, c := range cases {
+		var v []bool
+		err := marshaler.Unmarshal(c.StrVal, &v)
+		if err != nil {
+			t.Errorf("Should not get error when unmarshaling \"%s\". Error: %s", c.StrVal, err.Error())
+		}
+
+		if !reflect.DeepEqual(v, c.Expected) {
+			t.Errorf("Expect marshal of %v but received %v instead", c.Expected, v)
+		}
+	}
+
+	var v []bool
+	if err := marshaler.Unmarshal("1,2", &v); err == nil {
+		t.Error("We expect parse to fail for a non-boolean element.")
+	}
+}
+
+type Toggle bool
+
+func TestUnmarshalNamedBool(t *testing.T) {
+	marshaler := &DefaultParser{}
+	cases := []struct {
+		StrVal   string
+		Expected Toggle
+	}{
+		{"true", Toggle(true)},
+		{"1", Toggle(true)},
+		{"FALSE", Toggle(false)},
+		{"0", Toggle(false)},
+	}
+
+	for _, c := range cases {
+		var v Toggle
+		err := marshaler.Unmarshal(c.StrVal, &v)
+		if err != nil {
+			t.Errorf("Should not get error when unmarshaling \"%s\" into Toggle.", c.StrVal)
+		}
+
+		if v != c.Expected {
+			t.Errorf("Expect marshal of %t but received %t instead", c.Expected, v)
+		}
+	}
+}
+
+func TestUnmarshalNamedBoolFail(t *testing.T) {
+	marshaler := &DefaultParser{}
+	var v Toggle
+	err := marshaler.Unmarshal("bogus", &v)
+	if err == nil {
+		t.Error("Should not be able to marshal \"bogus\" into Toggle.")
+	}
+}
+
+func TestUnmarshalMap(t *testing.T) {
+	marshaler := &DefaultParser{}
+	cases := []struct {
+		StrVal   string
+		Expected map[string]int
+	}{
+		{"a=1,b=2", map[string]int{"a": 1, "b": 2}},
+		{" a = 1 , b=-2 ", map[string]int{"a": 1, "b": -2}},
+		{"a=1,a=3", map[string]int{"a": 3}},
+		{"", map[string]int{}},
+	}
+
+	for _, c := range cases {
+		var v map[string]int
+		err := marshaler.Unmarshal(c.StrVal, &v)
+		if err != nil {
+			t.Errorf("Should not get error when unmarshaling \"%s\". Error: %s", c.StrVal, err.Error())
+		}
+
+		if !reflect.DeepEqual(v, c.Expected) {
+			t.Errorf("Expect marshal of %v but received %v instead", c.Expected, v)
+		}
+	}
+}
+
+func TestUnmarshalMapFail(t *testing.T) {
+	marshaler := &DefaultParser{}
+	cases := []string{
+		"a",
+		"a=1,b",
+		"a=x",
+		"1=1,",
+	}
+
+	for _, c := range cases {
+		var v map[string]int
+		err := marshaler.Unmarshal(c, &v)
+		if err == nil {
+			t.Errorf("Should not be able to marshal \"%s\" into map[string]int.", c)
+		}
+	}
+
+	var v map[int]string
+	if err := marshaler.Unmarshal("x=1", &v); err == nil {
+		t.Error("Should not be able to marshal \"x=1\" into map[int]string.")
+	}
+}
+
+func TestParseInto(t *testing.T) {
+	marshaler := DefaultParser{}
+
+	backing := make([]int, 2, 8)
+	dst := reflect.ValueOf(&backing).Elem()
+	if err := marshaler.ParseInto("1, 2, 3", dst); err != nil {
+		t.Fatalf("Should not get error when parsing into a slice. Error: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(backing, []int{1, 2, 3}) {
+		t.Errorf("Expected [1 2 3], actual %v", backing)
+	}
+	if cap(backing) != 8 {
+		t.Errorf("Expected the backing array to be reused, capacity is %d", cap(backing))
+	}
+
+	var arr [3]uint8
+	if err := marshaler.ParseInto("4,5,6", reflect.ValueOf(&arr).Elem()); err != nil {
+		t.Fatalf("Should not get error when parsing into an array. Error: %s", err.Error())
+	}
+	if arr != [3]uint8{4, 5, 6} {
+		t.Errorf("Expected [4 5 6], actual %v", arr)
+	}
+
+	existing := 3
+	ptr := &existing
+	if err := marshaler.ParseInto("7", reflect.ValueOf(&ptr).Elem()); err != nil {
+		t.Fatalf("Should not get error when parsing into a pointer. Error: %s", err.Error())
+	}
+	if ptr != &existing || existing != 7 {
+		t.Errorf("Expected the referenced value to be reused and set to 7, actual %d", *ptr)
+	}
+}
+
+func TestParseIntoFail(t *testing.T) {
+	marshaler := DefaultParser{}
+
+	if err := marshaler.ParseInto("1", reflect.ValueOf(1)); err == nil {
+		t.Error("We expect parsing into an unsettable value to fail.")
+	}
+
+	var arr [3]uint8
+	if err := marshaler.ParseInto("4,5", reflect.ValueOf(&arr).Elem()); err == nil {
+		t.Error("We expect parsing the wrong number of elements into an array to fail.")
+	}
+
+	var slice []uint8
+	if err := marshaler.ParseInto("4,256", reflect.ValueOf(&slice).Elem()); err == nil {
+		t.Error("We expect parsing an invalid element to fail.")
+	}
+}
+
+func largeSliceValue() string {
+	elts := make([]string, 10000)
+	for i := range elts {
+		elts[i] = fmt.Sprintf("%d", i)
+	}
+	return strings.Join(elts, ",")
+}
+
+func BenchmarkParseTypeLargeSlice(b *testing.B) {
+	marshaler := DefaultParser{}
+	str := largeSliceValue()
+	sliceType := reflect.TypeOf([]int{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := marshaler.ParseType(str, sliceType); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseIntoLargeSlice(b *testing.B) {
+	marshaler := DefaultParser{}
+	str := largeSliceValue()
+	dst := reflect.ValueOf(&[]int{}).Elem()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := marshaler.ParseInto(str, dst); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestUnmarshalOrderedMap(t *testing.T) {
+	marshaler := &DefaultParser{}
+	cases := []struct {
+		StrVal   string
+		Expected OrderedMap
+	}{
+		{"c=3,a=1,b=2", OrderedMap{{"c", "3"}, {"a", "1"}, {"b", "2"}}},
+		{"auth = jwt, gzip=, auth=basic", OrderedMap{{"auth", "jwt"}, {"gzip", ""}, {"auth", "basic"}}},
+		{"", OrderedMap{}},
+	}
+
+	for _, c := range cases {
+		var v OrderedMap
+		err := marshaler.Unmarshal(c.StrVal, &v)
+		if err != nil {
+			t.Errorf("Should not get error when unmarshaling \"%s\". Error: %s", c.StrVal, err.Error())
+		}
+
+		if !reflect.DeepEqual(v, c.Expected) {
+			t.Errorf("Expect marshal of %v but received %v instead", c.Expected, v)
+		}
+	}
+
+	var v OrderedMap
+	if err := marshaler.Unmarshal("c=3,a=1,c=4", &v); err != nil {
+		t.Fatalf("Should not get error when unmarshaling. Error: %s", err.Error())
+	}
+	if keys := v.Keys(); !reflect.DeepEqual(keys, []string{"c", "a", "c"}) {
+		t.Errorf("Expected keys [c a c], actual %v", keys)
+	}
+	if val, ok := v.Get("c"); !ok || val != "4" {
+		t.Errorf("Expected c=4, actual %s", val)
+	}
+	if _, ok := v.Get("d"); ok {
+		t.Error("Expected d to be missing")
+	}
+}
+
+func TestUnmarshalOrderedMapFail(t *testing.T) {
+	marshaler := &DefaultParser{}
+	var v OrderedMap
+	if err := marshaler.Unmarshal("a=1,b", &v); err == nil {
+		t.Error("Should not be able to marshal \"a=1,b\" into OrderedMap.")
+	}
+}
+
+func TestUnmarshalStringSet(t *testing.T) {
+	marshaler := &DefaultParser{}
+	cases := []struct {
+		StrVal   string
+		Expected StringSet
+	}{
+		{"admin,user,admin", StringSet{"admin": {}, "user": {}}},
+		{" user , admin,user ", StringSet{"admin": {}, "user": {}}},
+		{"admin", StringSet{"admin": {}}},
+		{"", StringSet{}},
+	}
+
+	for _, c := range cases {
+		var v StringSet
+		err := marshaler.Unmarshal(c.StrVal, &v)
+		if err != nil {
+			t.Errorf("Should not get error when unmarshaling \"%s\". Error: %s", c.StrVal, err.Error())
+		}
+
+		if !reflect.DeepEqual(v, c.Expected) {
+			t.Errorf("Expect marshal of %v but received %v instead", c.Expected, v)
+		}
+	}
+
+	var v StringSet
+	if err := (&DefaultParser{SliceSeparator: ";"}).Unmarshal("user;admin;user", &v); err != nil {
+		t.Fatalf("Should not get error when unmarshaling. Error: %s", err.Error())
+	}
+	if values := v.Values(); !reflect.DeepEqual(values, []string{"admin", "user"}) {
+		t.Errorf("Expected values [admin user], actual %v", values)
+	}
+	if !v.Contains("admin") || v.Contains("guest") {
+		t.Errorf("Unexpected membership of %v", v)
+	}
+}
+
+// Duration is a type that coincidentally shares its name with time.Duration
+type Duration int64
+
+func TestUnmarshalNamedDuration(t *testing.T) {
+	marshaler := &DefaultParser{}
+
+	var d Duration
+	if err := marshaler.Unmarshal("5", &d); err != nil {
+		t.Fatalf("Should not get error when unmarshaling \"5\". Error: %s", err.Error())
+	}
+	if d != 5 {
+		t.Errorf("Expected 5, actual %d", d)
+	}
+
+	if err := marshaler.Unmarshal("5s", &d); err == nil {
+		t.Error("Should not be able to marshal \"5s\" into a Duration that isn't time.Duration.")
+	}
+}
+
+func TestUnmarshalDurationOverflow(t *testing.T) {
+	marshaler := DefaultParser{}
+	cases := []string{
+		"9999999999h",
+		"-9999999999h",
+		"2562048h",
+	}
+
+	for _, c := range cases {
+		var d time.Duration
+		err := marshaler.Unmarshal(c, &d)
+		if errors.Cause(err) != ErrDurationOverflow {
+			t.Errorf("Expected \"%s\" to overflow, actual error %v", c, err)
+		}
+	}
+
+	syntaxCases := []string{
+		"9999999999hours",
+		"1h-30m",
+		"h",
+	}
+	for _, c := range syntaxCases {
+		var d time.Duration
+		err := marshaler.Unmarshal(c, &d)
+		if err == nil || errors.Cause(err) == ErrDurationOverflow {
+			t.Errorf("Expected \"%s\" to be a syntax error, actual error %v", c, err)
+		}
+	}
+}
+
+func TestUnmarshalDurationClamp(t *testing.T) {
+	marshaler := (&fieldOptions{clamp: true}).fieldParser(&DefaultParser{})
+	cases := []struct {
+		StrVal   string
+		Expected time.Duration
+	}{
+		{"9999999999h", time.Duration(math.MaxInt64)},
+		{"-9999999999h", time.Duration(math.MinInt64)},
+		{"12m", 12 * time.Minute},
+	}
+
+	for _, c := range cases {
+		var d time.Duration
+		if err := marshaler.Unmarshal(c.StrVal, &d); err != nil {
+			t.Errorf("Should not get error when unmarshaling \"%s\". Error: %s", c.StrVal, err.Error())
+		} else if d != c.Expected {
+			t.Errorf("Expected %s, received %s instead", c.Expected, d)
+		}
+	}
+
+	var d time.Duration
+	if err := marshaler.Unmarshal("99999999999hours", &d); err == nil {
+		t.Error("Expected clamping to not apply to syntax errors")
+	}
+}
+
+type JSONPoint struct {
+	X int
+	Y int
+}
+
+func (p *JSONPoint) UnmarshalJSON(b []byte) error {
+	point := struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	}{}
+	if err := json.Unmarshal(b, &point); err != nil {
+		return err
+	}
+	p.X, p.Y = point.X, point.Y
+	return nil
+}
+
+type TextLevel int
+
+func (l *TextLevel) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "debug":
+		*l = 0
+	case "info":
+		*l = 1
+	default:
+		return fmt.Errorf("unknown level %s", b)
+	}
+	return nil
+}
+
+// a type whose UnmarshalText should take precedence over UnmarshalJSON
+type TextAndJSON string
+
+func (v *TextAndJSON) UnmarshalText(b []byte) error {
+	*v = TextAndJSON("text:" + string(b))
+	return nil
+}
+
+func (v *TextAndJSON) UnmarshalJSON(b []byte) error {
+	*v = TextAndJSON("json:" + string(b))
+	return nil
+}
+
+func TestUnmarshalValueUnmarshalers(t *testing.T) {
+	marshaler := DefaultParser{}
+
+	var point JSONPoint
+	if err := marshaler.Unmarshal(`{"x": 1, "y": -2}`, &point); err != nil {
+		t.Errorf("Should not get error when unmarshaling JSON. Error: %s", err.Error())
+	} else if point != (JSONPoint{1, -2}) {
+		t.Errorf("Expected {1 -2}, actual %v", point)
+	}
+
+	var points []*JSONPoint
+	if err := marshaler.Unmarshal(`{"x": 1};{"y": 2}`, &points); err == nil {
+		t.Error("Expected an error for malformed JSON elements.")
+	}
+
+	var levels []TextLevel
+	if err := marshaler.Unmarshal("debug, info", &levels); err != nil {
+		t.Errorf("Should not get error when unmarshaling text. Error: %s", err.Error())
+	} else if !reflect.DeepEqual(levels, []TextLevel{0, 1}) {
+		t.Errorf("Expected [0 1], actual %v", levels)
+	}
+
+	var both TextAndJSON
+	if err := marshaler.Unmarshal("abc", &both); err != nil || both != "text:abc" {
+		t.Errorf("Expected UnmarshalText to take precedence, actual %s", both)
+	}
+}
+
+func TestUnmarshalValueUnmarshalersFail(t *testing.T) {
+	marshaler := DefaultParser{}
+
+	var point JSONPoint
+	if err := marshaler.Unmarshal(`{"x": "1"}`, &point); err == nil {
+		t.Error("Expected an error for invalid JSON.")
+	}
+
+	var level TextLevel
+	if err := marshaler.Unmarshal("verbose", &level); err == nil {
+		t.Error("Expected an error for invalid text.")
+	}
+}
+
+type Coordinates struct {
+	Lat, Long float64
+}
+
+func TestUnmarshalDecodeHooks(t *testing.T) {
+	coordinatesHook := func(from, to reflect.Type, data string) (interface{}, error) {
+		if to != reflect.TypeOf(Coordinates{}) {
+			return nil, nil
+		}
+
+		latLong := strings.Split(data, ",")
+		if len(latLong) != 2 {
+			return nil, fmt.Errorf("expected lat,long but received %s", data)
+		}
+		lat, err := strconv.ParseFloat(strings.TrimSpace(latLong[0]), 64)
+		if err != nil {
+			return nil, err
+		}
+		long, err := strconv.ParseFloat(strings.TrimSpace(latLong[1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		return Coordinates{lat, long}, nil
+	}
+	hooks := []string{}
+	tracingHook := func(from, to reflect.Type, data string) (interface{}, error) {
+		hooks = append(hooks, fmt.Sprintf("%s->%s", from, to))
+		return nil, nil
+	}
+	upperHook := func(from, to reflect.Type, data string) (interface{}, error) {
+		if to.Kind() != reflect.String {
+			return nil, nil
+		}
+		return strings.ToUpper(data), nil
+	}
+
+	marshaler := &DefaultParser{
+		SliceSeparator: ";",
+		DecodeHooks:    []DecodeHook{tracingHook, coordinatesHook, upperHook},
+	}
+
+	var coords []Coordinates
+	if err := marshaler.Unmarshal("43.65, -79.38; 51.5,-0.13", &coords); err != nil {
+		t.Fatalf("Should not get error when unmarshaling. Error: %s", err.Error())
+	}
+	expected := []Coordinates{{43.65, -79.38}, {51.5, -0.13}}
+	if !reflect.DeepEqual(coords, expected) {
+		t.Errorf("Expected %v, actual %v", expected, coords)
+	}
+
+	expectedHooks := []string{
+		"string->[]goenv.Coordinates",
+		"string->goenv.Coordinates",
+		"string->goenv.Coordinates",
+	}
+	if !reflect.DeepEqual(hooks, expectedHooks) {
+		t.Errorf("Expected hooks %v, actual %v", expectedHooks, hooks)
+	}
+
+	type Name string
+	var name Name
+	if err := marshaler.Unmarshal("toronto", &name); err != nil {
+		t.Fatalf("Should not get error when unmarshaling. Error: %s", err.Error())
+	}
+	if name != "TORONTO" {
+		t.Errorf("Expected TORONTO, actual %s", name)
+	}
+
+	var port int
+	if err := marshaler.Unmarshal("8080", &port); err != nil || port != 8080 {
+		t.Errorf("Expected the built-in parsing of 8080, actual %d (Error: %v)", port, err)
+	}
+}
+
+func TestUnmarshalDecodeHooksFail(t *testing.T) {
+	cases := []DecodeHook{
+		func(from, to reflect.Type, data string) (interface{}, error) {
+			return nil, fmt.Errorf("cannot decode %s", data)
+		},
+		func(from, to reflect.Type, data string) (interface{}, error) {
+			return []string{data}, nil
+		},
+	}
+
+	for i, hook := range cases {
+		marshaler := &DefaultParser{DecodeHooks: []DecodeHook{hook}}
+		var v int
+		if err := marshaler.Unmarshal("1", &v); err == nil {
+			t.Errorf("TC %d: Expecting an error from unmarshalling.", i)
+		}
+	}
+}
+
+func TestParseTimePointers(t *testing.T) {
+	marshaler := &DefaultParser{}
+
+	var timeout *time.Duration
+	if err := marshaler.Unmarshal("250ms", &timeout); err != nil {
+		t.Fatalf("Should not get error when unmarshaling. Error: %s", err.Error())
+	}
+	if timeout == nil || *timeout != 250*time.Millisecond {
+		t.Errorf("Expected 250ms, actual %v", timeout)
+	}
+
+	var deadline *time.Time
+	if err := marshaler.Unmarshal("2020-03-01T12:00:00+01:00", &deadline); err != nil {
+		t.Fatalf("Should not get error when unmarshaling. Error: %s", err.Error())
+	}
+	if deadline == nil || !deadline.Equal(time.Date(2020, 3, 1, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected 2020-03-01T11:00:00Z, actual %v", deadline)
+	}
+
+	if err := marshaler.Unmarshal("250", &timeout); err == nil {
+		t.Error("Should not be able to marshal \"250\" into a *time.Duration.")
+	}
+}
+
+func TestParseTimeLayouts(t *testing.T) {
+	cases := []struct {
+		Parser   *DefaultParser
+		StrVal   string
+		Expected time.Time
+	}{
+		{&DefaultParser{}, "2020-03-01T12:00:00Z", time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{&DefaultParser{}, "2020-03-01 12:30:15", time.Date(2020, 3, 1, 12, 30, 15, 0, time.UTC)},
+		{&DefaultParser{}, "2020-03-01", time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{&DefaultParser{}, "Sun, 01 Mar 2020 12:30:15 GMT", time.Date(2020, 3, 1, 12, 30, 15, 0, time.UTC)},
+		{&DefaultParser{}, "Sun, 01 Mar 2020 12:30:15 -0700", time.Date(2020, 3, 1, 19, 30, 15, 0, time.UTC)},
+		{&DefaultParser{}, "01 Mar 20 12:30 UTC", time.Date(2020, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{&DefaultParser{}, "01 Mar 20 12:30 +0200", time.Date(2020, 3, 1, 10, 30, 0, 0, time.UTC)},
+		{&DefaultParser{}, "Sun Mar  1 12:30:15 2020", time.Date(2020, 3, 1, 12, 30, 15, 0, time.UTC)},
+		{
+			&DefaultParser{TimeLayouts: []string{"02/01/2006", time.Kitchen}},
+			"01/03/2020",
+			time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			&DefaultParser{TimeLayouts: []string{"02/01/2006", time.Kitchen}},
+			"3:04PM",
+			time.Date(0, 1, 1, 15, 4, 0, 0, time.UTC),
+		},
+	}
+	for i, c := range cases {
+		var v time.Time
+		if err := c.Parser.Unmarshal(c.StrVal, &v); err != nil {
+			t.Errorf("TC %d: Should not get error when unmarshaling \"%s\". Error: %s", i, c.StrVal, err.Error())
+		}
+		if !v.Equal(c.Expected) {
+			t.Errorf("TC %d: Expected %v, actual %v", i, c.Expected, v)
+		}
+	}
+
+	parser := &DefaultParser{TimeLayouts: []string{"02/01/2006", time.Kitchen}}
+	var v time.Time
+	err := parser.Unmarshal("2020-03-01", &v)
+	if err == nil || !strings.Contains(err.Error(), `"02/01/2006" "3:04PM"`) {
+		t.Errorf("Expected an error listing the attempted layouts, actual %v", err)
+	}
+
+	vars, err := (&DefaultEnvMarshaler{Parser: parser}).Marshal(&struct {
+		Date time.Time `env:"DATE"`
+	}{Date: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil || vars["DATE"] != "01/03/2020" {
+		t.Errorf("Expected DATE to be formatted with the first layout, actual %v (Error: %v)", vars, err)
+	}
+}
+
+func TestParseSliceFunc(t *testing.T) {
+	cases := []struct {
+		Parser   *DefaultParser
+		StrVal   string
+		Expected []int
+	}{
+		{&DefaultParser{}, "1, 2,3", []int{1, 2, 3}},
+		{&DefaultParser{}, "42", []int{42}},
+		{&DefaultParser{}, "", []int{}},
+		{&DefaultParser{SliceSeparator: "::"}, "1::2::3", []int{1, 2, 3}},
+		{&DefaultParser{SliceSeparator: "\n"}, "1\n2\n\n", []int{1, 2}},
+	}
+
+	for i, c := range cases {
+		elts := []int{}
+		err := c.Parser.ParseSliceFunc(c.StrVal, reflect.TypeOf(0), func(elt reflect.Value) error {
+			elts = append(elts, int(elt.Int()))
+			return nil
+		})
+		if err != nil {
+			t.Errorf("TC %d: Should not get error when parsing \"%s\". Error: %s", i, c.StrVal, err.Error())
+		}
+		if !reflect.DeepEqual(elts, c.Expected) {
+			t.Errorf("TC %d: Expected %v, actual %v", i, c.Expected, elts)
+		}
+	}
+}
+
+func TestParseSliceFuncFail(t *testing.T) {
+	marshaler := &DefaultParser{}
+
+	calls := 0
+	err := marshaler.ParseSliceFunc("1,x,3", reflect.TypeOf(0), func(elt reflect.Value) error {
+		calls++
+		return nil
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Expected an error after 1 call, actual %d calls (Error: %v)", calls, err)
+	}
+
+	calls = 0
+	stop := errors.New("stop")
+	err = marshaler.ParseSliceFunc("1,2,3", reflect.TypeOf(0), func(elt reflect.Value) error {
+		calls++
+		if elt.Int() == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || calls != 2 {
+		t.Errorf("Expected the callback's error after 2 calls, actual %d calls (Error: %v)", calls, err)
+	}
+}
+
+type (
+	PortAlias    = int
+	PortDefined  int
+	NameAlias    = string
+	NameDefined  string
+	FlagAlias    = bool
+	FlagDefined  bool
+	HostsAlias   = []string
+	HostsDefined []string
+	PortsDefined []PortDefined
+)
+
+func TestUnmarshalAliasedAndDefinedTypes(t *testing.T) {
+	marshaler := &DefaultParser{}
+	cases := []struct {
+		StrVal   string
+		Dst      interface{}
+		Expected interface{}
+	}{
+		{"8080", new(PortAlias), 8080},
+		{"8080", new(PortDefined), PortDefined(8080)},
+		{" app ", new(NameAlias), "app"},
+		{" app ", new(NameDefined), NameDefined("app")},
+		{"true", new(FlagAlias), true},
+		{"1", new(FlagDefined), FlagDefined(true)},
+		{"a,b", new(HostsAlias), []string{"a", "b"}},
+		{"a,b", new(HostsDefined), HostsDefined{"a", "b"}},
+		{"80,443", new(PortsDefined), PortsDefined{80, 443}},
+	}
+
+	for i, c := range cases {
+		if err := marshaler.Unmarshal(c.StrVal, c.Dst); err != nil {
+			t.Errorf("TC %d: Should not get error when unmarshaling \"%s\". Error: %s", i, c.StrVal, err.Error())
+			continue
+		}
+
+		actual := reflect.ValueOf(c.Dst).Elem().Interface()
+		if !reflect.DeepEqual(actual, c.Expected) {
+			t.Errorf("TC %d: Expected %#v, actual %#v", i, c.Expected, actual)
+		}
+	}
+
+	failCases := []struct {
+		StrVal string
+		Dst    interface{}
+	}{
+		{"eighty", new(PortAlias)},
+		{"eighty", new(PortDefined)},
+		{"bogus", new(FlagAlias)},
+		{"bogus", new(FlagDefined)},
+		{"80,https", new(PortsDefined)},
+	}
+
+	for i, c := range failCases {
+		if err := marshaler.Unmarshal(c.StrVal, c.Dst); err == nil {
+			t.Errorf("TC %d: Should not be able to marshal \"%s\" into %T.", i, c.StrVal, c.Dst)
+		}
+	}
+}
+
+func TestUnmarshalNetip(t *testing.T) {
+	marshaler := &DefaultParser{}
+
+	addrPortCases := []struct {
+		StrVal   string
+		Expected netip.AddrPort
+	}{
+		{"127.0.0.1:8080", netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), 8080)},
+		{"[::1]:443", netip.AddrPortFrom(netip.IPv6Loopback(), 443)},
+		{" [fe80::1%eth0]:53 ", netip.AddrPortFrom(netip.MustParseAddr("fe80::1%eth0"), 53)},
+	}
+	for i, c := range addrPortCases {
+		var v netip.AddrPort
+		if err := marshaler.Unmarshal(c.StrVal, &v); err != nil {
+			t.Errorf("TC %d: Should not get error when unmarshaling \"%s\". Error: %s", i, c.StrVal, err.Error())
+		}
+		if v != c.Expected {
+			t.Errorf("TC %d: Expected %s, actual %s", i, c.Expected, v)
+		}
+	}
+
+	var addrs []netip.Addr
+	if err := marshaler.Unmarshal("10.0.0.1, ::1", &addrs); err != nil {
+		t.Fatalf("Should not get error when unmarshaling. Error: %s", err.Error())
+	}
+	expected := []netip.Addr{netip.AddrFrom4([4]byte{10, 0, 0, 1}), netip.IPv6Loopback()}
+	if !reflect.DeepEqual(addrs, expected) {
+		t.Errorf("Expected %v, actual %v", expected, addrs)
+	}
+
+	failCases := []struct {
+		StrVal string
+		Dst    interface{}
+	}{
+		{"127.0.0.1", new(netip.AddrPort)},
+		{"::1:443", new(netip.AddrPort)},
+		{"127.0.0.1:http", new(netip.AddrPort)},
+		{"127.0.0.1:65536", new(netip.AddrPort)},
+		{"", new(netip.AddrPort)},
+		{"", new(netip.Addr)},
+		{"256.0.0.1", new(netip.Addr)},
+		{"localhost", new(netip.Addr)},
+	}
+	for i, c := range failCases {
+		if err := marshaler.Unmarshal(c.StrVal, c.Dst); err == nil {
+			t.Errorf("TC %d: Should not be able to marshal \"%s\" into %T.", i, c.StrVal, c.Dst)
+		}
+	}
+}
+
+func TestUnmarshalURLValues(t *testing.T) {
+	marshaler := &DefaultParser{}
+
+	cases := []struct {
+		StrVal   string
+		Expected url.Values
+	}{
+		{"a=1&b=2&b=3", url.Values{"a": {"1"}, "b": {"2", "3"}}},
+		{"q=hello%20world&tag=a%2Cb&empty=", url.Values{"q": {"hello world"}, "tag": {"a,b"}, "empty": {""}}},
+		{"", url.Values{}},
+	}
+	for i, c := range cases {
+		var v url.Values
+		if err := marshaler.Unmarshal(c.StrVal, &v); err != nil {
+			t.Errorf("TC %d: Should not get error when unmarshaling \"%s\". Error: %s", i, c.StrVal, err.Error())
+		}
+		if !reflect.DeepEqual(v, c.Expected) {
+			t.Errorf("TC %d: Expected %v, actual %v", i, c.Expected, v)
+		}
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{"QUERY": "a=%zz"}},
+	}
+	obj := struct {
+		Query url.Values `env:"QUERY"`
+	}{}
+	err := marsh.Unmarshal(&obj)
+	if err == nil || !strings.Contains(err.Error(), "QUERY") {
+		t.Errorf("Expected an error naming the key QUERY, actual %v", err)
+	}
+}
+
+func TestUnmarshalBufferAndReader(t *testing.T) {
+	marshaler := &DefaultParser{}
+	payload := " {\"a\": 1}\n"
+
+	var buf *bytes.Buffer
+	if err := marshaler.Unmarshal(payload, &buf); err != nil {
+		t.Fatalf("Should not get error when unmarshaling. Error: %s", err.Error())
+	}
+	if buf == nil || buf.String() != payload {
+		t.Errorf("Expected the buffer to hold %q, actual %v", payload, buf)
+	}
+
+	var reader io.Reader
+	if err := marshaler.Unmarshal(payload, &reader); err != nil {
+		t.Fatalf("Should not get error when unmarshaling. Error: %s", err.Error())
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil || string(data) != payload {
+		t.Errorf("Expected the reader to yield %q, actual %q (Error: %v)", payload, data, err)
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{"PAYLOAD": payload}},
+	}
+	obj := struct {
+		Buffer *bytes.Buffer `env:"PAYLOAD"`
+		Reader io.Reader     `env:"PAYLOAD"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if obj.Buffer == nil || obj.Buffer.String() != payload {
+		t.Errorf("Expected the buffer field to hold %q, actual %v", payload, obj.Buffer)
+	}
+	if data, _ := io.ReadAll(obj.Reader); string(data) != payload {
+		t.Errorf("Expected the reader field to yield %q, actual %q", payload, data)
+	}
+
+	vars, err := marsh.Marshal(&struct {
+		Buffer *bytes.Buffer `env:"PAYLOAD"`
+	}{Buffer: bytes.NewBufferString(payload)})
+	if err != nil || vars["PAYLOAD"] != payload {
+		t.Errorf("Expected PAYLOAD=%q, actual %v (Error: %v)", payload, vars, err)
+	}
+}
+
+type testPerm uint8
+
+func (testPerm) BitValues() map[string]int {
+	return map[string]int{"read": 4, "write": 2, "exec": 1}
+}
+
+func TestParseBits(t *testing.T) {
+	marshaler := &DefaultParser{}
+
+	var perm testPerm
+	if err := marshaler.Unmarshal("read, write,exec", &perm); err != nil {
+		t.Fatalf("Should not get error when unmarshaling. Error: %s", err.Error())
+	}
+	if perm != 7 {
+		t.Errorf("Expected a mask of 7, actual %d", perm)
+	}
+
+	if err := marshaler.Unmarshal("", &perm); err != nil || perm != 0 {
+		t.Errorf("Expected an empty mask, actual %d (Error: %v)", perm, err)
+	}
+
+	err := marshaler.Unmarshal("read,delete", &perm)
+	if err == nil || !strings.Contains(err.Error(), "unknown bit delete, expected one of exec, read, write") {
+		t.Errorf("Expected an error for an unknown bit, actual %v", err)
+	}
+
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{"PERMS": "write|read"}},
+	}
+	obj := struct {
+		Perms testPerm `env:"PERMS,sep=|"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if obj.Perms != 6 {
+		t.Errorf("Expected a mask of 6, actual %d", obj.Perms)
+	}
+
+	vars, err := marsh.Marshal(&obj)
+	if err != nil || vars["PERMS"] != "read|write" {
+		t.Errorf("Expected PERMS=read|write, actual %v (Error: %v)", vars, err)
+	}
+	if _, err := marsh.Marshal(&struct {
+		Perms testPerm `env:"PERMS"`
+	}{Perms: 8}); err == nil {
+		t.Error("Expecting an error from marshalling bits without names.")
+	}
+}
+
+func TestParseRegexp(t *testing.T) {
+	marsh := DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{"FILTER": `^/api/v\d+/`}},
+	}
+	obj := struct {
+		Filter *regexp.Regexp `env:"FILTER"`
+	}{}
+	if err := marsh.Unmarshal(&obj); err != nil {
+		t.Fatalf("Unmarshal should not raise error. Error: %s", err.Error())
+	}
+	if obj.Filter == nil || !obj.Filter.MatchString("/api/v2/users") || obj.Filter.MatchString("/static/") {
+		t.Errorf("Unexpected regexp %v", obj.Filter)
+	}
+
+	vars, err := marsh.Marshal(&obj)
+	if err != nil || vars["FILTER"] != `^/api/v\d+/` {
+		t.Errorf("Expected FILTER=%s, actual %v (Error: %v)", `^/api/v\d+/`, vars, err)
+	}
+
+	marsh = DefaultEnvMarshaler{
+		Environment: &MockEnvReader{map[string]string{"FILTER": "^(api"}},
+	}
+	err = marsh.Unmarshal(&obj)
+	if err == nil || !strings.Contains(err.Error(), "Cannot compile ^(api as a regexp") ||
+		!strings.Contains(err.Error(), "(Env: FILTER)") {
+		t.Errorf("Expected an error naming the pattern and key, actual %v", err)
+	}
+}